@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -73,25 +74,35 @@ func TestCLIExtractionAndListing(t *testing.T) {
 		args []string
 		want string
 	}{
-		{"simple path", []string{"database.host", "test/simple.yml"}, "database:\n    host: localhost\n"},
+		{"simple path", []string{"database.host", "test/simple.yml"}, "database:\n  host: localhost\n"},
 		{"trim mode", []string{"-t", "database.port", "test/simple.yml"}, "5432\n"},
 		{"nested mapping", []string{"database.credentials", "test/simple.yml"},
-			"database:\n    credentials:\n        user: admin\n        password: secret123\n"},
-		{"array index wrapped", []string{"users[0].name", "test/arrays.yml"}, "users:\n    - name: Alice\n"},
+			"database:\n  credentials:\n    user: admin\n    password: secret123\n"},
+		{"array index wrapped", []string{"users[0].name", "test/arrays.yml"}, "users:\n  - name: Alice\n"},
 		{"array trim", []string{"-t", "users[1].email", "test/arrays.yml"}, "bob@example.com\n"},
+		{"array index wrapped alone", []string{"users[1].name", "test/arrays.yml"}, "users:\n  - name: Bob\n"},
+		{"--null-fill keeps the index", []string{"--null-fill", "users[1].name", "test/arrays.yml"}, "users:\n  - null\n  - name: Bob\n"},
 		{"nested array trim", []string{"-t", "users[0].roles[0]", "test/arrays.yml"}, "admin\n"},
+		{"negative index trim", []string{"-t", "users[-1].name", "test/arrays.yml"}, "Charlie\n"},
+		{"negative index wrapped", []string{"users[-1].roles[-1]", "test/arrays.yml"}, "users:\n  - roles:\n      - moderator\n"},
 		{"list mode default depth", []string{"-l", "database", "test/simple.yml"}, "host\nport\ntimeout\ncredentials\n"},
+		{"list mode with values", []string{"-l", "--values", "database", "test/simple.yml"}, "host: localhost\nport: 5432\ntimeout: 30\ncredentials\n"},
+		{"list mode with types", []string{"-l", "--types", "spec.template.spec.containers", "test/kubernetes.yml"}, "[0] (map[6])\n"},
+		{"list mode with values and types", []string{"-l", "--values", "--types", "database", "test/simple.yml"},
+			"host: localhost (str)\nport: 5432 (int)\ntimeout: 30 (int)\ncredentials (map[2])\n"},
 		{"list mode with depth", []string{"-l", "--depth", "2", "modules", "test/snmp.yml"},
 			"if_mib\n  walk\n  metrics\n  lookups\nsystem_mib\n  walk\n  metrics\nbgp_mib\n  walk\n  metrics\n"},
+		{"tree mode", []string{"--tree", "--depth", "2", "modules", "test/snmp.yml"},
+			"├── if_mib\n│   ├── walk\n│   ├── metrics\n│   └── lookups\n├── system_mib\n│   ├── walk\n│   └── metrics\n└── bgp_mib\n    ├── walk\n    └── metrics\n"},
 		{"deep snmp walk", []string{"-t", "modules.if_mib.walk[0]", "test/snmp.yml"}, "1.3.6.1.2.1.2.2.1.1\n"},
 		{"kubernetes container name", []string{"spec.template.spec.containers[0].name", "test/kubernetes.yml"},
-			"spec:\n    template:\n        spec:\n            containers:\n                - name: nginx\n"},
+			"spec:\n  template:\n    spec:\n      containers:\n        - name: nginx\n"},
 		{"kubernetes env value trim", []string{"-t", "spec.template.spec.containers[0].env[0].value", "test/kubernetes.yml"}, "example.com\n"},
 		{"numbers preserve formatting", []string{"numbers", "test/types.yml"},
-			"numbers:\n    integer: 42\n    float: 3.14159\n    negative: -100\n    scientific: 1.23e-4\n    octal: 0o755\n    hex: 0xFF\n"},
+			"numbers:\n  integer: 42\n  float: 3.14159\n  negative: -100\n  scientific: 1.23e-4\n  octal: 0o755\n  hex: 0xFF\n"},
 		{"numeric-looking key", []string{"-t", "special_keys.1", "test/types.yml"}, "\"numeric key\"\n"},
 		{"booleans preserve yaml 1.1 forms", []string{"booleans", "test/types.yml"},
-			"booleans:\n    true_value: true\n    false_value: false\n    yes_value: yes\n    no_value: no\n"},
+			"booleans:\n  true_value: true\n  false_value: false\n  yes_value: yes\n  no_value: no\n"},
 		{"ansible vars by document index", []string{"-t", "[0].vars.app_name", "test/ansible.yml"}, "myapp\n"},
 		{"ansible task name by index", []string{"-t", "[0].tasks[0].name", "test/ansible.yml"}, "Create application user\n"},
 		{"ansible nested loop item", []string{"-t", "[0].tasks[7].loop[1]", "test/ansible.yml"}, "80\n"},
@@ -121,9 +132,19 @@ func TestCLIRealWorldFixtures(t *testing.T) {
 		{"docker-compose service list", []string{"-l", "--depth", "1", "services", "test/docker-compose.yml"}, "web\napp\ndb\ncache\n"},
 		{"github actions matrix entry", []string{"-t", "jobs.build.strategy.matrix.go-version[1]", "test/github-actions.yml"}, "\"1.21\"\n"},
 		{"helm ingress host", []string{"ingress.hosts[0].host", "test/helm-values.yml"},
-			"ingress:\n    hosts:\n        - host: app.example.com\n"},
+			"ingress:\n  hosts:\n    - host: app.example.com\n"},
 		{"helm resource limit", []string{"-t", "resources.limits.memory", "test/helm-values.yml"}, "512Mi\n"},
+		{"github actions last matrix entry", []string{"-t", "jobs.build.strategy.matrix.go-version[-1]", "test/github-actions.yml"}, "\"1.22\"\n"},
+		{"github actions on trigger", []string{".on.push", "test/github-actions.yml"}, "on:\n  push:\n    branches: [main]\n"},
+		{"github actions on trigger as true", []string{"-t", "true.pull_request.branches[0]", "test/github-actions.yml"}, "main\n"},
+		{"kubernetes label with dots via quoting", []string{"metadata.labels.\"app.kubernetes.io/name\"", "test/kubernetes.yml"},
+			"metadata:\n  labels:\n    app.kubernetes.io/name: nginx\n"},
+		{"escaped dots in a key", []string{"-t", `metadata.labels.app\.kubernetes\.io/name`, "test/kubernetes.yml"}, "nginx\n"},
+		{"bracketed string key", []string{"-t", `metadata.labels["app.kubernetes.io/name"]`, "test/kubernetes.yml"}, "nginx\n"},
+		{"quoted dotted key trim", []string{"-t", "metadata.labels.'app.kubernetes.io/name'", "test/kubernetes.yml"}, "nginx\n"},
 		{"prometheus second scrape job", []string{"-t", "scrape_configs[1].job_name", "test/prometheus.yml"}, "kubernetes-pods\n"},
+		{"kubernetes container image by name", []string{"-t", ".spec.template.spec.containers[name=nginx].image", "test/kubernetes.yml"}, "nginx:1.21\n"},
+		{"kubernetes container env var by name", []string{"-r", "spec.template.spec.containers[name=nginx].env[name=NGINX_HOST].value", "test/kubernetes.yml"}, "example.com\n"},
 	}
 
 	for _, tc := range cases {
@@ -139,101 +160,2335 @@ func TestCLIRealWorldFixtures(t *testing.T) {
 	}
 }
 
+func TestCLIWildcard(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"wildcard wraps every match in its real path", []string{"services.*.image", "test/docker-compose.yml"},
+			"services:\n  web:\n    image: nginx:1.25-alpine\n  db:\n    image: postgres:16\n" +
+				"  cache:\n    image: redis:7-alpine\n"},
+		{"wildcard trim prints one scalar per line", []string{"-t", "services.*.image", "test/docker-compose.yml"},
+			"nginx:1.25-alpine\npostgres:16\nredis:7-alpine\n"},
+		{"wildcard as the first segment", []string{"-t", "*.credentials", "test/simple.yml"},
+			"user: admin\npassword: secret123\n"},
+		{"trailing wildcard matches every value", []string{"-t", "app.*", "test/simple.yml"},
+			"MyApp\n1.2.3\nfalse\n"},
+		{"sequence wildcard trim", []string{"-t", "users[*].name", "test/arrays.yml"}, "Alice\nBob\nCharlie\n"},
+		{"sequence wildcard wrapped", []string{"spec.template.spec.containers[*].name", "test/kubernetes.yml"},
+			"spec:\n  template:\n    spec:\n      containers:\n        - name: nginx\n"},
+		{"recursive descent trim", []string{"-t", "..name", "test/arrays.yml"}, "Alice\nBob\nCharlie\n"},
+		{"recursive descent wrapped", []string{"..condition", "test/docker-compose.yml"},
+			"services:\n  app:\n    depends_on:\n      db:\n        condition: service_healthy\n" +
+				"      cache:\n        condition: service_started\n"},
+		{"recursive descent across documents", []string{"-t", "..port", "test/multi-doc.yml"}, "80\n"},
+		{"recursive descent into sequences", []string{"..image", "test/kubernetes.yml"},
+			"spec:\n  template:\n    spec:\n      containers:\n        - image: nginx:1.21\n"},
+		{"slice trim", []string{"-t", "users[1:]", "test/arrays.yml"},
+			"- id: 2\n  name: Bob\n  email: bob@example.com\n  roles:\n    - user\n  active: false\n" +
+				"- id: 3\n  name: Charlie\n  email: charlie@example.com\n  roles:\n    - user\n    - moderator\n  active: true\n"},
+		{"slice wrapped", []string{"users[0].roles[:1]", "test/arrays.yml"}, "users:\n  - roles:\n      - admin\n"},
+		{"negative slice bound", []string{"-t", "users[-2:].name", "test/arrays.yml"}, "Bob\nCharlie\n"},
+		{"[last] index keyword", []string{"-t", "users[last].name", "test/arrays.yml"}, "Charlie\n"},
+		{"anchor reference", []string{"-t", "&defaults.adapter", "test/anchors.yml"}, "postgres\n"},
+		{"glob within a key", []string{"-t", "services.d*.image", "test/docker-compose.yml"}, "postgres:16\n"},
+		{"^ steps back to the parent", []string{"-t", "users[name=Bob].^[0].name", "test/arrays.yml"}, "Alice\n"},
+		{"[first] index keyword wraps the real element", []string{"users[first].name", "test/arrays.yml"}, "users:\n  - name: Alice\n"},
+		{"index list keeps the listed order", []string{"-t", "users[2,0].name", "test/arrays.yml"}, "Charlie\nAlice\n"},
+		{"index list skips out-of-range entries", []string{"-t", "users[0].roles[1,5]", "test/arrays.yml"}, "- user\n"},
+		{"slice then field", []string{"-t", "users[:2].name", "test/arrays.yml"}, "Alice\nBob\n"},
+		{"filter by field equality", []string{"-t", `users[?(.name=="Bob")].email`, "test/arrays.yml"}, "bob@example.com\n"},
+		{"filter wrapped keeps the real element", []string{`users[?(.name!="Bob")].name`, "test/arrays.yml"},
+			"users:\n  - name: Alice\n  - name: Charlie\n"},
+		{"regex key match", []string{"-t", "metadata.~^(name|namespace)$", "test/kubernetes.yml"}, "nginx-deployment\nproduction\n"},
+		{"select by value", []string{"-t", "users[name=Bob].email", "test/arrays.yml"}, "bob@example.com\n"},
+		{"select by value returns every match in order", []string{"-t", "users[active=true].name", "test/arrays.yml"}, "Alice\nCharlie\n"},
+		{"filter by nested sequence value", []string{"-t", `users[?(.roles[*]==moderator)].name`, "test/arrays.yml"}, "Charlie\n"},
+		{"nested sequence wildcards", []string{"-t", "users[*].roles[*]", "test/arrays.yml"},
+			"admin\nuser\nuser\nuser\nmoderator\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("collections from multiple matches are separated by document markers", func(t *testing.T) {
+		res := runCLI(t, "a:\n  x:\n    k: 1\n  y:\n    k: 2\n", "-t", "a.*")
+		want := "k: 1\n---\nk: 2\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("list mode heads each match with its path", func(t *testing.T) {
+		res := runCLI(t, "", "-l", "services.*.healthcheck", "test/docker-compose.yml")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "test\ninterval\ntimeout\nretries\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q (a single match lists like a plain path)", res.stdout, want)
+		}
+
+		res = runCLI(t, "", "-l", "*", "test/simple.yml")
+		want = "app\n  name\n  version\n  debug\ndatabase\n  host\n  port\n  timeout\n  credentials\n" +
+			"cache\n  enabled\n  ttl\n"
+		if res.stdout != want {
+			t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, want)
+		}
+	})
+
+	t.Run("wildcard with no matches exits 1", func(t *testing.T) {
+		res := runCLI(t, "", "services.*.nope", "test/docker-compose.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if res.stderr != "Path not found: services.*.nope\n" {
+			t.Errorf("stderr = %q, want %q", res.stderr, "Path not found: services.*.nope\n")
+		}
+	})
+}
+
+func TestCLIMultiDocument(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"pattern runs against every document", []string{"-t", "metadata.name", "test/multi-doc.yml"},
+			"web\n---\nweb-svc\n---\nweb-config\n"},
+		{"documents without a match are skipped", []string{"spec.replicas", "test/multi-doc.yml"},
+			"spec:\n  replicas: 3\n"},
+		{"wrapped results are separated by document markers", []string{"kind", "test/multi-doc.yml"},
+			"kind: Deployment\n---\nkind: Service\n---\nkind: ConfigMap\n"},
+		{"--doc selects a single document", []string{"--doc", "1", "-t", "metadata.name", "test/multi-doc.yml"},
+			"web-svc\n"},
+		{"list mode runs against every document", []string{"-l", "data", "test/multi-doc.yml"}, "LOG_LEVEL\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("no match in any document exits 1", func(t *testing.T) {
+		res := runCLI(t, "", "spec.nope", "test/multi-doc.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if res.stderr != "Path not found: spec.nope\n" {
+			t.Errorf("stderr = %q, want %q", res.stderr, "Path not found: spec.nope\n")
+		}
+	})
+
+	t.Run("--doc past the last document is a usage error", func(t *testing.T) {
+		res := runCLI(t, "", "--doc", "3", "kind", "test/multi-doc.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		want := "Error: --doc 3 out of range (input has 3 document(s))\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("--doc does not search other documents", func(t *testing.T) {
+		res := runCLI(t, "", "--doc", "0", "data", "test/multi-doc.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+	})
+}
+
 func TestCLIFlowAndBlockStyle(t *testing.T) {
 	t.Run("extracting from a JSON source preserves JSON's flow style", func(t *testing.T) {
 		res := runCLI(t, "", "database.host", "test/config.json")
 		if res.exitCode != 0 {
 			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
 		}
-		want := "{\"database\": {\"host\": \"localhost\"}}\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		want := "{\"database\": {\"host\": \"localhost\"}}\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("extracting an array element from JSON preserves flow style", func(t *testing.T) {
+		res := runCLI(t, "", "services[1]", "test/config.json")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "{\"services\": [\"api\"]}\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("--flow forces flow-style output on a block YAML source", func(t *testing.T) {
+		res := runCLI(t, "", "--flow", "database", "test/simple.yml")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "{database: {host: localhost, port: 5432, timeout: 30, credentials: {user: admin, password: secret123}}}\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("--flow keeps each match on one line", func(t *testing.T) {
+		const input = "# head\nweb: # the web tier\n  name: web # n\n  note: |\n    line one\n    line two\n  ports:\n    # first\n    - 80\n"
+		for _, tt := range []struct {
+			args []string
+			want string
+		}{
+			{[]string{"--flow", "web"}, "{web: {name: web, note: \"line one\\nline two\\n\", ports: [80]}}\n"},
+			{[]string{"--flow", "-t", "web.note"}, "\"line one\\nline two\\n\"\n"},
+			{[]string{"--flow", "--style", "folded", "-t", "web.name,web.ports"}, "\"web\"\n---\n[80]\n"},
+		} {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != 0 || res.stdout != tt.want {
+				t.Errorf("%v: exit code = %d, stdout = %q, want 0 and %q; stderr=%q", tt.args, res.exitCode, res.stdout, tt.want, res.stderr)
+			}
+		}
+	})
+
+	t.Run("--block forces clean block-style output on a JSON source, no leftover quotes", func(t *testing.T) {
+		res := runCLI(t, "", "--block", "database", "test/config.json")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "database:\n  host: localhost\n  port: 5432\n  credentials:\n" +
+			"    user: admin\n    password: secret123\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("-j short flag behaves like --flow", func(t *testing.T) {
+		res := runCLI(t, "", "-j", "database", "test/simple.yml")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "{database: {host: localhost, port: 5432, timeout: 30, credentials: {user: admin, password: secret123}}}\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("-y short flag behaves like --block", func(t *testing.T) {
+		res := runCLI(t, "", "-y", "database", "test/config.json")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		want := "database:\n  host: localhost\n  port: 5432\n  credentials:\n" +
+			"    user: admin\n    password: secret123\n"
+		if res.stdout != want {
+			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		}
+	})
+
+	t.Run("--yaml-indent sets the indentation", func(t *testing.T) {
+		res := runCLI(t, "", "--yaml-indent", "4", "users[0].roles", "test/arrays.yml")
+		want := "users:\n    - roles:\n        - admin\n        - user\n"
+		if res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
+		}
+	})
+
+	t.Run("--yaml-indent applies to --set output", func(t *testing.T) {
+		res := runCLI(t, "a:\n  b: 1\n", "--yaml-indent", "3", "--set", "a.b=2")
+		if want := "a:\n   b: 2\n"; res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
+		}
+	})
+
+	t.Run("--yaml-indent out of range is a usage error", func(t *testing.T) {
+		res := runCLI(t, "a: 1\n", "--yaml-indent", "1", "a")
+		if want := "Error: --yaml-indent must be between 2 and 9, got 1\n"; res.exitCode != 2 || res.stderr != want {
+			t.Errorf("exit code = %d, stderr = %q, want 2 and %q", res.exitCode, res.stderr, want)
+		}
+		for _, n := range []string{"0", "-2", "10"} {
+			res := runCLI(t, "a: 1\n", "--indent", n, "a")
+			if want := "Error: --indent must be between 2 and 9, got " + n + "\n"; res.exitCode != 2 || res.stderr != want {
+				t.Errorf("--indent %s: exit code = %d, stderr = %q, want 2 and %q", n, res.exitCode, res.stderr, want)
+			}
+		}
+	})
+
+	t.Run("--indent and --seq-indent apply to wrapped, trimmed and edited output", func(t *testing.T) {
+		const input = "spec:\n  ports:\n    - 80\n    - 443\n"
+		for _, tt := range []struct {
+			args []string
+			want string
+		}{
+			{[]string{"--indent", "4", "spec"}, "spec:\n    ports:\n        - 80\n        - 443\n"},
+			{[]string{"--indent", "4", "--seq-indent", "flush", "spec"}, "spec:\n    ports:\n    - 80\n    - 443\n"},
+			{[]string{"--indent", "4", "--seq-indent", "flush", "-t", "spec"}, "ports:\n- 80\n- 443\n"},
+			{[]string{"--seq-indent", "flush", "--set", "spec.ports[0]=8080"}, "spec:\n  ports:\n  - 8080\n  - 443\n"},
+		} {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != 0 || res.stdout != tt.want {
+				t.Errorf("%v: exit code = %d, stdout = %q, want 0 and %q; stderr=%q", tt.args, res.exitCode, res.stdout, tt.want, res.stderr)
+			}
+		}
+		if res := runCLI(t, input, "--seq-indent", "none", "spec"); res.exitCode != 2 {
+			t.Errorf("--seq-indent none: exit code = %d, want 2", res.exitCode)
+		}
+	})
+
+	t.Run("--null-fill with --trim is a usage error", func(t *testing.T) {
+		res := runCLI(t, "", "--null-fill", "-t", "users[1].name", "test/arrays.yml")
+		if res.exitCode != 2 || res.stdout != "" {
+			t.Errorf("got exit %d, stdout %q; want exit 2 and no output", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("--flow and --block together is a usage error on stderr", func(t *testing.T) {
+		res := runCLI(t, "", "--flow", "--block", "database", "test/simple.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		if res.stdout != "" {
+			t.Errorf("stdout = %q, want empty", res.stdout)
+		}
+		want := "Error: --flow/-j and --block/-y are mutually exclusive\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("mixing the long and short forms is still an error", func(t *testing.T) {
+		res := runCLI(t, "", "-j", "-y", "database", "test/simple.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		want := "Error: --flow/-j and --block/-y are mutually exclusive\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+}
+
+func TestCLIJSONOutput(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"wrapped path as pretty JSON", []string{"--json", "database.credentials", "test/simple.yml"},
+			"{\n  \"database\": {\n    \"credentials\": {\n      \"user\": \"admin\",\n      \"password\": \"secret123\"\n    }\n  }\n}\n"},
+		{"trimmed scalar keeps its type", []string{"--json", "-t", "database.port", "test/simple.yml"}, "5432\n"},
+		{"compact output", []string{"--json", "--json-indent", "0", "-t", "numbers", "test/types.yml"},
+			`{"integer":42,"float":3.14159,"negative":-100,"scientific":1.23e-4,"octal":493,"hex":255}` + "\n"},
+		{"multiple trimmed matches become an array", []string{"--json", "--json-indent", "0", "-t", "users[*].active", "test/arrays.yml"},
+			"[true,false,true]\n"},
+		{"one value per document", []string{"--json", "-t", "kind", "test/multi-doc.yml"},
+			"\"Deployment\"\n\"Service\"\n\"ConfigMap\"\n"},
+		{"--ndjson puts each match on its own line", []string{"--ndjson", "users[*].roles", "test/arrays.yml"},
+			"[\"admin\",\"user\"]\n[\"user\"]\n[\"user\",\"moderator\"]\n"},
+		{"--ndjson is compact even for collections", []string{"--ndjson", "database.credentials", "test/simple.yml"},
+			"{\"user\":\"admin\",\"password\":\"secret123\"}\n"},
+		{"--ndjson across documents", []string{"--ndjson", "kind", "test/multi-doc.yml"},
+			"\"Deployment\"\n\"Service\"\n\"ConfigMap\"\n"},
+		{"--output json is --json", []string{"--output", "json", "-t", "database.port", "test/simple.yml"}, "5432\n"},
+		{"--sort-keys sorts objects", []string{"--json", "--json-indent", "0", "--sort-keys", "database.credentials", "test/simple.yml"},
+			"{\"database\":{\"credentials\":{\"password\":\"secret123\",\"user\":\"admin\"}}}\n"},
+		{"merge keys are resolved like paths", []string{"--json", "--json-indent", "0", "-t", "production", "test/anchors.yml"},
+			`{"adapter":"postgres","host":"localhost","database":"myapp_production","pool":25}` + "\n"},
+		{"--no-merge keeps them", []string{"--json", "--json-indent", "0", "--no-merge", "-t", "production", "test/anchors.yml"},
+			`{"<<":{"adapter":"postgres","host":"localhost","pool":5},"database":"myapp_production","pool":25}` + "\n"},
+		{"--output ndjson is --ndjson", []string{"--output", "ndjson", "kind", "test/multi-doc.yml"},
+			"\"Deployment\"\n\"Service\"\n\"ConfigMap\"\n"},
+		{"--ndjson with --labels is a line per document", []string{"--ndjson", "--labels", "-f", "test/multi-doc.yml", "kind", "metadata.name"},
+			"{\"kind\":\"Deployment\",\"metadata.name\":\"web\"}\n{\"kind\":\"Service\",\"metadata.name\":\"web-svc\"}\n{\"kind\":\"ConfigMap\",\"metadata.name\":\"web-config\"}\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+}
+
+func TestCLIRawOutput(t *testing.T) {
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"quoted scalar is printed bare", "version: \"1.0\"\n", []string{"-r", "version"}, "1.0\n"},
+		{"long flag", "", []string{"--raw", "app.version", "test/simple.yml"}, "1.2.3\n"},
+		{"-n drops the trailing newline", "version: \"1.0\"\n", []string{"-r", "-n", "version"}, "1.0"},
+		{"-n only drops the last newline", "", []string{"-r", "-n", "users[*].name", "test/arrays.yml"}, "Alice\nBob\nCharlie"},
+		{"-n across documents", "", []string{"-r", "-n", "kind", "test/multi-doc.yml"}, "Deployment\n---\nService\n---\nConfigMap"},
+		{"multi-line strings are not re-encoded", "msg: |\n  line one\n  line two\n", []string{"-r", "msg"}, "line one\nline two\n\n"},
+		{"collections fall back to YAML", "", []string{"-r", "users[0].roles", "test/arrays.yml"}, "- admin\n- user\n"},
+		{"--raw-strict prints scalars bare", "", []string{"--raw-strict", "users[*].name", "test/arrays.yml"}, "Alice\nBob\nCharlie\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("--raw-strict refuses a collection", func(t *testing.T) {
+		res := runCLI(t, "", "--raw-strict", "users[0].roles", "test/arrays.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if res.stdout != "" || res.stderr != "Error: --raw-strict needs a scalar, but users[0].roles is a sequence\n" {
+			t.Errorf("stdout=%q stderr=%q", res.stdout, res.stderr)
+		}
+	})
+}
+
+func TestCLIColor(t *testing.T) {
+	const input = "name: web # the app\nreplicas: 3\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"auto doesn't color a pipe", []string{"--color", "auto", "name"}, "name: web # the app\n"},
+		{"always colors YAML output", []string{"--color", "always", "name"},
+			"\x1b[34mname\x1b[0m: \x1b[32mweb\x1b[0m \x1b[90m# the app\x1b[0m\n"},
+		{"always colors a number as one", []string{"--color", "always", "replicas"},
+			"\x1b[34mreplicas\x1b[0m: \x1b[36m3\x1b[0m\n"},
+		{"always colors list mode", []string{"--color", "always", "-l", "--values", "--types"},
+			"\x1b[34mname\x1b[0m: \x1b[32mweb\x1b[0m \x1b[33m(str)\x1b[0m\n\x1b[34mreplicas\x1b[0m: \x1b[32m3\x1b[0m \x1b[33m(int)\x1b[0m\n"},
+		{"never", []string{"--color", "never", "-t", "replicas"}, "3\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("an unknown mode is a usage error", func(t *testing.T) {
+		res := runCLI(t, input, "--color", "sometimes")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+	})
+}
+
+func TestCLIIgnoreCase(t *testing.T) {
+	const input = "Name: A\nname: b\nMeta: {Tier: web}\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"keys keep their casing", []string{"--ignore-case", "meta.tier"}, "Meta: {Tier: web}\n"},
+		{"every case variant is reported", []string{"--ci", "-t", "NAME"}, "A\nb\n"},
+		{"an exact-case key wins for a single path", []string{"--ci", "-t", "name"}, "b\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("not with an edit", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--ci", "--set", "meta.tier=api"},
+			{"--ignore-case", "-d", "meta.tier"},
+			{"--ci", "--merge", "meta", "/dev/null"},
+		} {
+			res := runCLI(t, input, args...)
+			if res.exitCode != 2 || res.stdout != "" {
+				t.Errorf("%q: exit code = %d, stdout = %q; want 2 and nothing", args, res.exitCode, res.stdout)
+			}
+		}
+	})
+}
+
+func TestCLIMergeKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"a merged key is found under the merging mapping", []string{"-t", "development.adapter", "test/anchors.yml"}, "postgres\n"},
+		{"the mapping's own key wins over the merged one", []string{"-t", "production.pool", "test/anchors.yml"}, "25\n"},
+		{"wildcards fan out over merged keys", []string{"--paths", "*.host", "test/anchors.yml"}, "defaults.host\ndevelopment.host\ntest.host\nproduction.host\n"},
+		{"an alias prints the node it refers to", []string{"-t", "deployment.active_regions", "test/anchors.yml"}, "- us-east-1\n- us-west-2\n- eu-west-1\n"},
+		{"list mode shows the merged keys", []string{"-l", "test", "test/anchors.yml"}, "adapter\nhost\npool\ndatabase\n"},
+		{"--no-merge lists the merge key", []string{"--no-merge", "-l", "test", "test/anchors.yml"}, "<<\ndatabase\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("--no-merge doesn't find merged keys", func(t *testing.T) {
+		res := runCLI(t, "", "--no-merge", "development.adapter", "test/anchors.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+	})
+}
+
+func TestCLILeaves(t *testing.T) {
+	const input = "db:\n  host: localhost # primary\n  ports: [5432, 5433]\nmeta:\n  app.kubernetes.io/name: web\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"** flattens every scalar", []string{".**"},
+			"db.host: localhost\ndb.ports[0]: 5432\ndb.ports[1]: 5433\nmeta.\"app.kubernetes.io/name\": web\n"},
+		{"--leaves flattens below each match", []string{"--leaves", "db"},
+			"db.host: localhost\ndb.ports[0]: 5432\ndb.ports[1]: 5433\n"},
+		{"-t prints the leaves themselves", []string{"-t", "db.**"}, "localhost # primary\n5432\n5433\n"},
+		{"--paths lists where the leaves are", []string{"--paths", "db.**"}, "db.host\ndb.ports[0]\ndb.ports[1]\n"},
+		{"--list --leaves lists every leaf's path", []string{"-l", "--leaves"},
+			"db.host\ndb.ports[0]\ndb.ports[1]\nmeta.\"app.kubernetes.io/name\"\n"},
+		{"--list --leaves --values", []string{"-l", "--leaves", "--values", "db"},
+			"db.host = localhost\ndb.ports[0] = 5432\ndb.ports[1] = 5433\n"},
+		{"--list --leaves honours --depth", []string{"-l", "--leaves", "--depth", "1"}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("a printed path is a pattern for its leaf", func(t *testing.T) {
+		res := runCLI(t, input, "-t", `meta."app.kubernetes.io/name"`)
+		if res.exitCode != 0 || res.stdout != "web\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and web", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("--leaves with --tree is a usage error", func(t *testing.T) {
+		res := runCLI(t, input, "--leaves", "--tree", "db")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+	})
+}
+
+func TestCLITagsAndPaths(t *testing.T) {
+	const input = "db:\n  password: !vault abc\n  created: 2024-01-02\napi:\n  token: !vault xyz\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"--paths prints where each match is", []string{"--paths", "*.*"}, "db.password\ndb.created\napi.token\n"},
+		{"--paths of the root", []string{"--paths", "-f", "-"}, ".\n"},
+		{"--paths with --values", []string{"--paths", "--values", "*.*"}, "db.password: !vault abc\ndb.created: 2024-01-02\napi.token: !vault xyz\n"},
+		{"--paths with --values of a collection", []string{"--paths", "--values", "api"}, "api: {token: !vault xyz}\n"},
+		{"--tag with --paths", []string{"--tag", "!vault", "--paths"}, "db.password\napi.token\n"},
+		{"--tag under a path prefix", []string{"--tag", "!vault", "--paths", "api"}, "api.token\n"},
+		{"--tag extracts implicitly tagged scalars", []string{"--tag", "!!timestamp", "-t"}, "2024-01-02\n"},
+		{"--tag wraps matches like any other", []string{"--tag", "!vault", "db"}, "db:\n  password: !vault abc\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("no node with the tag is not found", func(t *testing.T) {
+		res := runCLI(t, input, "--tag", "!nope")
+		if res.exitCode != 1 || res.stdout != "" {
+			t.Errorf("exit code = %d, stdout = %q; want 1 and nothing", res.exitCode, res.stdout)
+		}
+	})
+}
+
+func TestCLIInputFormats(t *testing.T) {
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"a TOML file by extension", "", []string{"-t", "database.port", "test/config.toml"}, "5432\n"},
+		{"TOML tables print as YAML", "", []string{"database.credentials", "test/config.toml"}, "database:\n  credentials:\n    user: admin\n"},
+		{"TOML inline tables keep flow style", "", []string{"-t", "database.limits", "test/config.toml"}, "{cpu: 1.5, memory: 512Mi}\n"},
+		{"TOML arrays of tables", "", []string{"-t", "servers[*].name", "test/config.toml"}, "alpha\nbeta\n"},
+		{"TOML strings that look like numbers stay strings", "", []string{"--json", "-t", "version", "test/config.toml"}, "\"1.0\"\n"},
+		{"TOML sniffed on stdin", "name = \"web\"\nport = 80\n", []string{"-t", "port"}, "80\n"},
+		{"--input toml", "[server]\nport = 80\n", []string{"--input", "toml", "-t", "server.port"}, "80\n"},
+		{"--input json", `{"a": [1, 2]}`, []string{"--input", "json", "-t", "a[1]"}, "2\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	errCases := []struct {
+		name     string
+		stdin    string
+		args     []string
+		exitCode int
+		stderr   string
+	}{
+		{"invalid TOML", "a = 1\na = 2\n", []string{"a"}, 3, "Error: failed to parse TOML in stdin: line 2: key a is defined more than once\n"},
+		{"unknown --input", "a: 1\n", []string{"--input", "xml", "a"}, 2, "Error: --input must be yaml, json, toml or auto, got \"xml\"\n"},
+		{"TOML can't be edited in place", "", []string{"--set", "title=x", "-i", "test/config.toml"}, 2, "Error: --in-place can't write test/config.toml back as TOML; gy only writes YAML\n"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != tc.exitCode {
+				t.Errorf("exit code = %d, want %d", res.exitCode, tc.exitCode)
+			}
+			if res.stderr != tc.stderr {
+				t.Errorf("stderr = %q, want %q", res.stderr, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIJSONPath(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"dot notation", []string{"--jsonpath", "-t", "$.spec.template.spec.containers[0].name", "test/kubernetes.yml"}, "nginx\n"},
+		{"bracket notation", []string{"--jsonpath", "-t", "$['metadata']['name']", "test/kubernetes.yml"}, "nginx-deployment\n"},
+		{"kubectl braces", []string{"--jsonpath", "-t", "{.metadata.namespace}", "test/kubernetes.yml"}, "production\n"},
+		{"wildcard and filter", []string{"--jsonpath", "-t", "$.spec.template.spec.containers[?(@.name=='nginx')].image", "test/kubernetes.yml"}, "nginx:1.21\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	errCases := []struct {
+		name   string
+		args   []string
+		stderr string
+	}{
+		{"comparison filter", []string{"--jsonpath", "$.items[?(@.size > 3)]"}, "Error: JSONPath $.items[?(@.size > 3)] is not supported: filter comparisons other than == and !=\n"},
+		{"kubectl range", []string{"--jsonpath", "{range .items[*]}{.name}{end}"}, "Error: JSONPath {range .items[*]}{.name}{end} is not supported: kubectl templates such as {range} are not paths\n"},
+		{"no root", []string{"--jsonpath", "items"}, "Error: JSONPath items must start with $\n"},
+		{"with --set", []string{"--jsonpath", "--set", "a=1"}, "Error: --jsonpath can't be combined with --set, --delete or --merge\n"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "items: []\n", tc.args...)
+			if res.exitCode != 2 {
+				t.Errorf("exit code = %d, want 2", res.exitCode)
+			}
+			if res.stderr != tc.stderr {
+				t.Errorf("stderr = %q, want %q", res.stderr, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIPatternSources(t *testing.T) {
+	t.Run("--pattern-stdin reads the pattern from stdin", func(t *testing.T) {
+		res := runCLI(t, "spec.replicas\nignored\n", "--pattern-stdin", "-t", "test/kubernetes.yml")
+		if res.exitCode != 0 || res.stdout != "3\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and 3", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("GY_PATTERN names the pattern", func(t *testing.T) {
+		t.Setenv("GY_PATTERN", "kind")
+		res := runCLI(t, "", "-t", "test/kubernetes.yml")
+		if res.exitCode != 0 || res.stdout != "Deployment\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and Deployment", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("GY_PATTERN wins over --pattern-stdin", func(t *testing.T) {
+		t.Setenv("GY_PATTERN", "kind")
+		res := runCLI(t, "spec.replicas\n", "--pattern-stdin", "-t", "test/kubernetes.yml")
+		if res.exitCode != 0 || res.stdout != "Deployment\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and Deployment", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("an argument wins over GY_PATTERN", func(t *testing.T) {
+		t.Setenv("GY_PATTERN", "kind")
+		res := runCLI(t, "", "-t", "metadata.name", "test/kubernetes.yml")
+		if res.exitCode != 0 || res.stdout != "nginx-deployment\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and nginx-deployment", res.exitCode, res.stdout)
+		}
+	})
+
+	errCases := []struct {
+		name   string
+		stdin  string
+		args   []string
+		stderr string
+	}{
+		{"no file to read", "kind\n", []string{"--pattern-stdin"}, "Error: --pattern-stdin reads the pattern from stdin, so the document must come from a file\n"},
+		{"empty first line", "\nkind\n", []string{"--pattern-stdin", "test/kubernetes.yml"}, "Error: --pattern-stdin found no pattern on the first line of stdin\n"},
+		{"with --set", "", []string{"--pattern-stdin", "--set", "a=1", "test/simple.yml"}, "Error: --pattern-stdin can't be combined with --set, --delete or --merge\n"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 2 {
+				t.Errorf("exit code = %d, want 2", res.exitCode)
+			}
+			if res.stderr != tc.stderr {
+				t.Errorf("stderr = %q, want %q", res.stderr, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIFindValue(t *testing.T) {
+	const input = "web:\n  host: 10.0.3.17\n  aliases: [10.0.3.17, web.local]\ndb:\n  host: 10.0.3.18\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"exact value", []string{"--find-value", "10.0.3.17"}, "web.host: 10.0.3.17\nweb.aliases[0]: 10.0.3.17\n"},
+		{"--contains", []string{"--find-value", "10.0.3", "--contains"}, "web.host: 10.0.3.17\nweb.aliases[0]: 10.0.3.17\ndb.host: 10.0.3.18\n"},
+		{"--regex", []string{"--find-value", `\.local$`, "--regex"}, "web.aliases[1]: web.local\n"},
+		{"below a pattern", []string{"--find-value", "10.0.3", "--contains", "db"}, "db.host: 10.0.3.18\n"},
+		{"--paths prints the paths alone", []string{"--find-value", "10.0.3.17", "--paths"}, "web.host\nweb.aliases[0]\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	errCases := []struct {
+		name     string
+		args     []string
+		exitCode int
+		stderr   string
+	}{
+		{"no hits", []string{"--find-value", "10.0.3.19"}, 1, "Value not found: 10.0.3.19\n"},
+		{"a missing path", []string{"--find-value", "10.0.3.17", "cache"}, 1, "Path not found: cache\n"},
+		{"--contains alone", []string{"--contains", "web"}, 2, "Error: --contains and --regex only apply to --find-value and --find-key\n"},
+		{"an invalid regex", []string{"--find-value", "(", "--regex"}, 2, "Error: invalid regular expression in --find-value: error parsing regexp: missing closing ): `(`\n"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != tc.exitCode {
+				t.Errorf("exit code = %d, want %d", res.exitCode, tc.exitCode)
+			}
+			if res.stderr != tc.stderr {
+				t.Errorf("stderr = %q, want %q", res.stderr, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIFindKey(t *testing.T) {
+	const input = "db:\n  user: app\n  password: s3cret\nusers:\n  - name: bob\n    password: hunter2\n    api_password: {vault: x}\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"paths of every match", []string{"--find-key", "password"}, "db.password\nusers[0].password\n"},
+		{"-t prints the values too", []string{"--find-key", "password", "-t"}, "db.password: s3cret\nusers[0].password: hunter2\n"},
+		{"--regex", []string{"--find-key", "password$", "--regex", "-t"},
+			"db.password: s3cret\nusers[0].password: hunter2\nusers[0].api_password.vault: x\n"},
+		{"below a pattern", []string{"--find-key", "password", "users"}, "users[0].password\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("no hits", func(t *testing.T) {
+		res := runCLI(t, input, "--find-key", "token")
+		if res.exitCode != 1 || res.stderr != "Key not found: token\n" {
+			t.Errorf("exit code = %d, stderr = %q; want 1 and Key not found", res.exitCode, res.stderr)
+		}
+	})
+
+	t.Run("with --find-value", func(t *testing.T) {
+		res := runCLI(t, input, "--find-key", "user", "--find-value", "app")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+	})
+}
+
+func TestCLIDefault(t *testing.T) {
+	const input = "name: web\nports: [80]\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"a missing path prints the default", []string{"optional.field", "--default", "none"}, "none\n"},
+		{"the default is printed raw", []string{"missing", "--default", "a: b"}, "a: b\n"},
+		{"an empty default", []string{"missing", "--default", ""}, "\n"},
+		{"a found path ignores the default", []string{"-t", "name", "--default", "none"}, "web\n"},
+		{"--default-yaml parses the default", []string{"missing", "--default", "[1, 2]", "--default-yaml"}, "[1, 2]\n"},
+		{"--default-yaml keeps its type in JSON", []string{"--json", "missing", "--default", "3", "--default-yaml"}, "3\n"},
+		{"each pattern gets the default", []string{"-t", "name", "missing", "other", "--default", "none"}, "web\nnone\nnone\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	errCases := []struct {
+		name     string
+		stdin    string
+		args     []string
+		exitCode int
+		stderr   string
+	}{
+		{"a parse error isn't hidden", "a: [1\n", []string{"a", "--default", "none"}, 3, "Error: failed to parse YAML in stdin: line 1: did not find expected ',' or ']'\n"},
+		{"a missing file isn't hidden", "", []string{"a", "test/missing.yml", "--default", "none"}, 3, "Error: cannot read test/missing.yml: no such file or directory\n"},
+		{"invalid --default-yaml", input, []string{"a", "--default", "[1", "--default-yaml"}, 2, "Error: --default isn't valid YAML: line 1: did not find expected ',' or ']'\n"},
+		{"--default-yaml alone", input, []string{"a", "--default-yaml"}, 2, "Error: --default-yaml only applies to --default\n"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != tc.exitCode {
+				t.Errorf("exit code = %d, want %d", res.exitCode, tc.exitCode)
+			}
+			if res.stderr != tc.stderr {
+				t.Errorf("stderr = %q, want %q", res.stderr, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIEmptyDocuments(t *testing.T) {
+	inputs := map[string]string{"empty": "", "comment only": "# comment\n", "bare ---": "---\n", "whitespace": "  \n"}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			for _, pattern := range []string{"", "."} {
+				res := runCLI(t, input, "-f", "-", pattern)
+				if res.exitCode != 0 || res.stdout != "" || res.stderr != "" {
+					t.Errorf("pattern %q: exit code = %d, stdout = %q, stderr = %q; want 0 and no output", pattern, res.exitCode, res.stdout, res.stderr)
+				}
+			}
+			res := runCLI(t, input, "-f", "-", "a.b")
+			if res.exitCode != 1 || res.stderr != "Path not found: a.b\n" {
+				t.Errorf("pattern a.b: exit code = %d, stderr = %q; want 1 and Path not found", res.exitCode, res.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIOutputFile(t *testing.T) {
+	read := func(t *testing.T, file string) string {
+		t.Helper()
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+
+	t.Run("the output goes to the file, not stdout", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "db.yml")
+		res := runCLI(t, "", "database.credentials", "-o", out, "test/simple.yml")
+		if res.exitCode != 0 || res.stdout != "" {
+			t.Fatalf("exit code = %d, stdout = %q; want 0 and no output; stderr=%q", res.exitCode, res.stdout, res.stderr)
+		}
+		if got, want := read(t, out), "database:\n  credentials:\n    user: admin\n    password: secret123\n"; got != want {
+			t.Errorf("file = %q, want %q", got, want)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(out))
+		if len(entries) != 1 {
+			t.Errorf("directory holds %d files, want just the output and no temporary file", len(entries))
+		}
+	})
+
+	t.Run("formatting flags apply", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "db.json")
+		res := runCLI(t, "", "--json", "--json-indent", "0", "--output-file", out, "database.port", "test/simple.yml")
+		if res.exitCode != 0 || read(t, out) != "{\"database\":{\"port\":5432}}\n" {
+			t.Errorf("exit code = %d, file = %q; want 0 and compact JSON", res.exitCode, read(t, out))
+		}
+	})
+
+	t.Run("an edit goes to the file and leaves the input alone", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "edited.yml")
+		res := runCLI(t, "", "--set", "app.debug=true", "-o", out, "test/simple.yml")
+		if res.exitCode != 0 || !bytes.Contains([]byte(read(t, out)), []byte("debug: true")) {
+			t.Errorf("exit code = %d, file = %q; want 0 and the edited document", res.exitCode, read(t, out))
+		}
+	})
+
+	t.Run("a failed run leaves the file as it was", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "keep.yml")
+		if err := os.WriteFile(out, []byte("kept: true\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		res := runCLI(t, "", "-o", out, "nope", "test/simple.yml")
+		if res.exitCode != 1 || read(t, out) != "kept: true\n" {
+			t.Errorf("exit code = %d, file = %q; want 1 and the file untouched", res.exitCode, read(t, out))
+		}
+		res = runCLI(t, "", "-o", out, "app.name", "test/simple.yml")
+		info, err := os.Stat(out)
+		if res.exitCode != 0 || err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("exit code = %d, mode = %v; want 0 and the file's own 0600 kept", res.exitCode, info.Mode())
+		}
+	})
+
+	t.Run("the input file is refused", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "in.yml")
+		if err := os.WriteFile(file, []byte("a: 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res := runCLI(t, "", "-o", file, "a", file)
+		want := "Error: --output-file " + file + " is the input file; use --in-place with --set, --delete or --merge to change it\n"
+		if res.exitCode != 2 || res.stderr != want || read(t, file) != "a: 1\n" {
+			t.Errorf("exit code = %d, stderr = %q; want 2 and %q", res.exitCode, res.stderr, want)
+		}
+		if res := runCLI(t, "", "-o", file+".out", "-i", "--set", "a=2", file); res.exitCode != 2 {
+			t.Errorf("with --in-place: exit code = %d, want 2", res.exitCode)
+		}
+	})
+}
+
+func TestCLIPatternFile(t *testing.T) {
+	checks := filepath.Join(t.TempDir(), "checks.txt")
+	if err := os.WriteFile(checks, []byte("# what CI checks\n\napp.name\n  database.port  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		stdin    string
+		args     []string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"one pattern per line", "", []string{"-t", "--pattern-file", checks, "test/simple.yml"}, 0, "MyApp\n5432\n", ""},
+		{"labeled", "", []string{"--labels", "--pattern-file", checks, "test/simple.yml"}, 0, "app.name: MyApp\ndatabase.port: 5432\n", ""},
+		{"with patterns from the command line first", "", []string{"-t", "--pattern-file", checks, "-f", "test/simple.yml", "app.version"}, 0, "1.2.3\nMyApp\n5432\n", ""},
+		{"from stdin", "app.debug\n", []string{"-t", "--pattern-file", "-", "test/simple.yml"}, 0, "false\n", ""},
+		{"a missing pattern", "", []string{"-t", "--pattern-file", checks, "test/arrays.yml"}, 1, "", "Path not found: app.name\nPath not found: database.port\n"},
+		{"a pattern argument besides the input", "", []string{"--pattern-file", checks, "app", "test/simple.yml"}, 2, "",
+			"Error: --pattern-file reads the patterns from " + checks + "; name the input with -f to give more on the command line\n"},
+		{"stdin can't hold both", "app.name\n", []string{"--pattern-file", "-"}, 2, "",
+			"Error: --pattern-file - reads the patterns from stdin, so the document must come from a file\n"},
+		{"no patterns", "# nothing\n", []string{"--pattern-file", "-", "test/simple.yml"}, 2, "", "Error: --pattern-file found no patterns in stdin\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != tc.exitCode || res.stdout != tc.stdout || res.stderr != tc.stderr {
+				t.Errorf("exit code = %d, stdout = %q, stderr = %q; want %d, %q, %q", res.exitCode, res.stdout, res.stderr, tc.exitCode, tc.stdout, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIFlatten(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		exitCode int
+		stdout   string
+	}{
+		{"a mapping", []string{"--flatten", "database", "test/simple.yml"}, 0,
+			"database.host: localhost\ndatabase.port: 5432\ndatabase.timeout: 30\ndatabase.credentials.user: admin\ndatabase.credentials.password: secret123\n"},
+		{"JSON", []string{"--flatten", "--json", "--json-indent", "0", "app", "test/simple.yml"}, 0,
+			"{\"app.name\":\"MyApp\",\"app.version\":\"1.2.3\",\"app.debug\":false}\n"},
+		{"sequences get indexes", []string{"--flatten", "spec.template.spec.containers[0].ports", "test/kubernetes.yml"}, 0,
+			"spec.template.spec.containers[0].ports[0].containerPort: 80\nspec.template.spec.containers[0].ports[0].protocol: TCP\n"},
+		{"a pattern ending in ** still flattens", []string{"--flatten", "app.**", "test/simple.yml"}, 0,
+			"app.name: MyApp\napp.version: 1.2.3\napp.debug: false\n"},
+		{"not with --list", []string{"--flatten", "-l", "test/simple.yml"}, 2, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != tc.exitCode || res.stdout != tc.stdout {
+				t.Errorf("exit code = %d, stdout = %q; want %d, %q (stderr=%q)", res.exitCode, res.stdout, tc.exitCode, tc.stdout, res.stderr)
+			}
+		})
+	}
+}
+
+func TestCLICheckPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"a well-formed path", []string{"--check-path", ".a.b[0]"}, 0, "", ""},
+		{"several patterns", []string{"--check-path", "a.b", "items[*] | name"}, 0, "", ""},
+		{"a malformed path", []string{"--check-path", "a.b[0"}, 2, "", "Error: unclosed '[' at offset 3\n"},
+		{"an error in a later union member", []string{"--check-path", "a, b[x]"}, 2, "", "Error: invalid index \"x\" at offset 5\n"},
+		{"--debug prints the parts as JSON", []string{"--debug", "--json-indent", "0", "--check-path", `a."b.c"[0] // d, e`}, 0,
+			`[{"pattern":"a.\"b.c\"[0] // d","paths":[["a","\"b.c\"","[0]"],["d"]]},{"pattern":"e","paths":[["e"]]}]` + "\n", ""},
+		{"no pattern", []string{"--check-path"}, 2, "", "Usage: gy --check-path pattern [pattern...]\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Nothing is read from stdin, so this input is never parsed
+			res := runCLI(t, "not: [yaml", tc.args...)
+			if res.exitCode != tc.exitCode || res.stdout != tc.stdout || res.stderr != tc.stderr {
+				t.Errorf("exit code = %d, stdout = %q, stderr = %q; want %d, %q, %q", res.exitCode, res.stdout, res.stderr, tc.exitCode, tc.stdout, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIMaxResults(t *testing.T) {
+	const input = "a:\n  name: one\n---\nb:\n  name: two\n  c:\n    name: three\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"-m 1 is the first match in document order", []string{"-t", "-m", "1", "..name"}, "one\n"},
+		{"the limit spans documents", []string{"-t", "--max-results", "2", "..name"}, "one\n---\ntwo\n"},
+		{"a limit above the matches changes nothing", []string{"-t", "-m", "5", "..name"}, "one\n---\ntwo\nthree\n"},
+		{"each pattern has its own limit", []string{"--paths", "-m", "1", "-f", "-", "..name", "*"}, "a.name\na\n"},
+		{"--count counts up to the limit", []string{"--count", "-m", "2", "..name"}, "1\n1\n"},
+		{"the limit applies after --find-value", []string{"--paths", "-m", "1", "--find-value", "three"}, "b.c.name\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("a negative limit is a usage error", func(t *testing.T) {
+		res := runCLI(t, input, "-m", "-1", "..name")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIDebug(t *testing.T) {
+	t.Run("traces a miss on stderr", func(t *testing.T) {
+		res := runCLI(t, "", "--debug", "services.web.ports[5]", "test/docker-compose.yml")
+		want := "debug: services.web.ports[5] splits into [\"services\" \"web\" \"ports\" \"[5]\"]\n" +
+			"debug: searching document 0 for services.web.ports[5]\n" +
+			"key services at the document root (a mapping)\n" +
+			"  key web at services (a mapping)\n" +
+			"    key ports at services.web (a mapping)\n" +
+			"      index [5] at services.web.ports (a sequence)\n" +
+			"      no match: [5] is out of range for services.web.ports (length 2)\n" +
+			"Path not found: services.web.ports[5]\n"
+		if res.exitCode != 1 || res.stdout != "" || res.stderr != want {
+			t.Errorf("exit code = %d, stdout = %q, stderr =\n%s\nwant 1, nothing and:\n%s", res.exitCode, res.stdout, res.stderr, want)
+		}
+	})
+
+	t.Run("leaves stdout as it was", func(t *testing.T) {
+		plain := runCLI(t, "", "-t", "..image", "test/docker-compose.yml")
+		traced := runCLI(t, "", "--debug", "-t", "..image", "test/docker-compose.yml")
+		if traced.exitCode != 0 || traced.stdout != plain.stdout {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q", traced.exitCode, traced.stdout, plain.stdout)
+		}
+		if !bytes.Contains([]byte(traced.stderr), []byte("match at services.web.image (a scalar)\n")) {
+			t.Errorf("stderr = %q, want a trace of each match", traced.stderr)
+		}
+	})
+}
+
+func TestCLINullAndOptional(t *testing.T) {
+	const input = "spec:\n  strategy:\n  replicas: null\n  image: web\n"
+
+	cases := []struct {
+		name     string
+		args     []string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"an empty value prints as null", []string{"-t", "spec.strategy"}, 0, "null\n", ""},
+		{"and raw too", []string{"-r", "spec.strategy"}, 0, "null\n", ""},
+		{"an explicit null as written", []string{"-t", "spec.replicas"}, 0, "null\n", ""},
+		{"--leaves spells it out", []string{"--leaves", "spec.strategy"}, 0, "spec.strategy: null\n", ""},
+		{"a missing key is not found", []string{"-t", "spec.nope"}, 1, "", "Path not found: spec.nope\n"},
+		{"? makes a missing key fine", []string{"-t", "spec.nope?"}, 0, "", ""},
+		{"? still prints what's there", []string{"-t", "spec.image?"}, 0, "web\n", ""},
+		{"? on one member of a union", []string{"-t", "spec.nope?, spec.image"}, 0, "web\n", ""},
+		{"? doesn't hide a path that can't fit", []string{"-t", "spec.image.name?"}, 1, "", "Error: name looks up a key, but spec.image is a scalar\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != tc.exitCode || res.stdout != tc.stdout || res.stderr != tc.stderr {
+				t.Errorf("exit code = %d, stdout = %q, stderr = %q; want %d, %q, %q", res.exitCode, res.stdout, res.stderr, tc.exitCode, tc.stdout, tc.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIExpandFilename(t *testing.T) {
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, "configs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "configs", "app.yaml"), []byte("x: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("GY_TEST_DIR", "configs")
+
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"a leading ~", []string{"-t", "x", "~/configs/app.yaml"}},
+		{"$VAR and ${VAR}", []string{"-t", "x", "$HOME/${GY_TEST_DIR}/app.yaml"}},
+		{"-f", []string{"-t", "-f", "~/configs/app.yaml", "x"}},
+		{"a lone filename argument", []string{"-t", "~/configs/app.yaml"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			want := "1\n"
+			if tc.name == "a lone filename argument" {
+				want = "x: 1\n"
+			}
+			if res.exitCode != 0 || res.stdout != want {
+				t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
+			}
+		})
+	}
+
+	t.Run("--no-expand takes the name literally", func(t *testing.T) {
+		res := runCLI(t, "", "--no-expand", "-t", "x", "~/configs/app.yaml")
+		want := "Error: cannot read ~/configs/app.yaml: no such file or directory\n"
+		if res.exitCode != 3 || res.stderr != want {
+			t.Errorf("exit code = %d, stderr = %q, want 3 and %q", res.exitCode, res.stderr, want)
+		}
+	})
+
+	t.Run("--in-place writes back to the expanded file", func(t *testing.T) {
+		res := runCLI(t, "", "-i", "--set", "x=2", "~/configs/app.yaml")
+		if res.exitCode != 0 {
+			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+		}
+		got, err := os.ReadFile(filepath.Join(home, "configs", "app.yaml"))
+		if err != nil || string(got) != "x: 2\n" {
+			t.Errorf("file = %q (%v), want %q", got, err, "x: 2\n")
+		}
+	})
+}
+
+func TestCLIStream(t *testing.T) {
+	const input = "a: 1\nb: x\n---\na: 2\n---\na: 3\nb: y\n"
+
+	// --stream prints what loading the whole input would
+	for _, args := range [][]string{
+		{"a,b"},
+		{"-t", "a,b"},
+		{"-r", "-n", "a,b"},
+		{"--labels", "-f", "-", "a", "b?"},
+		{"--count", "b"},
+		{"--json", "a"},
+		{"--doc", "1", "a"},
+	} {
+		t.Run(args[len(args)-1]+" "+args[0], func(t *testing.T) {
+			want := runCLI(t, input, args...)
+			got := runCLI(t, input, append([]string{"--stream"}, args...)...)
+			if got.exitCode != want.exitCode || got.stdout != want.stdout {
+				t.Errorf("--stream gave %q (exit %d), want %q (exit %d)", got.stdout, got.exitCode, want.stdout, want.exitCode)
+			}
+		})
+	}
+
+	// Reading stops once there's nothing left to find, so a broken
+	// document further on is never parsed
+	broken := input + "---\na: [4\n"
+	for _, args := range [][]string{
+		{"-t", "-m", "2", "a"},
+		{"-t", "--doc", "1", "a"},
+		{"--exists", "b"},
+	} {
+		t.Run("stops early with "+args[1], func(t *testing.T) {
+			res := runCLI(t, broken, append([]string{"--stream"}, args...)...)
+			if res.exitCode != 0 {
+				t.Errorf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+		})
+	}
+
+	t.Run("a parse error after some output", func(t *testing.T) {
+		res := runCLI(t, broken, "--stream", "-t", "a")
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3; stderr=%q", res.exitCode, res.stderr)
+		}
+		if res.stdout != "1\n---\n2\n---\n3\n" {
+			t.Errorf("stdout = %q, want the documents before the error", res.stdout)
+		}
+	})
+
+	t.Run("leaves --output-file alone on an error", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.yaml")
+		if err := os.WriteFile(out, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res := runCLI(t, broken, "--stream", "-o", out, "-t", "a")
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3; stderr=%q", res.exitCode, res.stderr)
+		}
+		if data, _ := os.ReadFile(out); string(data) != "old\n" {
+			t.Errorf("output file = %q, want it unchanged", data)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(out), ".out.yaml.*")); len(matches) > 0 {
+			t.Errorf("temporary files left behind: %v", matches)
+		}
+	})
+
+	t.Run("refuses TOML", func(t *testing.T) {
+		res := runCLI(t, "a = 1\n", "--stream", "--input", "toml", "a")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIFirstLast(t *testing.T) {
+	const input = "a: [1, 2, 3]\nb: x\n---\na: [4, 5]\n---\nc: 1\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"--first is the first match in document order", []string{"-t", "--first", "a[*]"}, "1\n"},
+		{"--last is the last, across documents", []string{"-t", "--last", "a[*]"}, "5\n"},
+		{"a single match is unchanged", []string{"-t", "--last", "b"}, "x\n"},
+		{"each pattern has its own", []string{"--paths", "--last", "-f", "-", "a[*]", "b"}, "b\na[1]\n"},
+		{"--count counts every match", []string{"--count", "--last", "a[*]"}, "3\n2\n0\n"},
+		{"a default doesn't replace an earlier match", []string{"-t", "--last", "--default", "none", "b"}, "x\n"},
+		{"--stream holds --last back to the end", []string{"-t", "--stream", "--last", "a[*]"}, "5\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"--first", "--last"}, {"--first", "-m", "2"}} {
+		t.Run("usage error for "+args[1], func(t *testing.T) {
+			res := runCLI(t, input, append(args, "a[*]")...)
+			if res.exitCode != 2 {
+				t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+			}
+		})
+	}
+}
+
+func TestCLITableOutput(t *testing.T) {
+	const input = "hosts:\n  - name: web\n    ip: 10.0.0.1\n  - name: \"db, primary\"\n    port: 5432\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"csv quotes where it must", []string{"--output", "csv", "hosts"},
+			"name,ip,port\nweb,10.0.0.1,\n\"db, primary\",,5432\n"},
+		{"tsv", []string{"--output", "tsv", "hosts"},
+			"name\tip\tport\nweb\t10.0.0.1\t\ndb, primary\t\t5432\n"},
+		{"a blank line between tables", []string{"--output", "csv", "-f", "-", "hosts[0]", "hosts[*].name"},
+			"name,ip\nweb,10.0.0.1\n\nvalue\nweb\n\"db, primary\"\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("an alias inside its own anchor is a null cell where it repeats", func(t *testing.T) {
+		for format, want := range map[string]string{
+			"csv": "b,c\n\"{\"\"b\"\":{\"\"b\"\":null,\"\"c\"\":1},\"\"c\"\":1}\",1\n",
+			"tsv": "b\tc\n\"{\"\"b\"\":{\"\"b\"\":null,\"\"c\"\":1},\"\"c\"\":1}\"\t1\n",
+		} {
+			res := runCLI(t, "a: &x\n  b: *x\n  c: 1\n", "--output", format, "a")
+			if res.exitCode != 0 || res.stdout != want {
+				t.Errorf("%s: exit code = %d, stdout = %q; want 0 and %q (stderr=%q)", format, res.exitCode, res.stdout, want, res.stderr)
+			}
+		}
+	})
+
+	t.Run("a sequence of sequences is an error", func(t *testing.T) {
+		res := runCLI(t, "rows: [[1, 2]]\n", "--output", "csv", "rows")
+		if res.exitCode != 1 || res.stdout != "" {
+			t.Errorf("exit code = %d, stdout = %q; want 1 and nothing printed", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("can't be combined with --paths", func(t *testing.T) {
+		res := runCLI(t, input, "--output", "csv", "--paths", "hosts")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIScalarStyle(t *testing.T) {
+	const input = "data:\n" +
+		"  script: |\n    echo hi\n    exit 0\n" +
+		"  keep: |+\n    a\n\n" +
+		"  strip: |-\n    b\n" +
+		"  indented: |2\n      c\n    d\n" +
+		"  single: 'it''s'\n" +
+		"  double: \"x\\ty\"\n"
+
+	t.Run("output matches the input byte for byte", func(t *testing.T) {
+		for _, pattern := range []string{".", "data", "data.*"} {
+			res := runCLI(t, input, "-f", "-", pattern)
+			if res.exitCode != 0 || res.stdout != input {
+				t.Errorf("%s gave %q (exit %d), want the input unchanged", pattern, res.stdout, res.exitCode)
+			}
+		}
+	})
+
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"a trimmed block keeps its indicator", input, []string{"-t", "data.script"}, "|\n  echo hi\n  exit 0\n"},
+		{"a trimmed kept block keeps its trailing lines", input, []string{"-t", "data.keep"}, "|+\n  a\n\n"},
+		{"--style double", input, []string{"--style", "double", "-t", "data.script"}, "\"echo hi\\nexit 0\\n\"\n"},
+		{"--style literal", input, []string{"--style", "literal", "-t", "data.single"}, "|-\n  it's\n"},
+		{"--style plain", input, []string{"--style", "plain", "-t", "data.single"}, "it's\n"},
+		{"--style plain still quotes what needs it", input, []string{"--style", "plain", "-t", "data.double"}, "\"x\\ty\"\n"},
+		{"--style leaves keys and numbers alone", "'n': 1\n", []string{"--style", "single", "-f", "-", "."}, "'n': 1\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("an unknown style is a usage error", func(t *testing.T) {
+		res := runCLI(t, input, "--style", "fancy", "data")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIKeyValueOutput(t *testing.T) {
+	const input = "app:\n  env:\n    db_host: localhost\n    ports: [80, 443]\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"dotenv", []string{"--output", "dotenv", "app.env"}, "DB_HOST=localhost\nPORTS_0=80\nPORTS_1=443\n"},
+		{"properties", []string{"--output", "properties", "app.env"}, "db_host=localhost\nports.0=80\nports.1=443\n"},
+		{"a scalar or sequence match keeps its key", []string{"--output", "dotenv", "-f", "-", "app.env.db_host", "app.env.ports"}, "DB_HOST=localhost\nPORTS_0=80\nPORTS_1=443\n"},
+		{"--prefix", []string{"--output", "properties", "--prefix", "spring", "app.env.db_host"}, "spring.db_host=localhost\n"},
+		{"shell", []string{"--output", "shell", "app.env"},
+			"export ENV_DB_HOST='localhost'\nexport ENV_PORTS_0='80'\nexport ENV_PORTS_1='443'\nexport ENV_PORTS_COUNT='2'\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("shell names that collide are an error", func(t *testing.T) {
+		res := runCLI(t, "a-b: 1\na_b: 2\n", "--output", "shell", "-f", "-", ".")
+		if res.exitCode != 1 || res.stdout != "" {
+			t.Errorf("exit code = %d, stdout = %q; want 1 and nothing printed", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("dotenv and properties names that collide are an error", func(t *testing.T) {
+		for _, tc := range []struct{ output, input string }{
+			{"dotenv", "a: {b: 1}\na_b: 2\n"},
+			{"properties", "a: {b: 1}\n\"a.b\": 2\n"},
+		} {
+			res := runCLI(t, tc.input, "--output", tc.output, "-f", "-", ".")
+			if res.exitCode != 1 || res.stdout != "" || !strings.Contains(res.stderr, "would both be written as") {
+				t.Errorf("%s: exit code = %d, stdout = %q, stderr = %q; want 1 and nothing printed", tc.output, res.exitCode, res.stdout, res.stderr)
+			}
+		}
+	})
+
+	t.Run("--prefix needs a key=value output", func(t *testing.T) {
+		res := runCLI(t, "a: 1\n", "--prefix", "x", "a")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIFlatOutput(t *testing.T) {
+	const input = "web:\n  port: 8080\n  hosts: [a, b, c, d, e, f, g, h, i, j, k]\n  \"tls.cert\": /etc/tls.pem\napi:\n  labels: {}\n  args: []\n"
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		exitCode int
+	}{
+		{"document order", []string{"--output", "flat", "-f", "-", "api,web.port"},
+			"api.labels: {}\napi.args: []\nweb.port: 8080\n", 0},
+		{"is --leaves", []string{"--leaves", "-f", "-", "api,web.port"},
+			"api.labels: {}\napi.args: []\nweb.port: 8080\n", 0},
+		{"sorted", []string{"--output", "flat", "--sort", "-f", "-", "."},
+			"api.args: []\napi.labels: {}\n" +
+				"web.hosts[0]: a\nweb.hosts[1]: b\nweb.hosts[2]: c\nweb.hosts[3]: d\nweb.hosts[4]: e\nweb.hosts[5]: f\n" +
+				"web.hosts[6]: g\nweb.hosts[7]: h\nweb.hosts[8]: i\nweb.hosts[9]: j\nweb.hosts[10]: k\n" +
+				"web.port: 8080\nweb.\"tls.cert\": /etc/tls.pem\n", 0},
+		{"--sort needs leaf lines", []string{"--sort", "web"}, "", 2},
+		{"unknown format", []string{"--output", "flattened", "web"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLIParent(t *testing.T) {
+	const input = "db:\n  host: localhost\n  port: 5432\nservices:\n  - name: web\n    port: 8080\n  - name: api\n    port: 3000\n"
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		exitCode int
+	}{
+		{"wrapped in its own path", []string{"--parent", "services[1].name"},
+			"services:\n  - name: api\n    port: 3000\n", 0},
+		{"trimmed", []string{"--parent", "-t", "db.host"}, "host: localhost\nport: 5432\n", 0},
+		{"a wildcard's matches share one parent", []string{"--parent", "-t", "services[*]"},
+			"- name: web\n  port: 8080\n- name: api\n  port: 3000\n", 0},
+		{"each match's own parent", []string{"--parent", "-t", "--paths", "..port"}, "db\nservices[0]\nservices[1]\n", 0},
+		{"a top-level key's parent is the root", []string{"--parent", "-t", "db"}, input, 0},
+		{"the root has none", []string{"--parent", ".", "-"}, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLIPrint0(t *testing.T) {
+	const input = "notes:\n  - \"one\\ntwo\"\n  - three\n  - {k: v}\nport: 80\n"
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		exitCode int
+	}{
+		{"raw values with line breaks", []string{"--print0", "-r", "notes[*]"}, "one\ntwo\x00three\x00{k: v}", 0},
+		{"a slice is one value", []string{"--print0", "-r", "notes[1:]"}, "- three\n- {k: v}", 0},
+		{"across patterns", []string{"--print0", "-r", "-f", "-", "notes[1]", "port"}, "three\x0080", 0},
+		{"paths", []string{"--print0", "--paths", "notes[*]"}, "notes[0]\x00notes[1]\x00notes[2]", 0},
+		{"list lines", []string{"--print0", "-l", "--leaves", ".", "-"}, "notes[0]\x00notes[1]\x00notes[2].k\x00port", 0},
+		{"a custom delimiter ends with a newline", []string{"--delimiter", ", ", "--paths", "notes[*]"}, "notes[0], notes[1], notes[2]\n", 0},
+		{"unless -n", []string{"--delimiter", ",", "-n", "-r", "notes[*]"}, "one\ntwo,three,{k: v}", 0},
+		{"not for YAML output", []string{"--print0", "notes"}, "", 2},
+		{"not both", []string{"--print0", "--delimiter", ",", "-r", "port"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLITags(t *testing.T) {
+	const fixture = "test/cloudformation.yml"
+	want, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Custom tags, and standard ones written out, survive a round trip
+	t.Run("round trip", func(t *testing.T) {
+		res := runCLI(t, "", ".", fixture)
+		if res.exitCode != 0 || res.stdout != string(want) {
+			t.Errorf("exit code = %d, stdout = %q, want the fixture unchanged; stderr: %s", res.exitCode, res.stdout, res.stderr)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		exitCode int
+	}{
+		{"a tagged scalar", []string{"-t", "Resources.Bucket.Properties.BucketName"}, "!Sub \"${AWS::StackName}-data\"\n", 0},
+		{"raw drops the tag", []string{"-r", "Outputs.BucketArn.Value"}, "Bucket.Arn\n", 0},
+		{"a rebuilt ancestor keeps its tag", []string{"Resources.Bucket.Properties.Tags[1]"},
+			"Resources:\n  Bucket:\n    Properties:\n      Tags: !If [!Ref ProdTags]\n", 0},
+		{"a tagged sequence", []string{"-t", "Resources.Bucket.Properties.Versioning"}, "!FindInMap\n- Settings\n- !Ref Env\n- Versioning\n", 0},
+		{"--flow keeps a written tag", []string{"--flow", "-t", "Resources.Queue.Properties.Retention"}, "!!str 345600\n", 0},
+		{"--keep-tags", []string{"--keep-tags", "-t", "Conditions"}, "!!str IsProd: !Equals [!Ref Env, !!str prod]\n", 0},
+		{"--strip-tags", []string{"--strip-tags", "-t", "Resources.Queue.Properties"},
+			"QueueName: !Join\n  - \"-\"\n  - - !Ref AWS::StackName\n    - queue\nDelay: 30\nRetention: \"345600\"\n", 0},
+		{"not both", []string{"--keep-tags", "--strip-tags", "Conditions"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, "", append(tt.args, fixture)...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLIWindowsInput(t *testing.T) {
+	// The fixtures start with a UTF-8 byte order mark and end their lines
+	// with CRLF, as some Windows editors save them
+	for _, file := range []string{"test/windows.yml", "test/windows.toml"} {
+		for _, tt := range []struct {
+			args []string
+			want string
+		}{
+			{[]string{"-t", "port"}, "8080\n"},
+			{[]string{"-r", "-n", "note"}, "line one\nline two\n"},
+			{[]string{"--json", "--json-indent", "0", "."}, "{\"name\":\"app\",\"port\":8080,\"note\":\"line one\\nline two\\n\",\"tags\":[\"a\",\"b\"]}\n"},
+		} {
+			t.Run(file+" "+tt.args[0], func(t *testing.T) {
+				res := runCLI(t, "", append(tt.args, file)...)
+				if res.exitCode != 0 {
+					t.Fatalf("exit code = %d; stderr: %s", res.exitCode, res.stderr)
+				}
+				if res.stdout != tt.want {
+					t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+				}
+			})
+		}
+	}
+
+	// Read from stdin, TOML is still told apart by its first line
+	input, err := os.ReadFile("test/windows.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := runCLI(t, string(input), "-t", "-f", "-", "port"); res.stdout != "8080\n" {
+		t.Errorf("stdin gave %q (exit %d), want %q", res.stdout, res.exitCode, "8080\n")
+	}
+}
+
+func TestCLITemplate(t *testing.T) {
+	const input = "packages:\n  - name: gy\n    version: 1.2.0\n  - name: yq\n    version: \"4.0\"\n  - name: jq\n    version: {major: 1}\n"
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		exitCode int
+	}{
+		{"one line per match", []string{"--template", "{{ .name }}:{{ .version }}", "-m", "2", ".packages[*]"}, "gy:1.2.0\nyq:4.0\n", 0},
+		{"a scalar match is the data itself", []string{"--template", "- {{ . | upper }}", "packages[*].name"}, "- GY\n- YQ\n- JQ\n", 0},
+		{"matches of every pattern", []string{"--template", "{{ . }}", "packages[0].name,packages[1].name"}, "gy\nyq\n", 0},
+		{"a failed match is named, and nothing printed", []string{"--template", "{{ .version | upper }} {{ .version.major }}", "packages[*]"}, "", 1},
+		{"a broken template is a usage error", []string{"--template", "{{ .name", "packages[*]"}, "", 2},
+		{"not with another format", []string{"--template", "{{ .name }}", "--json", "packages[*]"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, input, tt.args...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+
+	// The template is checked before the input is read, so a missing file
+	// isn't what's reported
+	res := runCLI(t, "", "--template", "{{ end }}", "name", "no-such-file.yml")
+	if res.exitCode != 2 || !strings.Contains(res.stderr, "--template") {
+		t.Errorf("got exit %d, stderr %q; want a --template error and exit 2", res.exitCode, res.stderr)
+	}
+}
+
+func TestCLIDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	staging := write("staging.yml", "spec:\n  replicas: 2\n  image: web:1\n  debug: true\n  ports: [80, 443]\n")
+	prod := write("prod.yml", "spec:\n  image: web:2\n  replicas: 2\n  ports: [80, 443, 8443]\n")
+	reordered := write("reordered.yml", "spec:\n  ports: [80, 443]\n  debug: true\n  image: web:1\n  replicas: 2\n")
+	multi := write("multi.yml", "a: 1\n---\na: 2\n")
+	merged := write("merged.yml", "base: &b {a: 1}\nx:\n  <<: *b\n")
+	inline := write("inline.yml", "base: {a: 1}\nx:\n  a: 1\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		want     string
+		exitCode int
+	}{
+		{"differences", []string{"--diff", staging, prod, ".spec"}, "",
+			"~ spec.image: web:1 -> web:2\n- spec.debug: true\n+ spec.ports[2]: 8443\n", 1},
+		{"the whole document by default", []string{"--diff", prod, staging}, "",
+			"~ spec.image: web:2 -> web:1\n- spec.ports[2]: 8443\n+ spec.debug: true\n", 1},
+		{"key order is no difference", []string{"--diff", staging, reordered}, "", "", 0},
+		{"a path in only one file", []string{"--diff", staging, prod, "spec.debug"}, "", "- spec.debug: true\n", 1},
+		{"one file from stdin", []string{"--diff", "-", prod, "spec.image"}, "spec: {image: web:2}\n", "", 0},
+		{"a path in neither file is trouble, not a difference", []string{"--diff", staging, prod, "spec.nope"}, "", "", 2},
+		{"merge keys are resolved", []string{"--diff", merged, inline}, "", "", 0},
+		{"--no-merge compares them as written", []string{"--diff", "--no-merge", merged, inline}, "", "- x.<<: {a: 1}\n+ x.a: 1\n", 1},
+		{"output flags don't apply", []string{"--diff", "--json", staging, prod}, "", "", 2},
+		{"nor does -t", []string{"--diff", "-t", staging, prod}, "", "", 2},
+		{"a path to several nodes", []string{"--diff", staging, prod, "spec.*"}, "", "", 2},
+		{"several documents need --doc", []string{"--diff", multi, multi}, "", "", 2},
+		{"--doc picks one", []string{"--diff", "--doc", "1", multi, write("two.yml", "a: 1\n---\na: 3\n")}, "", "~ a: 2 -> 3\n", 1},
+		{"--doc past the last document", []string{"--diff", "--doc", "2", multi, multi}, "", "", 2},
+		{"needs two files", []string{"--diff", staging}, "", "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCLI(t, tt.stdin, tt.args...)
+			if res.exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", res.exitCode, tt.exitCode, res.stderr)
+			}
+			if res.stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLILength(t *testing.T) {
+	const input = "items: [a, b, c]\nmeta: {x: 1, y: 2}\nname: héllo\nnothing: null\nsvc:\n  a: {ports: [80, 443]}\n  b: {ports: [8080]}\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"sequence counts elements", []string{"--length", "items"}, "3\n"},
+		{"mapping counts keys", []string{"--length", "meta"}, "2\n"},
+		{"scalar counts characters", []string{"--length", "name"}, "5\n"},
+		{"null is zero", []string{"--length", "nothing"}, "0\n"},
+		{"one count per match", []string{"--length", "svc.*.ports"}, "2\n1\n"},
+		{"one count per pattern", []string{"--length", "items", "meta", "svc"}, "3\n2\n2\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+}
+
+func TestCLICount(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"a single node is one", []string{"--count", "services", "test/docker-compose.yml"}, "1\n"},
+		{"wildcard counts matches", []string{"--count", "services.*.image", "test/docker-compose.yml"}, "3\n"},
+		{"recursive descent counts matches", []string{"--count", "..image", "test/docker-compose.yml"}, "3\n"},
+		{"[*] counts elements", []string{"--count", "services.web.ports[*]", "test/docker-compose.yml"}, "2\n"},
+		{"a slice counts the elements it selects", []string{"--count", "services.web.ports[0:1]", "test/docker-compose.yml"}, "1\n"},
+		{"a missing path is zero", []string{"--count", "services.nope", "test/docker-compose.yml"}, "0\n"},
+		{"one line per pattern", []string{"--count", "-f", "test/docker-compose.yml", "services.*", "services.nope"}, "4\n0\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	// --count used to count a single node's children, as --length does, and
+	// to exit 1 on a missing path; now it counts matches either way
+	t.Run("counts matches, not children", func(t *testing.T) {
+		for _, tc := range []struct {
+			args  []string
+			count string
+			size  string
+		}{
+			{[]string{"services", "test/docker-compose.yml"}, "1\n", "4\n"},
+			{[]string{"services.web.ports", "test/docker-compose.yml"}, "1\n", "2\n"},
+		} {
+			count := runCLI(t, "", append([]string{"--count"}, tc.args...)...)
+			length := runCLI(t, "", append([]string{"--length"}, tc.args...)...)
+			if count.stdout != tc.count || length.stdout != tc.size {
+				t.Errorf("%s: --count = %q, --length = %q; want %q and %q", tc.args[0], count.stdout, length.stdout, tc.count, tc.size)
+			}
+		}
+		res := runCLI(t, "", "--count", "services.nope", "test/docker-compose.yml")
+		if res.exitCode != 0 || res.stdout != "0\n" {
+			t.Errorf("missing path: exit code = %d, stdout = %q; want 0 and 0", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("a path that doesn't fit the document is still an error", func(t *testing.T) {
+		res := runCLI(t, "", "--count", "services.web.image.tag", "test/docker-compose.yml")
+		if res.exitCode != 1 || res.stdout != "" {
+			t.Errorf("exit code = %d, stdout = %q, want 1 and nothing", res.exitCode, res.stdout)
+		}
+	})
+}
+
+func TestCLIKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"YAML sequence in document order", []string{"--keys", "services", "test/docker-compose.yml"}, "- web\n- app\n- db\n- cache\n"},
+		{"--raw prints plain lines", []string{"--keys", "-r", "services", "test/docker-compose.yml"}, "web\napp\ndb\ncache\n"},
+		{"--keys-sorted", []string{"--keys-sorted", "-r", "services", "test/docker-compose.yml"}, "app\ncache\ndb\nweb\n"},
+		{"JSON", []string{"--keys", "--json", "--json-indent", "0", "services", "test/docker-compose.yml"}, "[\"web\",\"app\",\"db\",\"cache\"]\n"},
+		{"--values", []string{"--values", "database.credentials", "test/simple.yml"}, "- admin\n- secret123\n"},
+		{"--values --raw", []string{"--values", "-r", "database.credentials", "test/simple.yml"}, "admin\nsecret123\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, "", tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout = %q, want %q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("sequence is an error", func(t *testing.T) {
+		res := runCLI(t, "", "--keys", "services.web.ports", "test/docker-compose.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if want := "Error: --keys needs a mapping, but services.web.ports is a sequence\n"; res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("--values of a sequence is an error", func(t *testing.T) {
+		res := runCLI(t, "", "--values", "services.web.ports", "test/docker-compose.yml")
+		if want := "Error: --values needs a mapping, but services.web.ports is a sequence\n"; res.exitCode != 1 || res.stderr != want {
+			t.Errorf("exit code = %d, stderr = %q; want 1 and %q", res.exitCode, res.stderr, want)
+		}
+	})
+
+	t.Run("--keys and --values together", func(t *testing.T) {
+		res := runCLI(t, "", "--keys", "--values", "services", "test/docker-compose.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+}
+
+func TestCLIExists(t *testing.T) {
+	const input = "name: web\ntls: null\nports: [80, 443]\nsvc:\n  a: {image: nginx}\n  b: {}\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"present key", []string{"--exists", "name"}, 0},
+		{"explicit null exists", []string{"--exists", "tls"}, 0},
+		{"short flag", []string{"-e", "tls"}, 0},
+		{"short flag on a missing key", []string{"-e", "spec.tls"}, 1},
+		{"missing key", []string{"--exists", "spec.tls"}, 1},
+		{"wildcard with one match", []string{"--exists", "svc.*.image"}, 0},
+		{"recursive descent", []string{"--exists", "..image"}, 0},
+		{"misused selector is just a miss", []string{"--exists", "svc[name=a]"}, 1},
+		{"every pattern must match", []string{"--exists", "-f", "-", "name", "nope"}, 1},
+		{"union needs one member", []string{"--exists", "nope, name"}, 0},
+		{"--strict union needs all members", []string{"--exists", "--strict", "nope, name"}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != tc.want {
+				t.Errorf("exit code = %d, want %d; stderr=%q", res.exitCode, tc.want, res.stderr)
+			}
+			if res.stdout != "" || res.stderr != "" {
+				t.Errorf("--exists printed stdout=%q stderr=%q, want nothing", res.stdout, res.stderr)
+			}
+		})
+	}
+
+	t.Run("unparseable input is an input error", func(t *testing.T) {
+		res := runCLI(t, "a: [1, 2\n", "--exists", "a")
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3", res.exitCode)
+		}
+		if res.stdout != "" {
+			t.Errorf("stdout = %q, want nothing", res.stdout)
+		}
+	})
+}
+
+func TestCLISet(t *testing.T) {
+	const config = "# app config\nimage:\n  repository: nginx # the image\n  tag: \"1.25\"\nreplicas: 3\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"keeps comments and quoting", []string{"--set", "image.tag=v2.0"},
+			"# app config\nimage:\n  repository: nginx # the image\n  tag: \"v2.0\"\nreplicas: 3\n"},
+		{"plain scalar takes the new type", []string{"--set", "replicas=auto"},
+			"# app config\nimage:\n  repository: nginx # the image\n  tag: \"1.25\"\nreplicas: auto\n"},
+		{"--plain drops the quotes", []string{"--plain", "--set", "image.tag=2"},
+			"# app config\nimage:\n  repository: nginx # the image\n  tag: 2\nreplicas: 3\n"},
+		{"--create builds missing maps", []string{"--create", "--set", "resources.limits.cpu=500m"},
+			"# app config\nimage:\n  repository: nginx # the image\n  tag: \"1.25\"\nreplicas: 3\nresources:\n  limits:\n    cpu: 500m\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, config, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("missing path is an error without --create", func(t *testing.T) {
+		res := runCLI(t, config, "--set", "resources.limits.cpu=1")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		want := "Error: path not found: resources.limits.cpu (use --create to add it)\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("non-scalar target is an error", func(t *testing.T) {
+		res := runCLI(t, config, "--set", "image=nginx:1.25")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		want := "Error: cannot set image: it is a mapping, not a scalar\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
 		}
 	})
 
-	t.Run("extracting an array element from JSON preserves flow style", func(t *testing.T) {
-		res := runCLI(t, "", "services[1]", "test/config.json")
-		if res.exitCode != 0 {
-			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+	t.Run("--in-place writes back to the file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "values.yml")
+		if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
 		}
-		want := "{\"services\": [\"api\"]}\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		res := runCLI(t, "", "-i", "--set", "image.repository=httpd", file)
+		if res.exitCode != 0 || res.stdout != "" {
+			t.Fatalf("exit code = %d, stdout = %q, want 0 and no output; stderr=%q", res.exitCode, res.stdout, res.stderr)
+		}
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "# app config\nimage:\n  repository: httpd # the image\n  tag: \"1.25\"\nreplicas: 3\n"
+		if string(got) != want {
+			t.Errorf("file =\n%q\nwant:\n%q", got, want)
 		}
 	})
 
-	t.Run("--flow forces flow-style output on a block YAML source", func(t *testing.T) {
-		res := runCLI(t, "", "--flow", "database", "test/simple.yml")
-		if res.exitCode != 0 {
-			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+	t.Run("--plain needs --set", func(t *testing.T) {
+		res := runCLI(t, config, "--plain", "image")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
 		}
-		want := "{database: {host: localhost, port: 5432, timeout: 30, credentials: {user: admin, password: secret123}}}\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+	})
+
+	t.Run("--in-place needs a file", func(t *testing.T) {
+		res := runCLI(t, config, "-i", "--set", "replicas=1")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
 		}
 	})
 
-	t.Run("--block forces clean block-style output on a JSON source, no leftover quotes", func(t *testing.T) {
-		res := runCLI(t, "", "--block", "database", "test/config.json")
-		if res.exitCode != 0 {
-			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+	t.Run("--doc past the last document is a usage error", func(t *testing.T) {
+		res := runCLI(t, config, "--doc", "1", "--set", "replicas=1")
+		if res.exitCode != 2 || res.stdout != "" {
+			t.Errorf("exit code = %d, stdout = %q; want 2 and nothing", res.exitCode, res.stdout)
 		}
-		want := "database:\n    host: localhost\n    port: 5432\n    credentials:\n" +
-			"        user: admin\n        password: secret123\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+		want := "Error: --doc 1 out of range (input has 1 document(s))\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
 		}
 	})
+}
 
-	t.Run("-j short flag behaves like --flow", func(t *testing.T) {
-		res := runCLI(t, "", "-j", "database", "test/simple.yml")
-		if res.exitCode != 0 {
-			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+func TestCLIDelete(t *testing.T) {
+	const config = "# app config\n\nimage:\n  repository: nginx # the image\n  tag: \"1.25\"\nports: [80, 443, 8080]\n"
+
+	t.Run("removes a mapping entry", func(t *testing.T) {
+		res := runCLI(t, config, "-d", "image.tag")
+		want := "# app config\n\nimage:\n  repository: nginx # the image\nports: [80, 443, 8080]\n"
+		if res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
 		}
-		want := "{database: {host: localhost, port: 5432, timeout: 30, credentials: {user: admin, password: secret123}}}\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+	})
+
+	t.Run("splices a sequence element", func(t *testing.T) {
+		res := runCLI(t, config, "--delete", "ports[1]")
+		want := "# app config\n\nimage:\n  repository: nginx # the image\n  tag: \"1.25\"\nports: [80, 8080]\n"
+		if res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
 		}
 	})
 
-	t.Run("-y short flag behaves like --block", func(t *testing.T) {
-		res := runCLI(t, "", "-y", "database", "test/config.json")
-		if res.exitCode != 0 {
-			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+	t.Run("missing path is a no-op", func(t *testing.T) {
+		res := runCLI(t, "a: 1\n", "-d", "b")
+		if res.exitCode != 0 || res.stdout != "a: 1\n" {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and the input unchanged", res.exitCode, res.stdout)
 		}
-		want := "database:\n    host: localhost\n    port: 5432\n    credentials:\n" +
-			"        user: admin\n        password: secret123\n"
-		if res.stdout != want {
-			t.Errorf("stdout = %q, want %q", res.stdout, want)
+	})
+
+	t.Run("missing path fails under --strict", func(t *testing.T) {
+		res := runCLI(t, "a: 1\n", "-d", "--strict", "b")
+		if res.exitCode != 1 || res.stderr != "Path not found: b\n" {
+			t.Errorf("exit code = %d, stderr = %q, want 1 and Path not found", res.exitCode, res.stderr)
 		}
 	})
 
-	t.Run("--flow and --block together is an error on stderr", func(t *testing.T) {
-		res := runCLI(t, "", "--flow", "--block", "database", "test/simple.yml")
-		if res.exitCode != 1 {
-			t.Errorf("exit code = %d, want 1", res.exitCode)
+	t.Run("--in-place writes back to the file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "values.yml")
+		if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
 		}
-		if res.stdout != "" {
-			t.Errorf("stdout = %q, want empty", res.stdout)
+		res := runCLI(t, "", "-d", "-i", "image", file)
+		if res.exitCode != 0 || res.stdout != "" {
+			t.Fatalf("exit code = %d, stdout = %q, want 0 and no output; stderr=%q", res.exitCode, res.stdout, res.stderr)
 		}
-		want := "Error: --flow/-j and --block/-y are mutually exclusive\n"
-		if res.stderr != want {
-			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "# app config\n\nports: [80, 443, 8080]\n"; string(got) != want {
+			t.Errorf("file = %q, want %q", got, want)
 		}
 	})
+}
 
-	t.Run("mixing the long and short forms is still an error", func(t *testing.T) {
-		res := runCLI(t, "", "-j", "-y", "database", "test/simple.yml")
+func TestCLIMerge(t *testing.T) {
+	const config = "# app config\nspec:\n  replicas: 2 # by hand\n  ports: [80]\n"
+	dir := t.TempDir()
+	patch := filepath.Join(dir, "patch.yml")
+	if err := os.WriteFile(patch, []byte("replicas: 3\nports: [443]\nimage: web:2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"merges into the path", []string{"--merge", ".spec", patch},
+			"# app config\nspec:\n  replicas: 3 # by hand\n  ports: [443]\n  image: web:2\n"},
+		{"--merge-seq append", []string{"--merge", ".spec", "--merge-seq", "append", patch},
+			"# app config\nspec:\n  replicas: 3 # by hand\n  ports: [80, 443]\n  image: web:2\n"},
+		{"--sort-keys after a merge", []string{"--merge", ".spec", "--sort-keys", patch},
+			"# app config\nspec:\n  image: web:2\n  ports: [443]\n  replicas: 3 # by hand\n"},
+		{"merges into the document root", []string{"--merge", ".", patch},
+			"# app config\nspec:\n  replicas: 2 # by hand\n  ports: [80]\nreplicas: 3\nports: [443]\nimage: web:2\n"},
+		{"--create builds a missing path", []string{"--merge", "status.spec", "--create", patch},
+			"# app config\nspec:\n  replicas: 2 # by hand\n  ports: [80]\nstatus:\n  spec:\n    replicas: 3\n    ports: [443]\n    image: web:2\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, config, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("missing path is an error without --create", func(t *testing.T) {
+		res := runCLI(t, config, "--merge", "status", patch)
+		want := "Error: path not found: status (use --create to add it)\n"
+		if res.exitCode != 1 || res.stderr != want {
+			t.Errorf("exit code = %d, stderr = %q, want 1 and %q", res.exitCode, res.stderr, want)
+		}
+	})
+
+	t.Run("unknown --merge-seq is a usage error", func(t *testing.T) {
+		res := runCLI(t, config, "--merge", "spec", "--merge-seq", "prepend", patch)
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2; stderr=%q", res.exitCode, res.stderr)
+		}
+	})
+
+	t.Run("--in-place writes back to the file", func(t *testing.T) {
+		file := filepath.Join(dir, "values.yml")
+		if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		res := runCLI(t, "", "--merge", "spec", "-i", patch, file)
+		if res.exitCode != 0 || res.stdout != "" {
+			t.Fatalf("exit code = %d, stdout = %q, want 0 and no output; stderr=%q", res.exitCode, res.stdout, res.stderr)
+		}
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "# app config\nspec:\n  replicas: 3 # by hand\n  ports: [443]\n  image: web:2\n"
+		if string(got) != want {
+			t.Errorf("file =\n%q\nwant:\n%q", got, want)
+		}
+	})
+}
+
+func TestCLIMultiplePatterns(t *testing.T) {
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"results in order, trimmed scalars one per line", "",
+			[]string{"-t", "-f", "test/simple.yml", "app.name", ".app.version", "database.port"}, "MyApp\n1.2.3\n5432\n"},
+		{"collections are separated by ---", "",
+			[]string{"-t", "-f", "test/simple.yml", "app.name", "database.credentials"}, "MyApp\n---\nuser: admin\npassword: secret123\n"},
+		{"wrapped results are separated by ---", "",
+			[]string{"-f", "test/simple.yml", "app.name", "cache.ttl"}, "app:\n  name: MyApp\n---\ncache:\n  ttl: 3600\n"},
+		{"three or more patterns read stdin", "a: 1\nb: 2\nc: 3\n", []string{"-t", "c", "a", "b"}, "3\n1\n2\n"},
+		{"--labels prints a mapping per document", "",
+			[]string{"--labels", "-f", "test/multi-doc.yml", ".kind", "metadata.name"},
+			"kind: Deployment\nmetadata.name: web\n---\nkind: Service\nmetadata.name: web-svc\n---\nkind: ConfigMap\nmetadata.name: web-config\n"},
+		{"--labels collects several matches into a sequence", "",
+			[]string{"--labels", "-f", "test/arrays.yml", "users[*].name"}, "users[*].name:\n  - Alice\n  - Bob\n  - Charlie\n"},
+		{"pipe stages build on the previous matches", "",
+			[]string{"users[*] | .roles[0]", "test/arrays.yml"}, "users:\n  - roles:\n      - admin\n  - roles:\n      - user\n  - roles:\n      - user\n"},
+		{"pipe into keys", "", []string{"database | keys", "test/simple.yml"}, "- host\n- port\n- timeout\n- credentials\n"},
+		{"pipe into length", "", []string{"users[*].roles | length", "test/arrays.yml"}, "2\n1\n2\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("union members print in order, each wrapped", func(t *testing.T) {
+		res := runCLI(t, "", ".metadata.name, .spec.replicas", "test/kubernetes.yml")
+		want := "metadata:\n  name: nginx-deployment\n---\nspec:\n  replicas: 3\n"
+		if res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q", res.exitCode, res.stdout, want)
+		}
+	})
+
+	t.Run("union succeeds if any member matches", func(t *testing.T) {
+		res := runCLI(t, "", "-t", "metadata.name, nope", "test/kubernetes.yml")
+		if res.exitCode != 0 || res.stdout != "nginx-deployment\n" || res.stderr != "" {
+			t.Errorf("exit code = %d, stdout = %q, stderr = %q, want 0 and just the match", res.exitCode, res.stdout, res.stderr)
+		}
+	})
+
+	t.Run("union fails when no member matches", func(t *testing.T) {
+		res := runCLI(t, "", "-t", "x, y", "test/kubernetes.yml")
+		if res.exitCode != 1 || res.stderr != "Path not found: x, y\n" {
+			t.Errorf("exit code = %d, stderr = %q, want 1 and Path not found: x, y", res.exitCode, res.stderr)
+		}
+	})
+
+	t.Run("--strict requires every union member", func(t *testing.T) {
+		res := runCLI(t, "", "--strict", "-t", "metadata.name, nope", "test/kubernetes.yml")
+		if res.exitCode != 1 || res.stderr != "Path not found: nope\n" {
+			t.Errorf("exit code = %d, stderr = %q, want 1 and Path not found: nope", res.exitCode, res.stderr)
+		}
+		if res.stdout != "nginx-deployment\n" {
+			t.Errorf("stdout = %q, want the matched member still printed", res.stdout)
+		}
+	})
+
+	t.Run("fallback uses the first present path", func(t *testing.T) {
+		res := runCLI(t, "", "metadata.annotations // metadata.labels.app", "test/kubernetes.yml")
+		want := "metadata:\n  labels:\n    app: nginx\n"
+		if res.exitCode != 0 || res.stdout != want {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and %q; stderr=%q", res.exitCode, res.stdout, want, res.stderr)
+		}
+	})
+
+	t.Run("fallback to a literal default", func(t *testing.T) {
+		res := runCLI(t, "timeout: null\n", "-t", ".timeout // 30")
+		if res.exitCode != 0 || res.stdout != "30\n" {
+			t.Errorf("exit code = %d, stdout = %q, want 0 and 30", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("missing patterns are reported but the rest still print", func(t *testing.T) {
+		res := runCLI(t, "", "-t", "-f", "test/simple.yml", "app.name", "nope", "cache.ttl")
 		if res.exitCode != 1 {
 			t.Errorf("exit code = %d, want 1", res.exitCode)
 		}
-		want := "Error: --flow/-j and --block/-y are mutually exclusive\n"
-		if res.stderr != want {
-			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		if res.stdout != "MyApp\n3600\n" {
+			t.Errorf("stdout = %q, want %q", res.stdout, "MyApp\n3600\n")
+		}
+		if res.stderr != "Path not found: nope\n" {
+			t.Errorf("stderr = %q, want %q", res.stderr, "Path not found: nope\n")
+		}
+	})
+}
+
+func TestCLIFileFlag(t *testing.T) {
+	simple, err := os.ReadFile("test/simple.yml")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"-f before the pattern", "", []string{"-t", "-f", "test/simple.yml", "app.name"}, "MyApp\n"},
+		{"flags after the pattern", "", []string{"app.name", "-t", "--file", "test/simple.yml"}, "MyApp\n"},
+		{"-f - reads stdin", string(simple), []string{"-t", "-f", "-", "app.version"}, "1.2.3\n"},
+		{"positional filename still works", "", []string{"-t", "app.name", "test/simple.yml"}, "MyApp\n"},
+		{"flags after a positional filename", "", []string{"app.name", "test/simple.yml", "-t"}, "MyApp\n"},
+		{"-- ends the flags", "-x: 1\n", []string{"-t", "--", "-x"}, "1\n"},
+		{"--set reads -f", "", []string{"--set", "cache.ttl=60", "-f", "test/simple.yml"},
+			"app:\n  name: MyApp\n  version: 1.2.3\n  debug: false\n" +
+				"database:\n  host: localhost\n  port: 5432\n  timeout: 30\n" +
+				"  credentials:\n    user: admin\n    password: secret123\n" +
+				"cache:\n  enabled: true\n  ttl: 60\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, tc.stdin, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+
+	t.Run("--help shows the usage line", func(t *testing.T) {
+		res := runCLI(t, "", "--help")
+		if res.exitCode != 0 {
+			t.Errorf("exit code = %d, want 0", res.exitCode)
+		}
+		if !bytes.HasPrefix([]byte(res.stderr), []byte("Usage: gy [flags] [pattern...] [-f|--file FILE]\n")) {
+			t.Errorf("stderr = %q, want it to start with the usage line", res.stderr)
 		}
 	})
 }
 
+func TestCLIComments(t *testing.T) {
+	const input = "# top\ndb:\n  # the host\n  host: localhost # inline\n  port: 5432\nservers:\n  # the first\n  - name: a # primary\n    port: 1\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"wrapped output keeps comments", []string{"db.host"}, "# top\ndb:\n  # the host\n  host: localhost # inline\n"},
+		{"trimmed output keeps comments", []string{"-t", "db.host"}, "# the host\nlocalhost # inline\n"},
+		{"a rebuilt element keeps its comment", []string{"servers[0].port"}, "servers:\n  # the first\n  - port: 1\n"},
+		{"--no-comments strips them", []string{"--no-comments", "db"}, "db:\n  host: localhost\n  port: 5432\n"},
+		{"--no-comments strips a rebuilt element's", []string{"--no-comments", "servers[0].name"}, "servers:\n  - name: a\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := runCLI(t, input, tc.args...)
+			if res.exitCode != 0 {
+				t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
+			}
+			if res.stdout != tc.want {
+				t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, tc.want)
+			}
+		})
+	}
+}
+
 func TestCLIStdinAndPiping(t *testing.T) {
 	t.Run("reads from stdin when only a pattern is given", func(t *testing.T) {
 		simple, err := os.ReadFile("test/simple.yml")
@@ -244,7 +2499,7 @@ func TestCLIStdinAndPiping(t *testing.T) {
 		if res.exitCode != 0 {
 			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
 		}
-		want := "app:\n    name: MyApp\n"
+		want := "app:\n  name: MyApp\n"
 		if res.stdout != want {
 			t.Errorf("stdout = %q, want %q", res.stdout, want)
 		}
@@ -265,10 +2520,10 @@ func TestCLIStdinAndPiping(t *testing.T) {
 		if res.exitCode != 0 {
 			t.Fatalf("exit code = %d, want 0; stderr=%q", res.exitCode, res.stderr)
 		}
-		want := "app:\n    name: MyApp\n    version: 1.2.3\n    debug: false\n" +
-			"database:\n    host: localhost\n    port: 5432\n    timeout: 30\n" +
-			"    credentials:\n        user: admin\n        password: secret123\n" +
-			"cache:\n    enabled: true\n    ttl: 3600\n"
+		want := "app:\n  name: MyApp\n  version: 1.2.3\n  debug: false\n" +
+			"database:\n  host: localhost\n  port: 5432\n  timeout: 30\n" +
+			"  credentials:\n    user: admin\n    password: secret123\n" +
+			"cache:\n  enabled: true\n  ttl: 3600\n"
 		if res.stdout != want {
 			t.Errorf("stdout =\n%q\nwant:\n%q", res.stdout, want)
 		}
@@ -286,15 +2541,15 @@ func TestCLIFlagsAndVersion(t *testing.T) {
 }
 
 func TestCLIErrorHandling(t *testing.T) {
-	t.Run("more than two positional args prints usage on stderr and exits 1", func(t *testing.T) {
-		res := runCLI(t, "", "a", "b", "c")
-		if res.exitCode != 1 {
-			t.Errorf("exit code = %d, want 1", res.exitCode)
+	t.Run("several patterns with a positional filename exit 2 asking for -f", func(t *testing.T) {
+		res := runCLI(t, "", "a", "b", "test/simple.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
 		}
 		if res.stdout != "" {
 			t.Errorf("stdout = %q, want empty (usage error belongs on stderr)", res.stdout)
 		}
-		want := "Usage: gy [--trim|-t] [--list|-l] [--depth N] [--flow|-j] [--block|-y] [pattern] [filename]\n"
+		want := "Error: with several patterns, name the input file with -f (gy -f test/simple.yml ...)\n"
 		if res.stderr != want {
 			t.Errorf("stderr = %q, want %q", res.stderr, want)
 		}
@@ -313,15 +2568,127 @@ func TestCLIErrorHandling(t *testing.T) {
 		}
 	})
 
-	t.Run("missing input file exits 1 with a clean message, no panic", func(t *testing.T) {
-		res := runCLI(t, "", "a", "test/does-not-exist.yml")
+	t.Run("negative index past the start is not found", func(t *testing.T) {
+		res := runCLI(t, "", "users[-4]", "test/arrays.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if res.stderr != "Path not found: users[-4]\n" {
+			t.Errorf("stderr = %q, want %q", res.stderr, "Path not found: users[-4]\n")
+		}
+	})
+
+	t.Run("a zero slice step is a usage error", func(t *testing.T) {
+		res := runCLI(t, "", "users[::0]", "test/arrays.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		if want := "Error: slice step can't be zero in [::0]\n"; res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("an index into a mapping points at the key instead", func(t *testing.T) {
+		res := runCLI(t, "history:\n  2023: a\n", "history[2023]")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		if want := "Error: [2023] indexes a sequence, but history is a mapping; write history.2023 for the key 2023\n"; res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("an integer key is rebuilt as an integer", func(t *testing.T) {
+		res := runCLI(t, "history:\n  2023: a\n  0x10: b\n", "history.16")
+		if res.exitCode != 0 || res.stdout != "history:\n  0x10: b\n" {
+			t.Errorf("exit code = %d, stdout = %q; want 0 and the 0x10 key", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("unterminated quote in the pattern is a usage error, not a silent miss", func(t *testing.T) {
+		res := runCLI(t, "", `metadata."app.kubernetes.io/name`, "test/kubernetes.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		want := "Error: unterminated quote in pattern at offset 9\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("a path that doesn't fit the document says where", func(t *testing.T) {
+		for pattern, want := range map[string]string{
+			"metadata.name.first":                "Error: first looks up a key, but metadata.name is a scalar\n",
+			"metadata.name[0]":                   "Error: [0] selects from a sequence, but metadata.name is a scalar\n",
+			"spec.template.spec.containers.name": "Error: name looks up a key, but spec.template.spec.containers is a sequence\n",
+		} {
+			res := runCLI(t, "", pattern, "test/kubernetes.yml")
+			if res.exitCode != 1 || res.stderr != want {
+				t.Errorf("%s: exit code = %d, stderr = %q; want 1 and %q", pattern, res.exitCode, res.stderr, want)
+			}
+		}
+		res := runCLI(t, "", "--default", "x", "metadata.name.first", "test/kubernetes.yml")
+		if res.exitCode != 1 || res.stdout != "" {
+			t.Errorf("--default: exit code = %d, stdout = %q; want 1 and no default for a mismatch", res.exitCode, res.stdout)
+		}
+	})
+
+	t.Run("a malformed pattern is a usage error pointing at the typo", func(t *testing.T) {
+		for pattern, want := range map[string]string{
+			".spec[abc].replicas":  "Error: invalid index \"abc\" at offset 6\n",
+			"spec.template[[0]":    "Error: unclosed '[' at offset 13\n",
+			"metadata.name, spec]": "Error: stray ']' at offset 19\n",
+			"metadata...name":      "Error: empty path segment at offset 10\n",
+		} {
+			res := runCLI(t, "", pattern, "test/kubernetes.yml")
+			if res.exitCode != 2 || res.stderr != want {
+				t.Errorf("%s: exit code = %d, stderr = %q; want 2 and %q", pattern, res.exitCode, res.stderr, want)
+			}
+		}
+	})
+
+	t.Run("select by value on a mapping says why nothing matched", func(t *testing.T) {
+		res := runCLI(t, "", "metadata[name=nginx]", "test/kubernetes.yml")
+		if res.exitCode != 1 {
+			t.Errorf("exit code = %d, want 1", res.exitCode)
+		}
+		want := "Error: [name=nginx] selects from a sequence, but metadata is a mapping\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("--strict rejects out-of-range index list entries", func(t *testing.T) {
+		res := runCLI(t, "", "--strict", "users[0,5].name", "test/arrays.yml")
 		if res.exitCode != 1 {
 			t.Errorf("exit code = %d, want 1", res.exitCode)
 		}
+		want := "Error: index 5 out of range for users (length 3)\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("invalid regex fails fast as a usage error", func(t *testing.T) {
+		res := runCLI(t, "", "jobs.~^(deploy", "test/simple.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
+		}
+		want := "Error: invalid regular expression in ~^(deploy: error parsing regexp: missing closing ): `^(deploy`\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("missing input file exits 3 with a clean message, no panic", func(t *testing.T) {
+		res := runCLI(t, "", "a", "test/does-not-exist.yml")
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3", res.exitCode)
+		}
 		if bytes.Contains([]byte(res.stderr), []byte("panic:")) {
 			t.Errorf("stderr = %q, should not contain a panic trace", res.stderr)
 		}
-		want := "Error: open test/does-not-exist.yml: no such file or directory\n"
+		want := "Error: cannot read test/does-not-exist.yml: no such file or directory\n"
 		if res.stderr != want {
 			t.Errorf("stderr = %q, want %q", res.stderr, want)
 		}
@@ -330,16 +2697,39 @@ func TestCLIErrorHandling(t *testing.T) {
 		}
 	})
 
-	t.Run("malformed YAML exits 1 with a clean parse error, no panic", func(t *testing.T) {
+	t.Run("malformed YAML exits 3 with a clean parse error, no panic", func(t *testing.T) {
 		res := runCLI(t, "key: [unterminated", "a")
-		if res.exitCode != 1 {
-			t.Errorf("exit code = %d, want 1", res.exitCode)
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3", res.exitCode)
 		}
 		if bytes.Contains([]byte(res.stderr), []byte("panic:")) {
 			t.Errorf("stderr = %q, should not contain a panic trace", res.stderr)
 		}
-		if !bytes.Contains([]byte(res.stderr), []byte("Error: failed to parse YAML")) {
-			t.Errorf("stderr = %q, want it to contain a clean parse error", res.stderr)
+		want := "Error: failed to parse YAML in stdin: line 1: did not find expected ',' or ']'\n"
+		if res.stderr != want {
+			t.Errorf("stderr = %q, want %q", res.stderr, want)
+		}
+	})
+
+	t.Run("parse errors name the file and line", func(t *testing.T) {
+		dir := t.TempDir()
+		bad := filepath.Join(dir, "bad.yml")
+		if err := os.WriteFile(bad, []byte("a: 1\nb: [2\nc: 3\n"), 0o644); err != nil {
+			t.Fatalf("failed to write fixture: %v", err)
+		}
+		res := runCLI(t, "", "a", bad)
+		if res.exitCode != 3 {
+			t.Errorf("exit code = %d, want 3", res.exitCode)
+		}
+		if !bytes.HasPrefix([]byte(res.stderr), []byte("Error: failed to parse YAML in "+bad+": line ")) {
+			t.Errorf("stderr = %q, want a parse error naming %s and the line", res.stderr, bad)
+		}
+	})
+
+	t.Run("unknown flag is a usage error", func(t *testing.T) {
+		res := runCLI(t, "", "--no-such-flag", "a", "test/simple.yml")
+		if res.exitCode != 2 {
+			t.Errorf("exit code = %d, want 2", res.exitCode)
 		}
 	})
 }
@@ -0,0 +1,278 @@
+// Colored output for gy: --color paints list mode's keys, values and types,
+// and highlights YAML output after it's encoded, following the node it
+// came from, so color never changes what is printed, only how it looks.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ANSI colors for each kind of text.
+const (
+	colorKey     = "\x1b[34m" // blue
+	colorValue   = "\x1b[32m" // green
+	colorType    = "\x1b[33m" // yellow
+	colorNumber  = "\x1b[36m" // cyan
+	colorBool    = "\x1b[35m" // magenta, for true, false and null
+	colorAnchor  = "\x1b[31m" // red, for anchors, aliases and tags
+	colorComment = "\x1b[90m" // grey
+	colorAdded   = "\x1b[32m" // green, for --diff
+	colorRemoved = "\x1b[31m" // red
+	colorChanged = "\x1b[33m" // yellow
+	colorReset   = "\x1b[0m"
+)
+
+// useColor resolves --color: "always" and "never" say so, and "auto" colors
+// only a terminal, unless NO_COLOR is set or TERM is dumb.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		return isTerminal(os.Stdout), nil
+	}
+	return false, fmt.Errorf("--color must be auto, always or never, got %q", mode)
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in color when on is set. Empty text stays empty.
+func paint(s, color string, on bool) string {
+	if !on || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// highlightYAML colors text, node encoded, by walking node and finding
+// each of its tokens in turn: keys, strings, numbers, booleans and null,
+// anchors, aliases and tags, and the comments between them. The node says
+// what each token is, and the text only where it ends, so a quoted key
+// with a colon in it or a string over several lines is colored as the one
+// token it is. Anything it can't place is left as it was.
+func highlightYAML(text string, node *yaml.Node) string {
+	h := &highlighter{text: text}
+	if h.node(node, false) {
+		h.skip()
+	}
+	h.b.WriteString(h.text[h.pos:])
+	return h.b.String()
+}
+
+// highlighter is highlightYAML's place in the text and what it has
+// written so far.
+type highlighter struct {
+	text string
+	pos  int
+	b    strings.Builder
+}
+
+// node colors n's tokens, reporting false if the text isn't what n would
+// encode as.
+func (h *highlighter) node(n *yaml.Node, key bool) bool {
+	h.skip()
+	// An anchor and a tag come before what they're on
+	for h.pos < len(h.text) && (h.text[h.pos] == '&' || h.text[h.pos] == '!') {
+		h.write(h.tokenEnd(), colorAnchor)
+		h.skip()
+	}
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			if !h.node(child, false) {
+				return false
+			}
+		}
+	case yaml.MappingNode:
+		for i, child := range n.Content {
+			if !h.node(child, i%2 == 0) {
+				return false
+			}
+		}
+	case yaml.AliasNode:
+		if !strings.HasPrefix(h.text[h.pos:], "*") {
+			return false
+		}
+		h.write(h.tokenEnd(), colorAnchor)
+	case yaml.ScalarNode:
+		return h.scalar(n, key)
+	}
+	return true
+}
+
+// scalar colors n, which starts at h.pos, by its tag, or as a key.
+func (h *highlighter) scalar(n *yaml.Node, key bool) bool {
+	color := colorValue
+	switch {
+	case key:
+		color = colorKey
+	case n.ShortTag() == "!!int" || n.ShortTag() == "!!float":
+		color = colorNumber
+	case n.ShortTag() == "!!bool" || n.ShortTag() == "!!null":
+		color = colorBool
+	}
+	rest := h.text[h.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'"):
+		end := closingQuote(rest)
+		if end < 0 {
+			return false
+		}
+		h.write(h.pos+end+1, color)
+	case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+		h.write(h.tokenEnd(), color)
+		h.blockScalar(n.Value, color)
+	case strings.HasPrefix(rest, n.Value):
+		// A plain scalar is written as it is; a null can be nothing at all
+		h.write(h.pos+len(n.Value), color)
+	default:
+		return false
+	}
+	return true
+}
+
+// blockScalar colors the lines of a `|` or `>` scalar, after its header.
+// Its lines are indented as far as its first, less any spaces value itself
+// starts with; the first line indented less than that is past its end.
+func (h *highlighter) blockScalar(value, color string) {
+	// The rest of the header line can only be a comment
+	h.skipLine()
+	first := strings.TrimLeft(value, "\n")
+	lead := len(first) - len(strings.TrimLeft(first, " "))
+	indent := -1
+	for h.pos < len(h.text) {
+		line := h.text[h.pos:]
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end+1]
+		}
+		content := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(content) != "" {
+			if indent < 0 {
+				indent = len(line) - len(content) - lead
+			}
+			if len(line)-len(content) < indent {
+				return
+			}
+		}
+		h.b.WriteString(line[:len(line)-len(content)])
+		h.pos += len(line) - len(content)
+		h.write(h.pos+len(strings.TrimSuffix(content, "\n")), color)
+		h.skipLine()
+	}
+}
+
+// skip writes out what comes between two nodes: space, line breaks,
+// comments, indicators such as `- ` and `: `, flow brackets and commas,
+// and document markers.
+func (h *highlighter) skip() {
+	for h.pos < len(h.text) {
+		rest := h.text[h.pos:]
+		lineStart := h.pos == 0 || h.text[h.pos-1] == '\n'
+		switch {
+		case rest[0] == ' ' || rest[0] == '\n' || strings.IndexByte(",[]{}", rest[0]) >= 0:
+			h.b.WriteByte(rest[0])
+			h.pos++
+		case rest[0] == '#':
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			h.write(h.pos+end, colorComment)
+		case lineStart && (isIndicator(rest, "---") || isIndicator(rest, "...")):
+			h.b.WriteString(rest[:3])
+			h.pos += 3
+		case isIndicator(rest, "-") || isIndicator(rest, "?") || isIndicator(rest, ":"):
+			h.b.WriteByte(rest[0])
+			h.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipLine writes out the rest of the line, which can only be space and a
+// comment, and its line break.
+func (h *highlighter) skipLine() {
+	for h.pos < len(h.text) && h.text[h.pos] != '\n' {
+		if h.text[h.pos] == '#' {
+			end := strings.IndexByte(h.text[h.pos:], '\n')
+			if end < 0 {
+				end = len(h.text) - h.pos
+			}
+			h.write(h.pos+end, colorComment)
+			continue
+		}
+		h.b.WriteByte(h.text[h.pos])
+		h.pos++
+	}
+	if h.pos < len(h.text) {
+		h.b.WriteByte('\n')
+		h.pos++
+	}
+}
+
+// isIndicator reports whether text starts with the indicator s standing
+// alone, followed by a space, a line break or nothing.
+func isIndicator(text, s string) bool {
+	if !strings.HasPrefix(text, s) {
+		return false
+	}
+	return len(text) == len(s) || text[len(s)] == ' ' || text[len(s)] == '\n'
+}
+
+// tokenEnd is where the token at h.pos - an anchor, alias, tag or block
+// scalar header - ends.
+func (h *highlighter) tokenEnd() int {
+	end := h.pos
+	for end < len(h.text) && strings.IndexByte(" \n,]}", h.text[end]) < 0 {
+		end++
+	}
+	return end
+}
+
+// write paints the text up to end in color, a line at a time so no color
+// runs on past a line break into the next line's indentation.
+func (h *highlighter) write(end int, color string) {
+	for i, line := range strings.Split(h.text[h.pos:end], "\n") {
+		if i > 0 {
+			h.b.WriteByte('\n')
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		h.b.WriteString(line[:len(line)-len(trimmed)] + paint(trimmed, color, true))
+	}
+	h.pos = end
+}
+
+// closingQuote returns the index of the quote closing the string text
+// opens, or -1. A double-quoted string escapes with a backslash, a single-
+// quoted one by doubling the quote.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
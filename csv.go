@@ -0,0 +1,132 @@
+// Table output for gy: --output csv and --output tsv print a sequence of
+// mappings as rows under a header of their keys, for spreadsheets and
+// tools like cut and awk.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// tableRows lays matches out as a table, header first. A single match
+// that's a sequence gives a row per element; otherwise each match is a row
+// itself, so `hosts[*]` works as well as `hosts`. Rows of mappings have a
+// column for every key any of them has, in the order they're first seen;
+// rows of scalars make a single column named value. A mix of the two, or
+// an element that's a sequence, is an error.
+func tableRows(matches []yamlpath.Match, opts jsonOptions) ([][]string, error) {
+	var rows []*yaml.Node
+	var paths [][]string
+	if len(matches) == 1 && tableNode(matches[0].Node).Kind == yaml.SequenceNode {
+		for i, item := range tableNode(matches[0].Node).Content {
+			rows = append(rows, tableNode(item))
+			paths = append(paths, append(append([]string{}, matches[0].Path...), fmt.Sprintf("[%d]", i)))
+		}
+	} else {
+		for _, m := range matches {
+			rows = append(rows, tableNode(m.Node))
+			paths = append(paths, m.Path)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	var header []string
+	column := map[string]int{}
+	scalars := 0
+	for i, row := range rows {
+		switch row.Kind {
+		case yaml.MappingNode:
+			content := row.Content
+			if !opts.noMerge {
+				content = yamlpath.MergedContent(row)
+			}
+			for j := 0; j+1 < len(content); j += 2 {
+				key := jsonKey(content[j], opts)
+				if _, ok := column[key]; !ok {
+					column[key] = len(header)
+					header = append(header, key)
+				}
+			}
+		case yaml.ScalarNode:
+			scalars++
+		default:
+			return nil, fmt.Errorf("a table needs a sequence of mappings or of scalars, but %s is %s", yamlpath.DescribePath(paths[i]), yamlpath.KindName(row))
+		}
+	}
+	if scalars > 0 && scalars < len(rows) {
+		return nil, fmt.Errorf("a table needs a sequence of mappings or of scalars, not a mix of the two")
+	}
+
+	table := [][]string{header}
+	if scalars > 0 {
+		table[0] = []string{"value"}
+	}
+	for _, row := range rows {
+		if row.Kind == yaml.ScalarNode {
+			table = append(table, []string{tableCell(row, opts)})
+			continue
+		}
+		cells := make([]string, len(header))
+		content := row.Content
+		if !opts.noMerge {
+			content = yamlpath.MergedContent(row)
+		}
+		for j := 0; j+1 < len(content); j += 2 {
+			cells[column[jsonKey(content[j], opts)]] = tableCell(content[j+1], opts)
+		}
+		table = append(table, cells)
+	}
+	return table, nil
+}
+
+// tableNode is the node a table is made of: a document's content, or an
+// alias's target.
+func tableNode(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// tableCell is a value as a cell: a scalar's text, empty for null, and a
+// sequence or mapping as compact JSON.
+func tableCell(node *yaml.Node, opts jsonOptions) string {
+	node = tableNode(node)
+	if node.Kind == yaml.ScalarNode {
+		if node.ShortTag() == "!!null" {
+			return ""
+		}
+		return node.Value
+	}
+	out, err := writeJSON(jsonValue(node, opts), 0)
+	if err != nil {
+		return node.Value
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// printTable prints the table for matches as CSV, or with tsv separated
+// by tabs.
+func printTable(matches []yamlpath.Match, tsv bool, opts jsonOptions) error {
+	table, err := tableRows(matches, opts)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(os.Stdout)
+	if tsv {
+		w.Comma = '\t'
+	}
+	w.WriteAll(table)
+	return w.Error()
+}
@@ -0,0 +1,213 @@
+// Structural diffs for gy: --diff compares the node at a path in two
+// documents key by key and element by element, rather than line by line,
+// so a reordered mapping or a reformatted file isn't a difference.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeDiff is one difference between two nodes: a key or element only in
+// the second ('+'), only in the first ('-'), or with a different value in
+// each ('~'). path is relative to the nodes compared.
+type nodeDiff struct {
+	op       byte
+	path     []string
+	from, to *yaml.Node // from is nil for '+', to for '-'
+}
+
+// diffNodes compares a with b. Mappings are compared by key, whatever
+// order the keys are in, with << merge keys resolved unless noMerge
+// compares them as the entries they're written as; sequences element by
+// element, by index. Anything else that isn't the same scalar, including
+// two nodes of different kinds, is changed. Differences are listed in a's
+// order, with b's added keys after a mapping's others.
+func diffNodes(a, b *yaml.Node, noMerge bool) []nodeDiff {
+	var diffs []nodeDiff
+	following := map[*yaml.Node]bool{}
+	var walk func(a, b *yaml.Node, path []string)
+	walk = func(a, b *yaml.Node, path []string) {
+		a, b = diffTarget(a), diffTarget(b)
+		// An alias back into its own anchor would compare forever
+		if following[a] {
+			return
+		}
+		following[a] = true
+		defer delete(following, a)
+		child := func(part string) []string { return append(path[:len(path):len(path)], part) }
+		switch {
+		case a.Kind == yaml.MappingNode && b.Kind == yaml.MappingNode:
+			aContent, bContent := a.Content, b.Content
+			if !noMerge {
+				aContent, bContent = yamlpath.MergedContent(a), yamlpath.MergedContent(b)
+			}
+			inA := map[string]bool{}
+			for i := 0; i+1 < len(aContent); i += 2 {
+				key := aContent[i].Value
+				inA[key] = true
+				if value := contentValue(bContent, key); value != nil {
+					walk(aContent[i+1], value, child(yamlpath.EscapeKey(key)))
+				} else {
+					diffs = append(diffs, nodeDiff{op: '-', path: child(yamlpath.EscapeKey(key)), from: aContent[i+1]})
+				}
+			}
+			for i := 0; i+1 < len(bContent); i += 2 {
+				if key := bContent[i].Value; !inA[key] {
+					diffs = append(diffs, nodeDiff{op: '+', path: child(yamlpath.EscapeKey(key)), to: bContent[i+1]})
+				}
+			}
+		case a.Kind == yaml.SequenceNode && b.Kind == yaml.SequenceNode:
+			for i := 0; i < len(a.Content) || i < len(b.Content); i++ {
+				index := fmt.Sprintf("[%d]", i)
+				switch {
+				case i >= len(b.Content):
+					diffs = append(diffs, nodeDiff{op: '-', path: child(index), from: a.Content[i]})
+				case i >= len(a.Content):
+					diffs = append(diffs, nodeDiff{op: '+', path: child(index), to: b.Content[i]})
+				default:
+					walk(a.Content[i], b.Content[i], child(index))
+				}
+			}
+		case !sameScalar(a, b):
+			diffs = append(diffs, nodeDiff{op: '~', path: path, from: a, to: b})
+		}
+	}
+	walk(a, b, nil)
+	return diffs
+}
+
+// diffTarget is the node a document or alias stands for.
+func diffTarget(node *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+			node = node.Content[0]
+		case node.Kind == yaml.AliasNode && node.Alias != nil:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+}
+
+// contentValue is the value of key in a mapping's key/value pairs, or nil.
+func contentValue(content []*yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == key {
+			return content[i+1]
+		}
+	}
+	return nil
+}
+
+// sameScalar reports whether a and b are scalars with the same tag and
+// value. A null is a null however it's written, so `key:` and `key: ~`
+// are the same; `80` and `"80"` are not, being an int and a string.
+func sameScalar(a, b *yaml.Node) bool {
+	if a.Kind != yaml.ScalarNode || b.Kind != yaml.ScalarNode || a.ShortTag() != b.ShortTag() {
+		return false
+	}
+	return a.ShortTag() == "!!null" || a.Value == b.Value
+}
+
+// runDiff implements --diff: args are the two files and, optionally, the
+// path to compare in each, the whole document by default. It prints a
+// line per difference and exits 1 if there were any, as diff does; a path
+// in neither file is trouble rather than a difference, and exits 2.
+func runDiff(args []string, docIndex int, format string, color, noMerge bool) {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintln(os.Stderr, "Usage: gy --diff [--doc N] a.yaml b.yaml [path]")
+		os.Exit(exitUsage)
+	}
+	pattern := "."
+	if len(args) == 3 {
+		pattern = args[2]
+	}
+	if err := yamlpath.CheckPath(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	files := []string{expandPath(args[0]), expandPath(args[1])}
+	if (files[0] == "-" || files[0] == "") && (files[1] == "-" || files[1] == "") {
+		fmt.Fprintln(os.Stderr, "Error: --diff can read only one of its files from stdin")
+		os.Exit(exitUsage)
+	}
+
+	// The node at pattern in each file, nil where it isn't there
+	var nodes [2]*yaml.Node
+	var path []string
+	for i, filename := range files {
+		source := filename
+		if filename == "-" {
+			source = "stdin"
+		}
+		docs, _ := loadDocuments(filename, format)
+		index := docIndex
+		switch {
+		case index < 0 && len(docs) > 1:
+			fmt.Fprintf(os.Stderr, "Error: %s has %d documents; choose the one to compare with --doc\n", source, len(docs))
+			os.Exit(exitUsage)
+		case index < 0:
+			index = 0
+		case index >= len(docs):
+			fmt.Fprintf(os.Stderr, "Error: --doc %d out of range (%s has %d document(s))\n", docIndex, source, len(docs))
+			os.Exit(exitUsage)
+		}
+		w := yamlpath.Walker{NoMerge: noMerge}
+		matches, err := w.Find(docs[index], pattern)
+		if w.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if len(matches) > 1 {
+			fmt.Fprintf(os.Stderr, "Error: --diff compares one node from each file, but %s matches %d in %s\n", pattern, len(matches), source)
+			os.Exit(exitUsage)
+		}
+		if len(matches) == 1 {
+			nodes[i], path = matches[0].Node, matches[0].Path
+		}
+	}
+
+	var diffs []nodeDiff
+	switch {
+	case nodes[0] == nil && nodes[1] == nil:
+		fmt.Fprintf(os.Stderr, "Path not found in either file: %s\n", pattern)
+		os.Exit(exitUsage)
+	case nodes[0] == nil:
+		diffs = []nodeDiff{{op: '+', to: nodes[1]}}
+	case nodes[1] == nil:
+		diffs = []nodeDiff{{op: '-', from: nodes[0]}}
+	default:
+		diffs = diffNodes(nodes[0], nodes[1], noMerge)
+	}
+	for _, d := range diffs {
+		fmt.Println(diffLine(d, path, color))
+	}
+	if len(diffs) > 0 {
+		os.Exit(exitDifferent)
+	}
+}
+
+// diffLine formats d as `+ path: value`, `- path: value` or
+// `~ path: old -> new`, with its path joined to base, the path compared.
+func diffLine(d nodeDiff, base []string, color bool) string {
+	where := pathOf(yamlpath.Match{Path: append(base[:len(base):len(base)], d.path...)})
+	if d.from != nil {
+		d.from = diffTarget(d.from)
+	}
+	if d.to != nil {
+		d.to = diffTarget(d.to)
+	}
+	switch d.op {
+	case '+':
+		return paint(fmt.Sprintf("+ %s: %s", where, leafValue(d.to)), colorAdded, color)
+	case '-':
+		return paint(fmt.Sprintf("- %s: %s", where, leafValue(d.from)), colorRemoved, color)
+	}
+	return paint(fmt.Sprintf("~ %s: %s -> %s", where, leafValue(d.from), leafValue(d.to)), colorChanged, color)
+}
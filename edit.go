@@ -0,0 +1,579 @@
+// In-place editing for gy: --set and --delete change the parsed yaml.Node
+// tree and re-encode the whole stream, so comments, key order and quoting
+// elsewhere in the document survive the edit.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// runSet implements --set PATH=VALUE. Every node the path matches is set,
+// in each selected document; the whole stream is then written to stdout,
+// to outputFile, or back to the file with --in-place.
+func runSet(expr string, args []string, docIndex int, create, plain, inPlace bool, outputFile string, yamlOpts yamlOptions, format string) {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gy --set PATH=VALUE [--in-place|-i] [--create] [--plain] [filename]")
+		os.Exit(exitUsage)
+	}
+	pattern, value, ok := splitAssignment(expr)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: --set expects PATH=VALUE, got %q\n", expr)
+		os.Exit(exitUsage)
+	}
+	if err := yamlpath.CheckPath(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	var filename string
+	if len(args) == 1 {
+		filename = expandPath(args[0])
+	}
+	if inPlace && (filename == "" || filename == "-") {
+		fmt.Fprintln(os.Stderr, "Error: --in-place needs a filename, not stdin")
+		os.Exit(exitUsage)
+	}
+	if err := checkOutputFile(outputFile, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	docs, selected := loadEditDocuments(filename, docIndex, format, inPlace)
+	if err := setAll(selected, pattern, value, create, plain); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitNotFound)
+	}
+	if inPlace {
+		outputFile = filename
+	}
+	writeDocuments(docs, outputFile, yamlOpts)
+}
+
+// runDelete implements --delete: every node the pattern matches is removed
+// from its parent, in each selected document, and the whole stream is
+// written out like --set does. A path that doesn't exist leaves the input
+// unchanged unless strict is set.
+func runDelete(args []string, docIndex int, inPlace, strict bool, outputFile string, yamlOpts yamlOptions, format string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: gy --delete|-d [--in-place|-i] [--strict] pattern [filename]")
+		os.Exit(exitUsage)
+	}
+	pattern := args[0]
+	if err := yamlpath.CheckPath(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	var filename string
+	if len(args) == 2 {
+		filename = expandPath(args[1])
+	}
+	if inPlace && (filename == "" || filename == "-") {
+		fmt.Fprintln(os.Stderr, "Error: --in-place needs a filename, not stdin")
+		os.Exit(exitUsage)
+	}
+	if err := checkOutputFile(outputFile, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	docs, selected := loadEditDocuments(filename, docIndex, format, inPlace)
+	deleted := 0
+	for _, doc := range selected {
+		n, err := deleteAll(doc, pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitNotFound)
+		}
+		deleted += n
+	}
+	if deleted == 0 && strict {
+		fmt.Fprintf(os.Stderr, "Path not found: %s\n", pattern)
+		os.Exit(exitNotFound)
+	}
+	if inPlace {
+		outputFile = filename
+	}
+	writeDocuments(docs, outputFile, yamlOpts)
+}
+
+// loadEditDocuments loads the stream to edit, returning every document
+// (to write back) and the ones --doc selects (to change). The result is
+// always written as YAML, so TOML can't be edited in place.
+func loadEditDocuments(filename string, docIndex int, format string, inPlace bool) (docs, selected []*yaml.Node) {
+	docs, format = loadDocuments(filename, format)
+	if inPlace && format == "toml" {
+		fmt.Fprintf(os.Stderr, "Error: --in-place can't write %s back as TOML; gy only writes YAML\n", filename)
+		os.Exit(exitUsage)
+	}
+	if docIndex < 0 {
+		return docs, docs
+	}
+	if docIndex >= len(docs) {
+		fmt.Fprintf(os.Stderr, "Error: --doc %d out of range (input has %d document(s))\n", docIndex, len(docs))
+		os.Exit(exitUsage)
+	}
+	return docs, docs[docIndex : docIndex+1]
+}
+
+// writeDocuments prints the edited stream, or writes it to filename: the
+// input itself with --in-place, or an --output-file.
+func writeDocuments(docs []*yaml.Node, filename string, opts yamlOptions) {
+	output, err := encodeYAML(docs, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to encode YAML: %v\n", err)
+		os.Exit(exitIO)
+	}
+	if filename == "" {
+		fmt.Print(string(output))
+		return
+	}
+	if err := writeFileAtomic(filename, output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot write %s: %v\n", filename, describeReadError(err))
+		os.Exit(exitIO)
+	}
+}
+
+// setAll sets every scalar pattern matches across docs to value. When
+// nothing matches anywhere, create builds the path in each document
+// instead; without it that's an error. plain is setScalar's.
+func setAll(docs []*yaml.Node, pattern, value string, create, plain bool) error {
+	found := false
+	for _, doc := range docs {
+		for _, m := range editMatches(doc, pattern) {
+			found = true
+			if err := setScalar(m.Node, value, plain); err != nil {
+				return fmt.Errorf("cannot set %s: %v", yamlpath.FormatPath(m.Path), err)
+			}
+		}
+	}
+	if found {
+		return nil
+	}
+	if !create {
+		return fmt.Errorf("path not found: %s (use --create to add it)", pattern)
+	}
+	for _, doc := range docs {
+		node, err := createPath(doc, yamlpath.SplitPath(pattern))
+		if err != nil {
+			return err
+		}
+		if err := setScalar(node, value, plain); err != nil {
+			return fmt.Errorf("cannot set %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// editMatches is extractAll for --set and --delete. It matches the tree as
+// written: a key merged in by `<<` isn't there to change, and an alias at
+// the end of the path is the entry itself, not the anchored node that every
+// other alias of it shares.
+func editMatches(doc *yaml.Node, pattern string) []yamlpath.Match {
+	w := yamlpath.Walker{NoMerge: true, KeepAlias: true}
+	matches, _ := w.Search(doc, pattern)
+	return matches
+}
+
+// splitAssignment splits "PATH=VALUE" at the first = that isn't part of the
+// path itself, i.e. not inside brackets (`users[name=bob].email=x`),
+// quotes or after a backslash.
+func splitAssignment(expr string) (pattern, value string, ok bool) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '=' && depth == 0:
+			if i == 0 {
+				return "", "", false
+			}
+			return expr[:i], expr[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// setScalar replaces a scalar's value in place. A quoted, block or
+// explicitly tagged scalar keeps its style and tag, so `tag: "1.0"` stays a
+// string; a plain scalar takes the tag the new value resolves to, so
+// setting `replicas: 3` to `auto` turns it into a string. plain makes
+// every scalar a plain one first, so `'abc'` set to 3 becomes a number.
+func setScalar(node *yaml.Node, value string, plain bool) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("it is %s, not a scalar", yamlpath.KindName(node))
+	}
+	node.Value = value
+	if plain {
+		node.Style = 0
+	}
+	if node.Style&(yaml.TaggedStyle|yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		node.Tag = (&yaml.Node{Kind: yaml.ScalarNode, Value: value}).ShortTag()
+	}
+	return nil
+}
+
+// createPath walks doc along parts like extractAll does, adding any missing
+// mapping keys as it goes, and returns the node at the end of the path.
+// Only plain keys and existing indexes can be followed; a null value on
+// the way is replaced by a mapping.
+func createPath(doc *yaml.Node, parts []string) (*yaml.Node, error) {
+	if doc.Kind == 0 {
+		// Empty input - start a document from scratch
+		doc.Kind = yaml.DocumentNode
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+	node := doc.Content[0]
+	for i, part := range parts {
+		where := yamlpath.FormatPath(parts[:i+1])
+		if part == ".." || part == "*" || part == "[*]" || yamlpath.IsGlobPart(part) {
+			return nil, fmt.Errorf("cannot create %s: wildcards can't be created", where)
+		}
+		if part == "^" || yamlpath.IsAnchorPart(part) {
+			return nil, fmt.Errorf("cannot create %s: %s can't be created", where, part)
+		}
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+			*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: node.Line, Column: node.Column}
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			key, ok := yamlpath.BracketKey(part)
+			if !ok && yamlpath.IsIndexPart(part) {
+				return nil, fmt.Errorf("cannot create %s: %s is a mapping", where, yamlpath.DescribePath(parts[:i]))
+			}
+			if !ok {
+				key = yamlpath.KeyName(part)
+			}
+			if child := findMapValue(node, key); child != nil {
+				node = child
+				continue
+			}
+			child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			if i == len(parts)-1 {
+				child = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			node = child
+		case yaml.SequenceNode:
+			if !yamlpath.IsIndexPart(part) {
+				return nil, fmt.Errorf("cannot create %s: %s is a sequence", where, yamlpath.DescribePath(parts[:i]))
+			}
+			index, err := strconv.Atoi(part[1 : len(part)-1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot create %s: only plain keys and indexes can be created", where)
+			}
+			if index < 0 {
+				index += len(node.Content)
+			}
+			if index < 0 || index >= len(node.Content) {
+				return nil, fmt.Errorf("cannot create %s: index out of range (length %d)", where, len(node.Content))
+			}
+			node = node.Content[index]
+		default:
+			return nil, fmt.Errorf("cannot create %s: %s is %s", where, yamlpath.DescribePath(parts[:i]), yamlpath.KindName(node))
+		}
+	}
+	return node, nil
+}
+
+// parentOf locates m's parent by its concrete path, and m's current
+// position within it. Looking the node up by identity rather than trusting
+// the index in the path keeps this right after earlier deletions have
+// shifted a sequence.
+func parentOf(root *yaml.Node, m yamlpath.Match) (*yaml.Node, int) {
+	if len(m.Path) == 0 {
+		return nil, -1
+	}
+	parent := yamlpath.WalkParts(root, m.Path[:len(m.Path)-1])
+	if parent != nil && parent.Kind == yaml.DocumentNode && len(parent.Content) > 0 {
+		parent = parent.Content[0]
+	}
+	if parent == nil {
+		return nil, -1
+	}
+	switch parent.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i+1] == m.Node {
+				return parent, i
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range parent.Content {
+			if item == m.Node {
+				return parent, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// deleteAll removes every node pattern matches in doc and returns how many
+// were removed. A mapping entry goes as a key/value pair; a sequence element
+// is spliced out, shifting the ones after it. A trailing slice or index
+// list removes each of the elements it selected.
+func deleteAll(doc *yaml.Node, pattern string) (int, error) {
+	// Resolve every parent before removing anything, while the concrete
+	// paths still describe the tree
+	type removal struct {
+		parent, node *yaml.Node
+	}
+	var removals []removal
+	for _, m := range editMatches(doc, pattern) {
+		if len(m.Path) == 0 {
+			return 0, fmt.Errorf("cannot delete the document root")
+		}
+		if parent, _ := parentOf(doc, m); parent != nil {
+			removals = append(removals, removal{parent, m.Node})
+			continue
+		}
+		// A sub-sequence from a slice or index list stands in for the
+		// original sequence: remove the elements it picked from that
+		if original := yamlpath.WalkParts(doc, m.Path); original != nil && original.Kind == yaml.SequenceNode && m.Node.Kind == yaml.SequenceNode {
+			for _, item := range m.Node.Content {
+				removals = append(removals, removal{original, item})
+			}
+		}
+	}
+
+	deleted := 0
+	for _, r := range removals {
+		if removeChild(r.parent, r.node) {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// removeChild removes node from parent's Content - with its key, for a
+// mapping value - and reports whether it was there.
+func removeChild(parent, node *yaml.Node) bool {
+	if parent.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i+1] == node {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return true
+			}
+		}
+		return false
+	}
+	for i, item := range parent.Content {
+		if item == node {
+			parent.Content = append(parent.Content[:i], parent.Content[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// findMapValue returns the value stored under key in a mapping node, or nil.
+func findMapValue(mapNode *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapNode.Content); i += 2 {
+		if mapNode.Content[i].Value == key {
+			return mapNode.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sortMappingKeys sorts the entries of every mapping in the tree by key, in
+// place. Each key moves with its value, and the comments on either with
+// them; sequences keep their order. An alias isn't followed, since the
+// mapping it refers to is sorted where it's defined.
+func sortMappingKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0].Value < pairs[j][0].Value })
+		for i, pair := range pairs {
+			node.Content[2*i], node.Content[2*i+1] = pair[0], pair[1]
+		}
+	}
+	for _, child := range node.Content {
+		sortMappingKeys(child)
+	}
+}
+
+// yamlOptions are the settings encodeYAML renders a stream with.
+type yamlOptions struct {
+	indent    int  // spaces per level
+	sortKeys  bool // sort every mapping's keys first
+	flushSeqs bool // line a mapping value's block sequence up with its key
+}
+
+// encodeYAML renders a whole stream back to YAML, with `---` between
+// documents, indenting each level by opts.indent spaces. With
+// opts.sortKeys every mapping is sorted first.
+func encodeYAML(docs []*yaml.Node, opts yamlOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(opts.indent)
+	for _, doc := range docs {
+		if doc.Kind == 0 {
+			continue // empty input that was never filled in
+		}
+		if opts.sortKeys {
+			sortMappingKeys(doc)
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if opts.flushSeqs {
+		return flushSequences(buf.Bytes()), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// flushSequences moves every block sequence that is a mapping value, and
+// everything in it, left so its dashes line up with the key above it - the
+// encoder always indents them, though not always by the same amount. It
+// works on the encoded text a line at a time, tracking block scalars so
+// their lines only ever move with the node they're in.
+func flushSequences(text []byte) []byte {
+	var b bytes.Buffer
+	// flushed holds the keys whose sequences are being moved: the key's
+	// column, and how far its lines move
+	type flush struct{ column, shift int }
+	var flushed []flush
+	block := -1 // the column a block scalar's lines are indented past
+	lines := strings.SplitAfter(string(text), "\n")
+	for i, line := range lines {
+		content := strings.TrimSuffix(line, "\n")
+		column := len(content) - len(strings.TrimLeft(content, " "))
+		rest := content[column:]
+		if rest != "" {
+			for len(flushed) > 0 && column <= flushed[len(flushed)-1].column {
+				flushed = flushed[:len(flushed)-1]
+			}
+		}
+		shift := 0
+		for _, f := range flushed {
+			shift += f.shift
+		}
+		b.WriteString(line[min(shift, column):])
+
+		if block >= 0 {
+			if rest == "" || column > block {
+				continue
+			}
+			block = -1
+		}
+		// A key's column is past any dashes of the sequence items it's in
+		start := column
+		for rest == "-" || strings.HasPrefix(rest, "- ") {
+			column += min(2, len(rest))
+			rest = rest[min(2, len(rest)):]
+		}
+		_, value, ok := splitYAMLKey(rest)
+		if !ok {
+			if trimmed := strings.TrimSpace(rest); strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, ">") {
+				block = start
+			}
+			continue
+		}
+		value, _ = splitYAMLComment(value)
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			block = column
+			continue
+		}
+		// Only a key with nothing after it but an anchor or tag can have a
+		// block sequence under it, starting on the next line
+		if value != "" && !strings.HasPrefix(value, "&") && !strings.HasPrefix(value, "!") {
+			continue
+		}
+		// A comment above the first item goes with it
+		for _, next := range lines[i+1:] {
+			next = strings.TrimSuffix(next, "\n")
+			nextColumn := len(next) - len(strings.TrimLeft(next, " "))
+			nextRest := next[nextColumn:]
+			if nextRest == "" || nextRest[0] == '#' {
+				continue
+			}
+			if nextColumn > column && (nextRest == "-" || strings.HasPrefix(nextRest, "- ")) {
+				flushed = append(flushed, flush{column, nextColumn - column})
+			}
+			break
+		}
+	}
+	return b.Bytes()
+}
+
+// splitYAMLKey splits a block mapping line's text into its key and what
+// follows the colon. A line with no key, such as a sequence item's scalar
+// or a continuation line, reports false.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	end := 0
+	switch {
+	case text == "" || text[0] == '#' || text[0] == '[' || text[0] == '{':
+		return "", "", false
+	case text[0] == '"' || text[0] == '\'':
+		end = closingQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		end++
+	default:
+		end = strings.Index(text, ": ")
+		if end < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			end = len(text) - 1
+		}
+	}
+	if end >= len(text) || text[end] != ':' || (end+1 < len(text) && text[end+1] != ' ') {
+		return "", "", false
+	}
+	return text[:end], text[end+1:], true
+}
+
+// splitYAMLComment splits a trailing ` # comment` off a value, ignoring any
+// # inside a quoted scalar. Only a leading quote opens one; the apostrophe
+// in a plain `don't` doesn't.
+func splitYAMLComment(text string) (value, comment string) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			if strings.TrimLeft(text[:i], " ") != "" {
+				continue
+			}
+			end := closingQuote(text[i:])
+			if end < 0 {
+				return text, ""
+			}
+			i += end
+		case '#':
+			if i == 0 || text[i-1] == ' ' {
+				return text[:i], text[i:]
+			}
+		}
+	}
+	return text, ""
+}
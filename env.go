@@ -0,0 +1,255 @@
+// Key/value output for gy: --output dotenv, properties and shell print
+// every leaf below the matches on a line of its own, as KEY=value for an
+// env file, key=value for a Java properties file, or an export for a shell
+// to eval.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// flatEntry is a leaf below a match, named by the keys and indexes on the
+// way down to it from the match.
+type flatEntry struct {
+	names []string
+	value string
+}
+
+// flatEntries collects the leaves at or below each match, in document
+// order. A leaf's names are relative to the match it's under, so
+// `app.env` gives DB_HOST rather than APP_ENV_DB_HOST; a match that is
+// itself a scalar, or a sequence, whose elements would otherwise be named
+// by bare numbers, starts with its own key. Null and an empty collection
+// are empty values.
+func flatEntries(matches []yamlpath.Match, noMerge bool) []flatEntry {
+	var entries []flatEntry
+	w := yamlpath.Walker{NoMerge: noMerge}
+	for _, m := range matches {
+		for _, leaf := range w.Leaves(m) {
+			var names []string
+			for _, part := range leaf.Path[len(m.Path):] {
+				names = append(names, partName(part))
+			}
+			if len(m.Path) > 0 && (len(names) == 0 || yamlpath.IsIndexPart(leaf.Path[len(m.Path)])) {
+				names = append([]string{partName(m.Path[len(m.Path)-1])}, names...)
+			}
+			if len(names) == 0 {
+				names = []string{"value"}
+			}
+			value := ""
+			if leaf.Node.Kind == yaml.ScalarNode && leaf.Node.ShortTag() != "!!null" {
+				value = leaf.Node.Value
+			}
+			entries = append(entries, flatEntry{names: names, value: value})
+		}
+	}
+	return entries
+}
+
+// partName is a path part as a name: a key as written, an index as its
+// number.
+func partName(part string) string {
+	if yamlpath.IsIndexPart(part) {
+		return strings.Trim(part, "[]")
+	}
+	return yamlpath.KeyName(part)
+}
+
+// envUnsafe matches what can't be part of an environment variable name.
+var envUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// envName is names as a variable name: joined by _ after any prefix and
+// uppercased, with anything else that isn't a letter, digit or _ turned
+// into _, and a leading _ where it would start with a digit or be empty.
+func envName(prefix string, names []string) string {
+	if prefix != "" {
+		names = append([]string{prefix}, names...)
+	}
+	name := envUnsafe.ReplaceAllString(strings.ToUpper(strings.Join(names, "_")), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// envLines renders the matches in the key=value format env names:
+// "dotenv", "properties" or "shell".
+func envLines(env string, matches []yamlpath.Match, prefix string, noMerge bool) ([]string, error) {
+	switch env {
+	case "dotenv":
+		return dotenvLines(flatEntries(matches, noMerge), prefix)
+	case "properties":
+		return propertiesLines(flatEntries(matches, noMerge), prefix)
+	}
+	return shellLines(matches, prefix, noMerge)
+}
+
+// dotenvLines renders entries as KEY=value, named by envName. A value that
+// a shell or env file would otherwise split, expand or cut short at a # is
+// double-quoted. Two leaves named alike are an error, as with shellLines.
+func dotenvLines(entries []flatEntry, prefix string) ([]string, error) {
+	var lines []string
+	from := map[string][]string{}
+	for _, e := range entries {
+		key := envName(prefix, e.names)
+		if other, ok := from[key]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written as %s", strings.Join(other, "."), strings.Join(e.names, "."), key)
+		}
+		from[key] = e.names
+		lines = append(lines, fmt.Sprintf("%s=%s", key, dotenvValue(e.value)))
+	}
+	return lines, nil
+}
+
+func dotenvValue(value string) string {
+	if !strings.ContainsAny(value, " \t\r\n#\"'\\$`") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// propertiesLines renders entries as key=value: names joined by . after
+// any prefix and lowercased, escaped as java.util.Properties reads them.
+// Lowercasing, and a key with a . in it, can give two leaves one name,
+// which is an error as with shellLines.
+func propertiesLines(entries []flatEntry, prefix string) ([]string, error) {
+	var lines []string
+	from := map[string][]string{}
+	for _, e := range entries {
+		names := e.names
+		if prefix != "" {
+			names = append([]string{prefix}, names...)
+		}
+		key := strings.ToLower(strings.Join(names, "."))
+		if other, ok := from[key]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written as %s", strings.Join(other, "."), strings.Join(e.names, "."), key)
+		}
+		from[key] = e.names
+		lines = append(lines, fmt.Sprintf("%s=%s", propertiesEscape(key, true), propertiesEscape(e.value, false)))
+	}
+	return lines, nil
+}
+
+// propertiesEscape backslash-escapes what a properties file would read
+// differently: line breaks, tabs and backslashes anywhere, leading
+// whitespace, and in a key the separators and a leading comment mark.
+func propertiesEscape(text string, key bool) string {
+	var b strings.Builder
+	for i, c := range text {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case key && (c == '=' || c == ':' || i == 0 && (c == '#' || c == '!')):
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// shellEntries collects the leaves at or below m for --output shell, named
+// from m's own key down so `deploy` gives DEPLOY_IMAGE. Each sequence also
+// gets a COUNT entry with its length, after its elements, for a shell loop.
+// Null and an empty collection are empty values, as in flatEntries.
+func shellEntries(m yamlpath.Match, noMerge bool) []flatEntry {
+	var base []string
+	if len(m.Path) > 0 {
+		base = []string{partName(m.Path[len(m.Path)-1])}
+	}
+	nameOf := func(parts []string) []string {
+		names := append([]string{}, base...)
+		for _, part := range parts {
+			names = append(names, partName(part))
+		}
+		return names
+	}
+	// The leaves come in document order, so a sequence's elements are
+	// together: it's open from its first element's leaves to its last's,
+	// and counted when a leaf outside it, or the end, closes it. depth is
+	// how many parts lead to it, last its last element's index so far.
+	type sequence struct{ depth, last int }
+	var open []sequence
+	var entries []flatEntry
+	var prev []string
+	closeTo := func(parts []string) {
+		for len(open) > 0 {
+			s := open[len(open)-1]
+			if len(parts) > s.depth && yamlpath.IsIndexPart(parts[s.depth]) && comparePaths(parts[:s.depth], prev[:s.depth]) == 0 {
+				return
+			}
+			entries = append(entries, flatEntry{names: append(nameOf(prev[:s.depth]), "COUNT"), value: fmt.Sprint(s.last + 1)})
+			open = open[:len(open)-1]
+		}
+	}
+	w := yamlpath.Walker{NoMerge: noMerge}
+	for _, leaf := range w.Leaves(m) {
+		parts := leaf.Path[len(m.Path):]
+		closeTo(parts)
+		for depth, part := range parts {
+			if !yamlpath.IsIndexPart(part) {
+				continue
+			}
+			index, _ := strconv.Atoi(partName(part))
+			if n := len(open); n > 0 && open[n-1].depth >= depth {
+				for i := range open {
+					if open[i].depth == depth {
+						open[i].last = index
+					}
+				}
+				continue
+			}
+			open = append(open, sequence{depth, index})
+		}
+		prev = parts
+
+		value := ""
+		if leaf.Node.Kind == yaml.ScalarNode && leaf.Node.ShortTag() != "!!null" {
+			value = leaf.Node.Value
+		}
+		entries = append(entries, flatEntry{names: nameOf(parts), value: value})
+		if leaf.Node.Kind == yaml.SequenceNode {
+			entries = append(entries, flatEntry{names: append(nameOf(parts), "COUNT"), value: "0"})
+		}
+	}
+	closeTo(nil)
+	return entries
+}
+
+// shellLines renders the matches as `export NAME='value'` lines, named by
+// envName; two leaves that end up with the same name are an error rather
+// than one silently overwriting the other. Values are single-quoted - a quote in
+// one closes the string, adds an escaped quote and reopens it - so nothing
+// in them is ever run or expanded.
+func shellLines(matches []yamlpath.Match, prefix string, noMerge bool) ([]string, error) {
+	var lines []string
+	from := map[string][]string{}
+	for _, m := range matches {
+		for _, e := range shellEntries(m, noMerge) {
+			name := envName(prefix, e.names)
+			if other, ok := from[name]; ok {
+				return nil, fmt.Errorf("%s and %s would both be exported as %s", strings.Join(other, "."), strings.Join(e.names, "."), name)
+			}
+			from[name] = e.names
+			lines = append(lines, fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(e.value, "'", `'\''`)))
+		}
+	}
+	return lines, nil
+}
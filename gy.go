@@ -8,21 +8,43 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 
+	"github.com/tsettle/gy/yamlpath"
 	"gopkg.in/yaml.v3"
 )
 
-var debug = flag.Bool("debug", false, "Enable debug mode")
+var noExpand = flag.Bool("no-expand", false, "Take filenames literally, without expanding a leading ~ or $VAR")
+
+var debug = flag.Bool("debug", false, "Trace how each pattern is split and followed through the document, on stderr")
 
 // buildVersion is set at build time via -ldflags "-X main.buildVersion=vX.Y.Z"
 // (see .github/workflows/release.yml). Local `go build` leaves it as "dev".
 var buildVersion = "dev"
 
+// Exit codes, distinct so scripts can tell a missing path apart from a
+// mistake in the invocation or an unreadable input.
+const (
+	exitNotFound = 1 // the pattern matched nothing
+	exitUsage    = 2 // bad flags, arguments or pattern syntax
+	exitIO       = 3 // the input couldn't be read or parsed
+
+	exitDifferent = 1 // --diff found the two documents differ
+)
+
 func main() {
 	// Flag declarations
 	trim := flag.Bool("trim", false, "Return only the matched node, not full path")
@@ -33,362 +55,2267 @@ func main() {
 	showVersion := flag.Bool("V", false, "Show version information")
 	flow := flag.Bool("flow", false, "Force flow-style ({}/[]) output, e.g. for JSON")
 	flowShort := flag.Bool("j", false, "Force flow-style output (short flag, mnemonic: json)")
+	style := flag.String("style", "keep", "How to write string values: keep (as written), plain, double, single, literal or folded")
+	nullFill := flag.Bool("null-fill", false, "Keep each extracted sequence element at its original index, padding the elements before it with null")
+	parent := flag.Bool("parent", false, "Print the mapping or sequence each match is in, rather than the match itself")
 	block := flag.Bool("block", false, "Force block-style (indented) output")
 	blockShort := flag.Bool("y", false, "Force block-style output (short flag, mnemonic: yaml)")
+	docIndex := flag.Int("doc", -1, "Only search the Nth document (zero-based) of a multi-document stream")
+	jsonOut := flag.Bool("json", false, "Output JSON instead of YAML")
+	jsonIndent := flag.Int("json-indent", 2, "Indentation width for --json output (0 for compact)")
+	yamlIndent := flag.Int("yaml-indent", 2, "Indentation width for YAML output (2 to 9)")
+	sortKeys := flag.Bool("sort-keys", false, "Print every mapping's keys in sorted order, in YAML and JSON output and after edits")
+	seqIndent := flag.String("seq-indent", "indent", "Where a block sequence's dashes go under a mapping key: indent them past the key, or flush at the key's column")
+	indentShort := flag.Int("indent", 2, "Indentation width for YAML output (the same as --yaml-indent)")
+	outputFormat := flag.String("output", "", "Output format: yaml (the default), json as --json, ndjson (or jsonl) as --ndjson, csv or tsv for a table of a sequence of mappings, flat as --leaves, dotenv or properties for a key=value line per leaf, or shell for an export per leaf")
+	templateFlag := flag.String("template", "", "Print each match through this Go text/template, e.g. '{{ .name }}: {{ .version }}'; helpers: upper, lower, join, default, indent")
+	prefix := flag.String("prefix", "", "With --output dotenv, properties or shell, put PREFIX before every name")
+	ndjson := flag.Bool("ndjson", false, "Output each match as one line of compact JSON (newline-delimited JSON)")
+	raw := flag.Bool("raw", false, "Print scalar values as-is, without YAML quoting (implies --trim)")
+	rawShort := flag.Bool("r", false, "Print scalar values as-is (short flag)")
+	rawStrict := flag.Bool("raw-strict", false, "Like --raw, but a match that isn't a scalar is an error instead of being printed as YAML")
+	noNewline := flag.Bool("n", false, "With --raw, don't print a newline after the last value")
+	print0 := flag.Bool("print0", false, "Separate --raw, --paths and --list results with a NUL byte instead of a newline, for xargs -0")
+	delimiter := flag.String("delimiter", "", "Separate --raw, --paths and --list results with STR instead of a newline")
+	setExpr := flag.String("set", "", "Set the scalar at PATH to VALUE (given as PATH=VALUE) and print the whole document. A quoted or tagged scalar keeps its quoting and tag, so it stays a string; add --plain to write VALUE as the type it reads as")
+	plain := flag.Bool("plain", false, "With --set, write VALUE as a plain scalar, taking the type it resolves to, even where the old value was quoted or tagged")
+	deleteMode := flag.Bool("delete", false, "Remove the nodes the pattern matches and print the whole document")
+	deleteShort := flag.Bool("d", false, "Remove the matched nodes (short flag)")
+	mergePath := flag.String("merge", "", "Deep-merge the YAML fragment in the patch file (the first argument) into the node at PATH and print the whole document")
+	diffMode := flag.Bool("diff", false, "Compare the node at a path (the third argument, . by default) in two files (the first two) key by key, printing + for added, - for removed and ~ for changed values; exit 1 if they differ")
+	mergeSeq := flag.String("merge-seq", "replace", "With --merge, what to do with a sequence in both: replace it, or append the patch's elements")
+	inPlace := flag.Bool("in-place", false, "With --set, --delete or --merge, write the result back to the file instead of stdout")
+	inPlaceShort := flag.Bool("i", false, "With --set, --delete or --merge, write back to the file (short flag)")
+	outputFileFlag := flag.String("output-file", "", "Write the output to this file instead of stdout, replacing it only once gy succeeds")
+	outputFileShort := flag.String("o", "", "Write the output to this file (short flag)")
+	create := flag.Bool("create", false, "With --set or --merge, create missing keys along the path")
+	inputFormat := flag.String("input", "auto", "Input format: yaml, json, toml, or auto to go by the file extension and content")
+	file := flag.String("file", "", "Read input from this file (- for stdin); every argument is then a pattern")
+	fileShort := flag.String("f", "", "Read input from this file (short flag)")
+	values := flag.Bool("values", false, "Print the values of each matched mapping as a YAML sequence (one per line with --raw); with --list, show the value of each leaf scalar as key: value; with --paths, print each match as path: value")
+	colorMode := flag.String("color", "auto", "Color list and YAML output: auto (only on a terminal, and not with NO_COLOR set), always or never")
+	tree := flag.Bool("tree", false, "List keys/items with tree-style connectors (implies --list)")
+	types := flag.Bool("types", false, "With --list, show each entry's type, e.g. replicas (int) or containers (seq[3])")
+	noComments := flag.Bool("no-comments", false, "Strip comments from the output")
+	keepTags := flag.Bool("keep-tags", false, "Write every scalar's tag, such as !!str or !!int, even where its value implies it")
+	stripTags := flag.Bool("strip-tags", false, "Drop standard tags such as !!str that the value doesn't need; custom tags like !Ref are kept")
+	keys := flag.Bool("keys", false, "Print the keys of each matched mapping, in document order, as a YAML sequence (one per line with --raw)")
+	keysSorted := flag.Bool("keys-sorted", false, "Like --keys, but sorted")
+	exists := flag.Bool("exists", false, "Print nothing; exit 0 if every pattern matches at least one node (null included), 1 otherwise")
+	existsShort := flag.Bool("e", false, "Test for the path by exit code alone (short flag)")
+	count := flag.Bool("count", false, "Print the number of nodes each pattern matches, 0 (and exit 0) if none")
+	length := flag.Bool("length", false, "Print the size of each match: elements of a sequence, keys of a mapping, characters of a scalar (0 for null)")
+	noMerge := flag.Bool("no-merge", false, "Don't resolve << merge keys: match and list them as plain entries")
+	ignoreCase := flag.Bool("ignore-case", false, "Match keys regardless of case; the keys are still printed as written")
+	ignoreCaseShort := flag.Bool("ci", false, "Match keys regardless of case (short flag)")
+	patternStdin := flag.Bool("pattern-stdin", false, "Read the pattern from the first line of stdin, and the document from the file argument")
+	patternFile := flag.String("pattern-file", "", "Read patterns from this file, one per line (blank lines and # comments are skipped), and the document from the file argument")
+	jsonPath := flag.Bool("jsonpath", false, "Read each pattern as a JSONPath expression, e.g. '$.items[*].metadata.name'")
+	findValue := flag.String("find-value", "", "Print the path of every scalar whose value is VALUE, as path: value, at or below each match")
+	findKey := flag.String("find-key", "", "Print the path of every mapping entry whose key is KEY at or below each match (with -t, as path: value)")
+	contains := flag.Bool("contains", false, "With --find-value or --find-key, match text that contains the argument")
+	regex := flag.Bool("regex", false, "With --find-value or --find-key, read the argument as a regular expression")
+	defaultValue := flag.String("default", "", "Print VALUE, as a raw string, for a pattern that matches nothing, and exit 0")
+	defaultYAML := flag.Bool("default-yaml", false, "Parse the --default value as YAML, so it can be a number, list or mapping")
+	tag := flag.String("tag", "", "Only match nodes with this YAML tag (e.g. !!timestamp or !vault) at or below each match")
+	leaves := flag.Bool("leaves", false, "Print every scalar below each match on a line of its own, as path: value (the default for a pattern ending in **)")
+	sortLeaves := flag.Bool("sort", false, "With --leaves or --output flat, print each pattern's lines sorted by path instead of in document order")
+	flatten := flag.Bool("flatten", false, "Print the matches as one flat mapping from the path of every leaf below them to its value, e.g. a.b[0].c: 1")
+	paths := flag.Bool("paths", false, "Print the path of each match instead of its value")
+	labels := flag.Bool("labels", false, "Print results as a mapping from each pattern to its value")
+	maxResults := flag.Int("max-results", 0, "Stop after the first N matches of each pattern, in document order, across all documents")
+	maxResultsShort := flag.Int("m", 0, "Stop after N matches of each pattern (short flag)")
+	stream := flag.Bool("stream", false, "Read and search one document at a time, printing each one's matches before reading the next, instead of loading the whole input")
+	first := flag.Bool("first", false, "Print only the first match of each pattern, in document order (--count still counts them all)")
+	last := flag.Bool("last", false, "Print only the last match of each pattern, in document order (--count still counts them all)")
+	checkPath := flag.Bool("check-path", false, "Check each argument's pattern syntax without reading a document; exit 0 if they're all well-formed, 2 otherwise (with --debug, print how they were read as JSON)")
+	strict := flag.Bool("strict", false, "Fail on out-of-range entries in an index list like [0,3,7], a --delete path that doesn't exist, or any unmatched member of a union")
 
-	flag.Parse()
+	flag.Usage = usage
+	args := parseArgs(os.Args[1:])
 
 	if *showVersion {
 		fmt.Printf("gy version %s\n", buildVersion)
 		os.Exit(0)
 	}
 
-	useTrim := *trim || *trimShort
-	useList := *list || *listShort
+	if *checkPath {
+		runCheckPath(args, *jsonPath, *jsonIndent)
+		return
+	}
+
+	useRaw := *raw || *rawShort || *rawStrict
+	useTrim := *trim || *trimShort || useRaw
+	useList := *list || *listShort || *tree
 	useFlow := *flow || *flowShort
 	useBlock := *block || *blockShort
 	maxDepth := *depth
 
 	if useFlow && useBlock {
 		fmt.Fprintln(os.Stderr, "Error: --flow/-j and --block/-y are mutually exclusive")
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+	useColors, err := useColor(*colorMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if _, ok := scalarStyles[*style]; !ok && *style != "keep" {
+		fmt.Fprintf(os.Stderr, "Error: --style must be keep, plain, double, single, literal or folded, got %q\n", *style)
+		os.Exit(exitUsage)
+	}
+	if *keepTags && *stripTags {
+		fmt.Fprintln(os.Stderr, "Error: --keep-tags and --strip-tags are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	// --indent is --yaml-indent by a shorter name. The encoder can't
+	// indent by less than 2 or more than 9.
+	indentFlag := "yaml-indent"
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "indent" {
+			indentFlag, *yamlIndent = "indent", *indentShort
+		}
+	})
+	if *yamlIndent < 2 || *yamlIndent > 9 {
+		fmt.Fprintf(os.Stderr, "Error: --%s must be between 2 and 9, got %d\n", indentFlag, *yamlIndent)
+		os.Exit(exitUsage)
+	}
+	if *seqIndent != "indent" && *seqIndent != "flush" {
+		fmt.Fprintf(os.Stderr, "Error: --seq-indent must be indent or flush, got %q\n", *seqIndent)
+		os.Exit(exitUsage)
+	}
+	// What edits are written back with, as printed YAML is
+	yamlOpts := yamlOptions{indent: *yamlIndent, sortKeys: *sortKeys, flushSeqs: *seqIndent == "flush"}
+
+	switch *inputFormat {
+	case "auto", "yaml", "json", "toml":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --input must be yaml, json, toml or auto, got %q\n", *inputFormat)
+		os.Exit(exitUsage)
+	}
+
+	// --output names a format: the one --json, --ndjson or --leaves asks
+	// for, or a csv or tsv table or dotenv or properties lines, which have
+	// no flag of their own
+	if *outputFormat != "" && (*jsonOut || *ndjson) {
+		fmt.Fprintln(os.Stderr, "Error: --output can't be combined with --json or --ndjson")
+		os.Exit(exitUsage)
+	}
+	var table, env string
+	switch *outputFormat {
+	case "", "yaml":
+	case "json":
+		*jsonOut = true
+	case "ndjson", "jsonl":
+		*ndjson = true
+	case "flat":
+		*leaves = true
+	case "csv", "tsv":
+		table = *outputFormat
+	case "dotenv", "properties", "shell":
+		env = *outputFormat
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --output must be yaml, json, ndjson, flat, csv, tsv, dotenv, properties or shell, got %q\n", *outputFormat)
+		os.Exit(exitUsage)
+	}
+	if *prefix != "" && env == "" {
+		fmt.Fprintln(os.Stderr, "Error: --prefix only applies to --output dotenv, properties and shell")
+		os.Exit(exitUsage)
 	}
 
-	args := flag.Args()
-	if len(args) > 2 {
-		fmt.Fprintln(os.Stderr, "Usage: gy [--trim|-t] [--list|-l] [--depth N] [--flow|-j] [--block|-y] [pattern] [filename]")
-		os.Exit(1)
+	// A broken template is reported before any input is read
+	var tmpl *template.Template
+	if *templateFlag != "" {
+		if tmpl, err = parseTemplate(*templateFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
 	}
 
-	// Parse pattern and filename
-	var pattern, filename string
-	switch len(args) {
-	case 0:
-		// No args - read from stdin, no pattern (just round-trip)
-		pattern = "."
-		filename = ""
-	case 1:
-		// One arg - could be pattern or filename
-		if _, err := os.Stat(args[0]); err == nil {
+	inputFile := *file
+	if *fileShort != "" {
+		inputFile = *fileShort
+	}
+	outputFile := *outputFileFlag
+	if *outputFileShort != "" {
+		outputFile = *outputFileShort
+	}
+	outputFile = expandPath(outputFile)
+	useExists := *exists || *existsShort
+	limit := *maxResults
+	if *maxResultsShort != 0 {
+		limit = *maxResultsShort
+	}
+	if limit < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --max-results must be a positive number")
+		os.Exit(exitUsage)
+	}
+	// --first is -m 1; --last keeps the final match instead. --count
+	// counts every match either way.
+	switch {
+	case *first && *last:
+		fmt.Fprintln(os.Stderr, "Error: --first and --last are mutually exclusive")
+		os.Exit(exitUsage)
+	case (*first || *last) && limit > 0:
+		fmt.Fprintln(os.Stderr, "Error: --first and --last can't be combined with --max-results")
+		os.Exit(exitUsage)
+	case *first && !*count:
+		limit = 1
+	}
+	useLast := *last && !*count
+	if outputFile != "" && (*inPlace || *inPlaceShort) {
+		fmt.Fprintln(os.Stderr, "Error: --output-file and --in-place are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if outputFile != "" && useExists {
+		fmt.Fprintln(os.Stderr, "Error: --exists prints nothing, so --output-file would have nothing to write")
+		os.Exit(exitUsage)
+	}
+	// A file isn't a terminal, so --color auto leaves it plain
+	if outputFile != "" && *colorMode == "auto" {
+		useColors = false
+	}
+	editing := *setExpr != "" || *deleteMode || *deleteShort || *mergePath != ""
+	if *diffMode {
+		if editing || useExists || inputFile != "" || outputFile != "" {
+			fmt.Fprintln(os.Stderr, "Error: --diff takes its two files as arguments, and can't be combined with --set, --delete, --merge, --exists, --file or --output-file")
+			os.Exit(exitUsage)
+		}
+		// --diff prints its own lines, so the flags that shape other
+		// output would be silently ignored
+		var ignored []string
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "diff", "doc", "input", "color", "no-merge", "no-expand", "debug":
+			default:
+				ignored = append(ignored, flagName(f.Name))
+			}
+		})
+		if len(ignored) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --diff can't be combined with %s\n", strings.Join(ignored, ", "))
+			os.Exit(exitUsage)
+		}
+		runDiff(args, *docIndex, *inputFormat, useColors, *noMerge)
+		return
+	}
+	if *mergePath != "" && (*setExpr != "" || *deleteMode || *deleteShort) {
+		fmt.Fprintln(os.Stderr, "Error: --merge can't be combined with --set or --delete")
+		os.Exit(exitUsage)
+	}
+	if useExists && editing {
+		fmt.Fprintln(os.Stderr, "Error: --exists can't be combined with --set, --delete or --merge")
+		os.Exit(exitUsage)
+	}
+	if *jsonPath && editing {
+		fmt.Fprintln(os.Stderr, "Error: --jsonpath can't be combined with --set, --delete or --merge")
+		os.Exit(exitUsage)
+	}
+	// An edit changes the keys as the pattern names them
+	if (*ignoreCase || *ignoreCaseShort) && editing {
+		fmt.Fprintln(os.Stderr, "Error: --ignore-case can't be combined with --set, --delete or --merge")
+		os.Exit(exitUsage)
+	}
+	if *patternStdin && editing {
+		fmt.Fprintln(os.Stderr, "Error: --pattern-stdin can't be combined with --set, --delete or --merge")
+		os.Exit(exitUsage)
+	}
+	if *patternFile != "" && editing {
+		fmt.Fprintln(os.Stderr, "Error: --pattern-file can't be combined with --set, --delete or --merge")
+		os.Exit(exitUsage)
+	}
+	if *patternFile != "" && *patternStdin {
+		fmt.Fprintln(os.Stderr, "Error: --pattern-file and --pattern-stdin are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if *setExpr != "" {
+		if inputFile != "" {
+			args = append(args, inputFile)
+		}
+		runSet(*setExpr, args, *docIndex, *create, *plain, *inPlace || *inPlaceShort, outputFile, yamlOpts, *inputFormat)
+		return
+	}
+	if *mergePath != "" {
+		if *mergeSeq != "replace" && *mergeSeq != "append" {
+			fmt.Fprintf(os.Stderr, "Error: --merge-seq must be replace or append, got %q\n", *mergeSeq)
+			os.Exit(exitUsage)
+		}
+		if inputFile != "" {
+			args = append(args, inputFile)
+		}
+		runMerge(*mergePath, args, *docIndex, *mergeSeq == "append", *create, *inPlace || *inPlaceShort, outputFile, yamlOpts, *inputFormat)
+		return
+	}
+	if *create {
+		fmt.Fprintln(os.Stderr, "Error: --create only applies to --set and --merge")
+		os.Exit(exitUsage)
+	}
+	if *plain {
+		fmt.Fprintln(os.Stderr, "Error: --plain only applies to --set")
+		os.Exit(exitUsage)
+	}
+	if *deleteMode || *deleteShort {
+		if inputFile != "" {
+			args = append(args, inputFile)
+		}
+		runDelete(args, *docIndex, *inPlace || *inPlaceShort, *strict, outputFile, yamlOpts, *inputFormat)
+		return
+	}
+	if *inPlace || *inPlaceShort {
+		fmt.Fprintln(os.Stderr, "Error: --in-place/-i only applies to --set, --delete and --merge")
+		os.Exit(exitUsage)
+	}
+	// Work out the patterns and the input. With -f/--file every argument
+	// is a pattern; otherwise the original positional forms apply, and
+	// three or more arguments are all patterns read against stdin.
+	var patterns []string
+	filename := expandPath(inputFile)
+	switch {
+	case filename != "" || len(args) == 0:
+		patterns = args
+	case len(args) == 1:
+		// One arg - could be pattern or filename. With --pattern-stdin
+		// or --pattern-file the patterns are elsewhere, so it can only be
+		// the file.
+		if _, err := os.Stat(expandPath(args[0])); err == nil || *patternStdin || *patternFile != "" {
 			// File exists, treat as filename with no pattern
-			filename = args[0]
-			pattern = "."
+			filename = expandPath(args[0])
 		} else {
 			// Treat as pattern, read from stdin
-			pattern = args[0]
-			filename = ""
+			patterns = args
 		}
-	case 2:
+	case *patternFile != "":
+		fmt.Fprintf(os.Stderr, "Error: --pattern-file reads the patterns from %s; name the input with -f to give more on the command line\n", *patternFile)
+		os.Exit(exitUsage)
+	case len(args) == 2:
 		// Two args - pattern and filename
-		pattern = args[0]
-		filename = args[1]
+		patterns = args[:1]
+		filename = expandPath(args[1])
+	default:
+		// A trailing filename is almost certainly meant as the input, not a
+		// pattern - say so rather than silently waiting on stdin
+		if _, err := os.Stat(expandPath(args[len(args)-1])); err == nil {
+			fmt.Fprintf(os.Stderr, "Error: with several patterns, name the input file with -f (gy -f %s ...)\n", args[len(args)-1])
+			os.Exit(exitUsage)
+		}
+		patterns = args
+	}
+	if err := checkOutputFile(outputFile, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	// --pattern-file adds its patterns to any given with -f
+	if *patternFile != "" {
+		filePatterns, err := readPatternFile(expandPath(*patternFile), filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	// A pattern given as an argument wins over $GY_PATTERN, which wins over
+	// --pattern-stdin
+	if len(patterns) == 0 {
+		if env := os.Getenv("GY_PATTERN"); env != "" {
+			patterns = []string{env}
+		} else if *patternStdin {
+			if filename == "" || filename == "-" {
+				fmt.Fprintln(os.Stderr, "Error: --pattern-stdin reads the pattern from stdin, so the document must come from a file")
+				os.Exit(exitUsage)
+			}
+			pattern, err := readPatternLine(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitUsage)
+			}
+			patterns = []string{pattern}
+		}
+	}
+	if len(patterns) == 0 {
+		// No pattern - just round-trip the input
+		patterns = []string{"."}
 	}
 
-	// Read from file or stdin
-	var input []byte
-	var err error
-	if filename != "" {
-		input, err = os.ReadFile(filename)
-	} else {
-		input, err = io.ReadAll(os.Stdin)
+	// A pattern may itself be a union, "a.b, c.d"; each member is searched
+	// and printed as a pattern of its own
+	var members []string
+	var memberOf []int
+	var optional []bool
+	for p, pattern := range patterns {
+		if *jsonPath {
+			translated, err := jsonPathToPattern(pattern)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitUsage)
+			}
+			pattern = translated
+		}
+		union, err := checkPattern(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		for _, member := range union {
+			member, opt := yamlpath.Optional(member)
+			members = append(members, member)
+			memberOf = append(memberOf, p)
+			optional = append(optional, opt)
+		}
 	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if *labels && useList {
+		fmt.Fprintln(os.Stderr, "Error: --labels can't be combined with --list")
+		os.Exit(exitUsage)
+	}
+	useKeys := *keys || *keysSorted
+	if useKeys && useList {
+		fmt.Fprintln(os.Stderr, "Error: --keys can't be combined with --list")
+		os.Exit(exitUsage)
+	}
+	// --values has a meaning of its own with --list, --paths, --leaves and
+	// the searches; anywhere else it projects a mapping's values
+	useValues := *values && !useList && !*paths && !*leaves && *findValue == "" && *findKey == ""
+	if useValues && useKeys {
+		fmt.Fprintln(os.Stderr, "Error: --keys and --values are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if *paths && (useList || *labels || *length || *count) {
+		fmt.Fprintln(os.Stderr, "Error: --paths can't be combined with --list, --labels, --length or --count")
+		os.Exit(exitUsage)
+	}
+	if *leaves && (*tree || *labels || *paths || *length || *count || *jsonOut || *ndjson || useKeys) {
+		fmt.Fprintln(os.Stderr, "Error: --leaves can't be combined with --tree, --labels, --paths, --length, --count, --keys or JSON output")
+		os.Exit(exitUsage)
+	}
+	if *flatten && (useList || *labels || *paths || *leaves || *length || *count || useKeys || useValues) {
+		fmt.Fprintln(os.Stderr, "Error: --flatten can't be combined with --list, --labels, --paths, --leaves, --length, --count, --keys or --values")
+		os.Exit(exitUsage)
+	}
+	// --list --leaves is an inventory of the whole tree, so it only stops
+	// short when --depth says to
+	if *leaves && useList {
+		maxDepth = 0
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "depth" {
+				maxDepth = *depth
+			}
+		})
+	}
+	// --find-value and --find-key share their matching: exact by default,
+	// or a substring or regular expression
+	search, searchFlag := *findValue, "--find-value"
+	if *findKey != "" {
+		search, searchFlag = *findKey, "--find-key"
+	}
+	var searchTest func(string) bool
+	switch {
+	case *findValue != "" && *findKey != "":
+		fmt.Fprintln(os.Stderr, "Error: --find-value and --find-key are mutually exclusive")
+		os.Exit(exitUsage)
+	case (*contains || *regex) && search == "":
+		fmt.Fprintln(os.Stderr, "Error: --contains and --regex only apply to --find-value and --find-key")
+		os.Exit(exitUsage)
+	case *contains && *regex:
+		fmt.Fprintln(os.Stderr, "Error: --contains and --regex are mutually exclusive")
+		os.Exit(exitUsage)
+	case search != "" && (useList || useKeys || *labels || *length || *count):
+		fmt.Fprintf(os.Stderr, "Error: %s can't be combined with --list, --keys, --labels, --length or --count\n", searchFlag)
+		os.Exit(exitUsage)
+	case *regex:
+		re, err := regexp.Compile(search)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid regular expression in %s: %v\n", searchFlag, err)
+			os.Exit(exitUsage)
+		}
+		searchTest = re.MatchString
+	case *contains:
+		searchTest = func(text string) bool { return strings.Contains(text, search) }
+	case search != "":
+		searchTest = func(text string) bool { return text == search }
+	}
+	// A pattern ending in ** or a --find-value search flattens to leaf
+	// lines unless another output was asked for. --find-key prints the
+	// paths it finds, or the leaves below them with -t.
+	useLeaves := *leaves
+	usePaths := *paths
+	if !useList && !*labels && !*paths && !*length && !*count && !*jsonOut && !*ndjson && !useKeys && !useValues && !*flatten && table == "" && env == "" {
+		switch {
+		case *findKey != "" && useTrim:
+			useLeaves = true
+		case *findKey != "":
+			usePaths = true
+		case useTrim:
+		case *findValue != "":
+			useLeaves = true
+		default:
+			for _, member := range members {
+				if yamlpath.EndsInLeaves(member) {
+					useLeaves = true
+				}
+			}
+		}
+	}
+	if (table != "" || env != "") && (useList || *labels || *paths || *leaves || *length || *count) {
+		fmt.Fprintf(os.Stderr, "Error: --output %s can't be combined with --list, --labels, --paths, --leaves, --length or --count\n", *outputFormat)
+		os.Exit(exitUsage)
+	}
+	if *sortLeaves && (!useLeaves || useList) {
+		fmt.Fprintln(os.Stderr, "Error: --sort only applies to path: value lines, from --leaves or --output flat")
+		os.Exit(exitUsage)
+	}
+	if *nullFill && useTrim {
+		fmt.Fprintln(os.Stderr, "Error: --null-fill only applies to output wrapped in its path, not to --trim or --raw")
+		os.Exit(exitUsage)
+	}
+	if tmpl != nil && (useList || *labels || *paths || *leaves || *flatten || *length || *count || *jsonOut || *ndjson || table != "" || env != "") {
+		fmt.Fprintln(os.Stderr, "Error: --template can't be combined with --list, --labels, --paths, --leaves, --flatten, --length, --count or another output format")
+		os.Exit(exitUsage)
+	}
+	if *print0 && *delimiter != "" {
+		fmt.Fprintln(os.Stderr, "Error: --print0 and --delimiter are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if (*print0 || *delimiter != "") && (!useRaw && !usePaths && !useList || *labels || *count || *jsonOut || *ndjson || table != "" || env != "" || tmpl != nil) {
+		fmt.Fprintln(os.Stderr, "Error: --print0 and --delimiter only apply to --raw, --paths and --list output")
+		os.Exit(exitUsage)
+	}
+	if *length && (useList || *labels) {
+		fmt.Fprintln(os.Stderr, "Error: --length can't be combined with --list or --labels")
+		os.Exit(exitUsage)
+	}
+	if *count && (useList || *labels || *length) {
+		fmt.Fprintln(os.Stderr, "Error: --count can't be combined with --list, --labels or --length")
+		os.Exit(exitUsage)
 	}
 
-	// Parse YAML
-	var node yaml.Node
-	err = yaml.Unmarshal(input, &node)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to parse YAML: %v\n", err)
-		os.Exit(1)
+	// --default stands in for a pattern that matches nothing. An empty
+	// default is still a default, so it's told apart by whether it was given.
+	var fallback *yaml.Node
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "default" {
+			fallback = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: *defaultValue}
+		}
+	})
+	switch {
+	case *defaultYAML && fallback == nil:
+		fmt.Fprintln(os.Stderr, "Error: --default-yaml only applies to --default")
+		os.Exit(exitUsage)
+	case fallback != nil && useExists:
+		fmt.Fprintln(os.Stderr, "Error: --default can't be combined with --exists")
+		os.Exit(exitUsage)
+	case *defaultYAML:
+		var parsed yaml.Node
+		if err := yaml.Unmarshal([]byte(*defaultValue), &parsed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --default isn't valid YAML: %s\n", strings.TrimPrefix(err.Error(), "yaml: "))
+			os.Exit(exitUsage)
+		}
+		fallback = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		if len(parsed.Content) > 0 {
+			fallback = parsed.Content[0]
+		}
 	}
 
-	// Extract the target node
-	extracted := extractPath(&node, pattern)
-	if extracted == nil {
-		fmt.Fprintf(os.Stderr, "Path not found: %s\n", pattern)
-		os.Exit(1)
+	// Run each pattern against each document. A document that doesn't
+	// match is skipped rather than aborting the run; output for each
+	// matching document is separated by a document marker.
+	opts := options{
+		trim:        useTrim,
+		list:        useList,
+		flow:        useFlow,
+		block:       useBlock,
+		depth:       maxDepth,
+		json:        *jsonOut,
+		jsonIndent:  *jsonIndent,
+		yamlIndent:  *yamlIndent,
+		sortKeys:    *sortKeys,
+		flushSeqs:   *seqIndent == "flush",
+		raw:         useRaw,
+		values:      *values,
+		types:       *types,
+		tree:        *tree,
+		color:       useColors,
+		noComments:  *noComments,
+		keepTags:    *keepTags,
+		stripTags:   *stripTags,
+		noMerge:     *noMerge,
+		length:      *length,
+		paths:       usePaths,
+		leaves:      useLeaves,
+		nullFill:    *nullFill,
+		sortLeaves:  *sortLeaves,
+		flatten:     *flatten,
+		table:       table,
+		env:         env,
+		prefix:      *prefix,
+		scalarStyle: *style,
+		template:    tmpl,
+	}
+	switch {
+	case *print0:
+		opts.records = &recordWriter{sep: "\x00"}
+	case *delimiter != "":
+		opts.records = &recordWriter{sep: *delimiter, newline: !*noNewline}
+	}
+	// --ndjson is trimmed, compact JSON with every match on a line of its own
+	if *ndjson {
+		if useList {
+			fmt.Fprintln(os.Stderr, "Error: --ndjson can't be combined with --list")
+			os.Exit(exitUsage)
+		}
+		opts.json, opts.ndjson, opts.trim, opts.jsonIndent = true, true, true, 0
+	}
+	// --keys and --values replace each matched mapping by a sequence of
+	// its keys or values
+	var project func(yamlpath.Match) ([]*yaml.Node, error)
+	switch {
+	case useKeys:
+		project = func(m yamlpath.Match) ([]*yaml.Node, error) { return yamlpath.Keys(m, *keysSorted, "--keys") }
+	case useValues:
+		project = func(m yamlpath.Match) ([]*yaml.Node, error) { return yamlpath.Values(m, "--values") }
+	}
+	if project != nil {
+		opts.trim = true
+	}
+	var results []result
+	lasts := make([]*result, len(members))
+	found := make([]bool, len(members))
+	misuse := make([]error, len(members))
+	searched := make([]bool, len(members))
+	w := yamlpath.Walker{Strict: *strict, IgnoreCase: *ignoreCase || *ignoreCaseShort, NoMerge: *noMerge}
+	// --max-results counts each pattern's matches across the documents.
+	// The walk can stop early unless the matches are filtered after it.
+	remaining := make([]int, len(members))
+	for m := range remaining {
+		remaining[m] = limit
+	}
+	// --debug traces each search on stderr, starting with how the pattern
+	// was split, so stdout stays clean for a pipe
+	if *debug {
+		w.Trace = os.Stderr
+		for _, member := range members {
+			for _, path := range yamlpath.Paths(member) {
+				fmt.Fprintf(os.Stderr, "debug: %s splits into %q\n", path, yamlpath.SplitPath(path))
+			}
+		}
 	}
 
-	// --list mode
-	if useList {
-		listNode(extracted, "", maxDepth, 0)
-		os.Exit(0)
+	// With --output-file everything is printed to a temporary file, which
+	// replaces the real one once it's all there - and only if every
+	// pattern matched, so a typo can't empty a file that was fine. With
+	// --stream printing starts before that's known, so the file is opened
+	// up front and dropped on any error.
+	var out *atomicFile
+	openOutput := func() {
+		if out, err = createAtomic(outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot write %s: %v\n", outputFile, describeReadError(err))
+			os.Exit(exitIO)
+		}
+		os.Stdout = out.File
+	}
+	discardOutput := func() {
+		if out != nil {
+			out.discard()
+		}
+	}
+	fail := func(code int) {
+		discardOutput()
+		os.Exit(code)
+	}
+	if *stream && outputFile != "" {
+		openOutput()
+	}
+	printer := resultPrinter{opts: opts, noNewline: *noNewline}
+	labeledDocs := 0
+	emit := func(results []result) {
+		switch {
+		case useExists:
+		case *count:
+			for _, r := range results {
+				fmt.Println(countMatches(r))
+			}
+		case *labels:
+			if len(results) == 0 {
+				return
+			}
+			if labeledDocs > 0 && !opts.json {
+				fmt.Println("---")
+			}
+			labeledDocs++
+			printLabeled(results, opts)
+		default:
+			for _, r := range results {
+				printer.add(r)
+			}
+		}
 	}
 
-	// Normal extraction mode
-	var result *yaml.Node
-	if useTrim {
-		result = extracted
+	// searchDocument runs every pattern against the next document, and
+	// says whether there's any point reading on: not once --doc's document
+	// is done, every pattern has its --max-results, or --exists has found
+	// them all
+	documents := 0
+	searchDocument := func(doc *yaml.Node) bool {
+		d := documents
+		documents++
+		if *docIndex >= 0 && d != *docIndex {
+			return true
+		}
+		for m, member := range members {
+			if limit > 0 && remaining[m] == 0 {
+				continue
+			}
+			if *debug {
+				fmt.Fprintf(os.Stderr, "debug: searching document %d for %s\n", d, member)
+			}
+			w.Limit = remaining[m]
+			if *tag != "" || searchTest != nil || *parent || w.IgnoreCase && yamlpath.Singular(member) {
+				w.Limit = 0
+			}
+			// Extract every node the pattern matches (more than one when it
+			// contains a wildcard)
+			matches, err := w.Find(doc, member)
+			if w.Err() != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				fail(exitNotFound)
+			}
+			// An empty or comment-only document has nothing to print, even
+			// for a pattern that names its root
+			if len(matches) == 1 && emptyDocument(matches[0].Node) {
+				found[m] = true
+				if *count {
+					results = append(results, result{doc: doc, docIndex: d, pattern: member})
+				}
+				continue
+			}
+			if *tag != "" {
+				matches = taggedNodes(matches, *tag)
+			}
+			if searchTest != nil {
+				if len(matches) > 0 {
+					searched[m] = true
+				}
+				if *findKey != "" {
+					matches = keyMatches(matches, searchTest)
+				} else {
+					matches = valueMatches(matches, searchTest)
+				}
+			}
+			// Keys differing only in case all match under --ignore-case,
+			// but where the pattern names a single node the exact-case
+			// one wins
+			if w.IgnoreCase && len(matches) > 1 && yamlpath.Singular(member) {
+				exact := yamlpath.Walker{Strict: w.Strict, NoMerge: w.NoMerge}
+				if found, _ := exact.Find(doc, member); len(found) == 1 {
+					matches = found
+				}
+			}
+			if *parent {
+				matches = parentMatches(doc, matches)
+			}
+			// A key or index that isn't there is a plain miss; anything
+			// else, such as an index into a mapping, explains it
+			plainMiss := err == nil || errors.Is(err, yamlpath.ErrNotFound)
+			if !plainMiss && misuse[m] == nil {
+				misuse[m] = err
+			}
+			// A pattern that can't match is still an error with --default
+			defaulted, usedDefault := false, false
+			if len(matches) == 0 && fallback != nil && plainMiss {
+				matches = []yamlpath.Match{{Node: fallback, Detached: true}}
+				defaulted, usedDefault = !*defaultYAML, true
+			}
+			if useLast && len(matches) > 1 {
+				matches = matches[len(matches)-1:]
+			}
+			if limit > 0 && len(matches) > remaining[m] {
+				matches = matches[:remaining[m]]
+			}
+			if limit > 0 {
+				remaining[m] -= len(matches)
+			}
+			if len(matches) > 0 {
+				found[m] = true
+			}
+			if project != nil {
+				if matches, err = projectMatches(matches, project, useRaw); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					fail(exitNotFound)
+				}
+			}
+			// --raw-strict output is only ever bare text, ready to capture
+			// in a shell variable
+			if *rawStrict {
+				for _, match := range matches {
+					node := match.Node
+					if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+						node = node.Content[0]
+					}
+					if node.Kind != yaml.ScalarNode {
+						fmt.Fprintf(os.Stderr, "Error: --raw-strict needs a scalar, but %s is %s\n", yamlpath.DescribePath(match.Path), yamlpath.KindName(node))
+						fail(exitNotFound)
+					}
+				}
+			}
+			// A table, a set of exports or a template's output is checked before anything's
+			// printed, so a match that can't be one fails the run cleanly
+			if table != "" {
+				if _, err := tableRows(matches, jsonOptions{noMerge: *noMerge}); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					fail(exitNotFound)
+				}
+			}
+			if tmpl != nil {
+				if _, err := renderTemplate(tmpl, matches); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					fail(exitNotFound)
+				}
+			}
+			if env != "" {
+				if _, err := envLines(env, matches, *prefix, *noMerge); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					fail(exitNotFound)
+				}
+			}
+			// --last holds each pattern's latest match back until every
+			// document has been searched; a default only stands in for
+			// one that never matched
+			if useLast && len(matches) > 0 {
+				if !usedDefault || lasts[m] == nil {
+					lasts[m] = &result{doc: doc, docIndex: d, pattern: member, matches: matches, raw: defaulted}
+				}
+				continue
+			}
+			// --count answers 0 for a path that isn't there
+			if len(matches) > 0 || *count && plainMiss {
+				results = append(results, result{doc: doc, docIndex: d, pattern: member, matches: matches, raw: defaulted})
+			}
+		}
+		// --stream is done with the document once its results are out
+		if *stream {
+			emit(results)
+			results = nil
+		}
+		if d == *docIndex {
+			return false
+		}
+		done := true
+		for m := range members {
+			switch {
+			case limit > 0 && remaining[m] > 0:
+				done = false
+			case limit == 0 && !(useExists && found[m]):
+				done = false
+			}
+		}
+		return !done
+	}
+	if *stream {
+		streamInput(filename, *inputFormat, discardOutput, searchDocument)
 	} else {
-		result = wrapInPath(&node, pattern, extracted)
+		docs, _ := loadDocuments(filename, *inputFormat)
+		for _, doc := range docs {
+			if !searchDocument(doc) {
+				break
+			}
+		}
 	}
-
-	if useFlow {
-		forceStyle(result, yaml.FlowStyle)
-	} else if useBlock {
-		forceStyle(result, 0)
+	if *docIndex >= documents {
+		fmt.Fprintf(os.Stderr, "Error: --doc %d out of range (input has %d document(s))\n", *docIndex, documents)
+		fail(exitUsage)
 	}
+	for _, r := range lasts {
+		if r != nil {
+			results = append(results, *r)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].docIndex < results[j].docIndex })
 
-	output, _ := yaml.Marshal(result)
-	fmt.Print(string(output))
-}
+	// An optional pattern that isn't there is fine; one that doesn't fit
+	// the document is still an error
+	for m := range members {
+		if optional[m] && misuse[m] == nil {
+			found[m] = true
+		}
+	}
 
-func wrapInPath(root *yaml.Node, pattern string, extracted *yaml.Node) *yaml.Node {
-	// Remove leading dot
-	if len(pattern) > 0 && pattern[0] == '.' {
-		pattern = pattern[1:]
-	}
-
-	parts := splitPath(pattern)
-
-	// Build the tree from the bottom up
-	var current *yaml.Node = extracted
-	for i := len(parts) - 1; i >= 0; i-- {
-		part := parts[i]
-		// The node being reconstructed at this level originally lived at
-		// parts[:i] in the source document - look it up so the rebuilt
-		// wrapper matches its original flow/block style (e.g. so extracting
-		// from a JSON source keeps looking like JSON) instead of always
-		// defaulting to block style.
-		parent := ancestorNodeAt(root, parts[:i])
-
-		// Handle array indexes like "[0]"
-		if len(part) > 0 && part[0] == '[' && part[len(part)-1] == ']' {
-			// Wrap in a single-element sequence containing just the match.
-			// The original index isn't reconstructed - gy doesn't know what
-			// the skipped elements were, so padding with `null` would imply
-			// entries that don't exist in the source document.
-			indexStr := part[1 : len(part)-1]
-			if _, err := strconv.Atoi(indexStr); err != nil {
-				// If we can't parse the index, just return the extracted node
-				return extracted
-			}
-			seqNode := &yaml.Node{
-				Kind:    yaml.SequenceNode,
-				Content: []*yaml.Node{current},
-			}
-			if parent != nil {
-				seqNode.Style = parent.Style
-			}
-			current = seqNode
-		} else {
-			keyNode := &yaml.Node{
-				Kind:  yaml.ScalarNode,
-				Value: part,
-				Tag:   "!!str",
+	// Every pattern that matched nothing is reported; the others are still
+	// printed. A union counts as found if any member is, unless --strict
+	// asks for all of them. --exists only wants the answer, in the exit
+	// code, so it reports nothing.
+	missing := false
+	for p, pattern := range patterns {
+		var unmatched []int
+		for m := range members {
+			if memberOf[m] == p && !found[m] {
+				unmatched = append(unmatched, m)
 			}
-			if origKey := findMapKey(parent, part); origKey != nil {
-				keyNode.Style = origKey.Style
+		}
+		if len(unmatched) == 0 {
+			continue
+		}
+		total := 0
+		for m := range members {
+			if memberOf[m] == p {
+				total++
 			}
-			mapNode := &yaml.Node{
-				Kind:    yaml.MappingNode,
-				Content: []*yaml.Node{keyNode, current},
+		}
+		switch {
+		case useExists:
+			if total > 1 && len(unmatched) < total && !*strict {
+				continue
 			}
-			if parent != nil {
-				mapNode.Style = parent.Style
+		case total == 1 && misuse[unmatched[0]] != nil:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", misuse[unmatched[0]])
+		case *count && misuse[unmatched[0]] == nil:
+			continue
+		case *findValue != "" && total == 1 && searched[unmatched[0]]:
+			fmt.Fprintf(os.Stderr, "Value not found: %s\n", *findValue)
+		case *findKey != "" && total == 1 && searched[unmatched[0]]:
+			fmt.Fprintf(os.Stderr, "Key not found: %s\n", *findKey)
+		case total == 1 || len(unmatched) == total:
+			fmt.Fprintf(os.Stderr, "Path not found: %s\n", pattern)
+		case *strict:
+			for _, m := range unmatched {
+				fmt.Fprintf(os.Stderr, "Path not found: %s\n", members[m])
 			}
-			current = mapNode
+		default:
+			continue
 		}
+		missing = true
 	}
 
-	return current
-}
-
-// ancestorNodeAt returns the original node found at the given path prefix in
-// root, unwrapping the document node so callers get the actual value node
-// (and its real Style) rather than the always-block DocumentNode wrapper.
-func ancestorNodeAt(root *yaml.Node, parts []string) *yaml.Node {
-	node := walkParts(root, parts)
-	for node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
-		node = node.Content[0]
+	if useExists {
+		if missing {
+			os.Exit(exitNotFound)
+		}
+		return
 	}
-	return node
-}
-
-// findMapKey returns the original key scalar node named key within mapNode,
-// so a reconstructed key can inherit its original quoting style.
-func findMapKey(mapNode *yaml.Node, key string) *yaml.Node {
-	if mapNode == nil || mapNode.Kind != yaml.MappingNode {
-		return nil
+	if outputFile != "" && out == nil {
+		openOutput()
+	}
+	emit(results)
+	printer.flush()
+	if opts.records != nil {
+		opts.records.end()
 	}
-	for i := 0; i+1 < len(mapNode.Content); i += 2 {
-		if mapNode.Content[i].Value == key {
-			return mapNode.Content[i]
+	if out != nil && missing {
+		out.discard()
+	} else if out != nil {
+		if err := out.commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot write %s: %v\n", outputFile, describeReadError(err))
+			os.Exit(exitIO)
 		}
 	}
-	return nil
+	if missing {
+		os.Exit(exitNotFound)
+	}
 }
 
-// forceStyle recursively overrides the flow/block style of every mapping and
-// sequence node in the tree, letting --flow/--block override whatever style
-// wrapInPath inherited from the source document. Scalar nodes have their
-// explicit quote style cleared too (e.g. JSON's mandatory double quotes),
-// so yaml.Marshal picks the cleanest safe representation for the target
-// style instead of dragging along quoting artifacts from the source format.
-func forceStyle(node *yaml.Node, style yaml.Style) {
-	if node == nil {
+// result is what one pattern matched in one document.
+type result struct {
+	doc      *yaml.Node
+	docIndex int
+	pattern  string
+	matches  []yamlpath.Match
+	raw      bool // a --default, printed as given
+}
+
+// resultPrinter prints each result in turn. Results from different
+// documents are separated by a document marker, as are the results of
+// several patterns - except in trim mode, where scalar results simply
+// follow each other one per line. It takes them a result at a time, for
+// --stream, which prints each document's results before reading the next,
+// and keeps only what the separator between two results depends on, not
+// the results.
+type resultPrinter struct {
+	opts      options
+	noNewline bool
+	printed   bool
+	docIndex  int  // of the last result printed
+	scalars   bool // whether the last result printed was all scalars
+	pending   *result
+}
+
+// add prints r. With -n it's held back until the next one, or flush, says
+// whether it's the last.
+func (p *resultPrinter) add(r result) {
+	if !p.noNewline {
+		p.print(r, false)
 		return
 	}
-	switch node.Kind {
-	case yaml.MappingNode, yaml.SequenceNode:
-		node.Style = style
-	case yaml.ScalarNode:
-		node.Style = 0
+	if p.pending != nil {
+		p.print(*p.pending, false)
 	}
-	for _, child := range node.Content {
-		forceStyle(child, style)
+	p.pending = &r
+}
+
+// flush prints a result add held back, as the last.
+func (p *resultPrinter) flush() {
+	if p.pending != nil {
+		p.print(*p.pending, true)
+		p.pending = nil
 	}
 }
 
-func extractPath(node *yaml.Node, pattern string) *yaml.Node {
-	if len(pattern) > 0 && pattern[0] == '.' {
-		pattern = pattern[1:]
+func (p *resultPrinter) print(r result, last bool) {
+	// JSON output is already a stream of self-delimiting values, and
+	// --length, --paths, --leaves, dotenv, properties and templates are one
+	// line per match or leaf. Tables are set apart by a blank line, and
+	// --print0 or --delimiter results by their separator.
+	if p.printed && p.opts.table != "" {
+		fmt.Println()
+	}
+	if p.printed && !p.opts.json && !p.opts.length && !p.opts.paths && !p.opts.leaves && p.opts.table == "" && p.opts.env == "" && p.opts.template == nil && p.opts.records == nil {
+		if p.docIndex != r.docIndex || !p.opts.trim || !p.scalars || !allScalars(r.matches) {
+			fmt.Println("---")
+		}
 	}
+	p.printed, p.docIndex, p.scalars = true, r.docIndex, allScalars(r.matches)
+	// -n only drops the newline at the very end of the output
+	resultOpts := p.opts
+	resultOpts.noNewline = p.noNewline && last
+	if r.raw && !p.opts.json {
+		resultOpts.raw = true
+	}
+	printMatches(r.doc, r.matches, resultOpts)
+}
 
-	if pattern == "" {
-		return node
+// recordWriter separates results with --print0 or --delimiter instead of
+// ending each with a newline: values that have line breaks of their own
+// stay one result each.
+type recordWriter struct {
+	sep     string
+	newline bool // end the output with a newline, after the last result
+	written bool
+}
+
+// write prints record, after the separator unless it's the first.
+func (w *recordWriter) write(record string) {
+	if w.written {
+		fmt.Print(w.sep)
 	}
+	fmt.Print(record)
+	w.written = true
+}
 
-	return walkParts(node, splitPath(pattern))
+// end finishes the output. Nothing follows the last result, so xargs -0
+// sees no empty one, except the newline --delimiter output ends with
+// unless -n is given.
+func (w *recordWriter) end() {
+	if w.written && w.newline {
+		fmt.Println()
+	}
 }
 
-// walkParts walks node following pre-split path parts. It's the shared core
-// of extractPath, also used by wrapInPath to look up an ancestor's original
-// node (and thus its original flow/block style) when reconstructing it.
-func walkParts(node *yaml.Node, parts []string) *yaml.Node {
-	current := node
+// printRecord prints a line of --paths or --list output, or with --print0
+// or --delimiter, a result.
+func printRecord(line string, opts options) {
+	if opts.records != nil {
+		opts.records.write(line)
+		return
+	}
+	fmt.Println(line)
+}
 
-	for partIndex := 0; partIndex < len(parts); {
-		part := parts[partIndex]
-		if part == "" {
-			partIndex++
-			continue // Skip empty parts
+// projectMatches replaces each matched mapping by a sequence of what
+// project picks from it - its keys for --keys, its values for --values -
+// or with raw by those nodes themselves so they print one per line.
+func projectMatches(matches []yamlpath.Match, project func(yamlpath.Match) ([]*yaml.Node, error), raw bool) ([]yamlpath.Match, error) {
+	var projected []yamlpath.Match
+	for _, m := range matches {
+		nodes, err := project(m)
+		if err != nil {
+			return nil, err
 		}
-		if current == nil {
-			return nil
-		}
-
-		processed := false
-		switch current.Kind {
-		case yaml.DocumentNode:
-			if len(current.Content) > 0 {
-				current = current.Content[0]
-				processed = true
-				// Reprocess the same part with the new current node (don't increment partIndex)
-			} else {
-				return nil
-			}
-		case yaml.MappingNode:
-			found := false
-			for i := 0; i < len(current.Content); i += 2 {
-				if i+1 < len(current.Content) && current.Content[i].Value == part {
-					current = current.Content[i+1]
-					found = true
-					processed = true
-					partIndex++ // Move to next part
-					break
-				}
-			}
-			if !found {
-				return nil
-			}
-		case yaml.SequenceNode:
-			// Array access - parse "[0]" into integer
-			if len(part) > 2 && part[0] == '[' && part[len(part)-1] == ']' {
-				indexStr := part[1 : len(part)-1]
-				index, err := strconv.Atoi(indexStr)
-				if err != nil || index < 0 || index >= len(current.Content) {
-					return nil // Invalid index or out of bounds
-				}
-				current = current.Content[index]
-				processed = true
-				partIndex++ // Move to next part
-			} else {
-				return nil
+		if raw {
+			for _, node := range nodes {
+				projected = append(projected, yamlpath.Match{Node: node, Path: m.Path})
 			}
-		default:
-			return nil
+			continue
 		}
+		projected = append(projected, yamlpath.Match{Node: &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: nodes}, Path: m.Path})
+	}
+	return projected, nil
+}
 
-		if !processed {
-			// If we didn't process the part (shouldn't happen in normal flow), move to next
-			partIndex++
+// checkPattern splits pattern into the members of its union and checks
+// each one's syntax, past any optional `?` suffix. A syntax error points
+// into the pattern as it was given, not into the member.
+func checkPattern(pattern string) ([]string, error) {
+	union := yamlpath.SplitUnion(pattern)
+	from := 0
+	for _, member := range union {
+		if member == "" && len(union) > 1 {
+			return nil, fmt.Errorf("empty path in union %q", pattern)
+		}
+		at := from + strings.Index(pattern[from:], member)
+		from = at + len(member)
+		path, _ := yamlpath.Optional(member)
+		if err := yamlpath.Check(path); err != nil {
+			return nil, yamlpath.Shift(err, at)
 		}
 	}
-
-	return current
+	return union, nil
 }
 
-func splitPath(pattern string) []string {
-	var parts []string
-	start := 0
-	inBracket := false
-
-	for i := 0; i < len(pattern); i++ {
-		switch pattern[i] {
-		case '[':
-			if !inBracket {
-				// Add the part before the bracket if it's not empty
-				if i > start {
-					parts = append(parts, pattern[start:i])
-				}
-				start = i
-				inBracket = true
-			}
-		case ']':
-			if inBracket {
-				// Add the bracket part including the brackets
-				parts = append(parts, pattern[start:i+1])
-				start = i + 1
-				inBracket = false
-			}
-		case '.':
-			if !inBracket {
-				// Only add if there's content between dots
-				if i > start {
-					parts = append(parts, pattern[start:i])
-				}
-				start = i + 1
+// runCheckPath implements --check-path: each argument is checked as a
+// pattern, with no document read. A malformed one is a usage error like
+// it is when searching. With --debug, how each pattern was read - its
+// union members, and the parts of each path in them - is printed as JSON.
+func runCheckPath(patterns []string, jsonPath bool, indent int) {
+	if len(patterns) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: gy --check-path pattern [pattern...]")
+		os.Exit(exitUsage)
+	}
+	type parsed struct {
+		Pattern  string     `json:"pattern"`
+		Paths    [][]string `json:"paths"`
+		Optional bool       `json:"optional,omitempty"`
+	}
+	report := []parsed{}
+	for _, pattern := range patterns {
+		if jsonPath {
+			translated, err := jsonPathToPattern(pattern)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitUsage)
 			}
+			pattern = translated
+		}
+		union, err := checkPattern(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		for _, member := range union {
+			entry := parsed{Pattern: member, Paths: [][]string{}}
+			member, entry.Optional = yamlpath.Optional(member)
+			for _, path := range yamlpath.Paths(member) {
+				entry.Paths = append(entry.Paths, append([]string{}, yamlpath.SplitPath(path)...))
+			}
+			report = append(report, entry)
 		}
 	}
-
-	// Add any remaining part if it's not empty
-	if start < len(pattern) {
-		parts = append(parts, pattern[start:])
+	if *debug {
+		printJSON(report, indent)
 	}
+}
 
-	return parts
+// printJSON writes v to stdout as JSON, exiting if it can't be encoded.
+func printJSON(v interface{}, indent int) {
+	output, err := writeJSON(v, indent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to encode JSON: %v\n", err)
+		os.Exit(exitIO)
+	}
+	fmt.Print(string(output))
 }
 
-func listNode(node *yaml.Node, prefix string, maxDepth, currentDepth int) {
-	if node == nil || (maxDepth > 0 && currentDepth >= maxDepth) {
-		return
+// leafValue renders a leaf as --leaves prints it: a one-line YAML scalar,
+// with a multi-line string double-quoted, or `{}`/`[]` for an empty
+// collection.
+func leafValue(node *yaml.Node) string {
+	leaf := *spelledNull(node)
+	leaf.HeadComment, leaf.LineComment, leaf.FootComment, leaf.Anchor = "", "", "", ""
+	switch {
+	case leaf.Kind != yaml.ScalarNode:
+		leaf.Style = yaml.FlowStyle
+	case leaf.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || strings.Contains(leaf.Value, "\n"):
+		leaf.Style = leaf.Style&yaml.TaggedStyle | yaml.DoubleQuotedStyle
+	}
+	output, err := yaml.Marshal(&leaf)
+	if err != nil {
+		return leaf.Value
 	}
+	return strings.TrimSuffix(string(output), "\n")
+}
 
+// flattenMatches builds the mapping --flatten prints: an entry for each
+// leaf at or below the matches - each scalar, and each empty mapping or
+// sequence, since it has nothing below it to flatten - keyed by its full
+// path and in document order. A non-empty collection never gets an entry
+// of its own, only its leaves do. A value that isn't in the document is
+// keyed by its path from itself, `.` for a scalar. Comments above or below
+// a leaf are dropped, since they'd land between unrelated entries.
+func flattenMatches(matches []yamlpath.Match, noMerge bool) *yaml.Node {
+	flat := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	seen := make(map[string]bool)
+	w := yamlpath.Walker{NoMerge: noMerge}
+	for _, m := range matches {
+		for _, leaf := range w.Leaves(m) {
+			key := pathOf(leaf)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			value := *leaf.Node
+			value.HeadComment, value.FootComment, value.Anchor = "", "", ""
+			flat.Content = append(flat.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &value)
+		}
+	}
+	return flat
+}
+
+// pathOf is the concrete path of a match as --paths prints it, `.` for
+// the document root.
+func pathOf(m yamlpath.Match) string {
+	if len(m.Path) == 0 {
+		return "."
+	}
+	return yamlpath.FormatPath(m.Path)
+}
+
+// comparePaths orders two concrete paths part by part, for --sort: keys
+// by name and indexes by number, so items[2] comes before items[10]. An
+// index sorts before a key, and a path before any that continue it.
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aIndex, bIndex := yamlpath.IsIndexPart(a[i]), yamlpath.IsIndexPart(b[i])
+		switch {
+		case aIndex && bIndex:
+			x, _ := strconv.Atoi(strings.Trim(a[i], "[]"))
+			y, _ := strconv.Atoi(strings.Trim(b[i], "[]"))
+			if x != y {
+				return x - y
+			}
+		case aIndex != bIndex:
+			if aIndex {
+				return -1
+			}
+			return 1
+		default:
+			if c := strings.Compare(yamlpath.KeyName(a[i]), yamlpath.KeyName(b[i])); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// taggedNodes replaces each match by the nodes at or below it whose tag is
+// tag, in document order. Tags compare in their short form, so `!!str` and
+// `tag:yaml.org,2002:str` are the same, and an untagged scalar has the tag
+// its value resolves to - a plain `2024-01-02` is a `!!timestamp`.
+func taggedNodes(matches []yamlpath.Match, tag string) []yamlpath.Match {
+	tag = (&yaml.Node{Tag: tag}).ShortTag()
+	var tagged []yamlpath.Match
+	var collect func(node *yaml.Node, path []string)
+	collect = func(node *yaml.Node, path []string) {
+		if node.Kind != yaml.DocumentNode && node.ShortTag() == tag {
+			tagged = append(tagged, yamlpath.Match{Node: node, Path: append([]string(nil), path...)})
+		}
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				collect(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				collect(node.Content[i+1], append(path, yamlpath.EscapeKey(node.Content[i].Value)))
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				collect(item, append(path, "["+strconv.Itoa(i)+"]"))
+			}
+		}
+	}
+	for _, m := range matches {
+		if m.Detached {
+			if m.Node.ShortTag() == tag {
+				tagged = append(tagged, m)
+			}
+			continue
+		}
+		collect(m.Node, m.Path)
+	}
+	return yamlpath.Dedupe(tagged)
+}
+
+// valueMatches replaces each match by the scalars at or below it whose
+// value passes test, in document order - the reverse of extraction, from a
+// value to the paths it's found at. Aliases and merge keys only repeat
+// values defined elsewhere, so each value is found where it's written.
+func valueMatches(matches []yamlpath.Match, test func(string) bool) []yamlpath.Match {
+	var found []yamlpath.Match
+	var search func(node *yaml.Node, path []string)
+	search = func(node *yaml.Node, path []string) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if test(node.Value) {
+				found = append(found, yamlpath.Match{Node: node, Path: append([]string(nil), path...)})
+			}
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				search(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if !yamlpath.IsMergeKey(node.Content[i]) {
+					search(node.Content[i+1], append(path, yamlpath.EscapeKey(node.Content[i].Value)))
+				}
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				search(item, append(path, "["+strconv.Itoa(i)+"]"))
+			}
+		}
+	}
+	for _, m := range matches {
+		if m.Detached {
+			if m.Node.Kind == yaml.ScalarNode && test(m.Node.Value) {
+				found = append(found, m)
+			}
+			continue
+		}
+		search(m.Node, m.Path)
+	}
+	return yamlpath.Dedupe(found)
+}
+
+// keyMatches replaces each match by the values of the mapping entries at or
+// below it whose key passes test, in document order, so `password` finds
+// every password however deeply it's nested. Like valueMatches, it finds
+// each entry only where it's written.
+func keyMatches(matches []yamlpath.Match, test func(string) bool) []yamlpath.Match {
+	var found []yamlpath.Match
+	var search func(node *yaml.Node, path []string)
+	search = func(node *yaml.Node, path []string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				search(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if yamlpath.IsMergeKey(key) {
+					continue
+				}
+				entry := append(path, yamlpath.EscapeKey(key.Value))
+				if test(key.Value) {
+					found = append(found, yamlpath.Match{Node: value, Path: append([]string(nil), entry...)})
+				}
+				search(value, entry)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				search(item, append(path, "["+strconv.Itoa(i)+"]"))
+			}
+		}
+	}
+	for _, m := range matches {
+		if !m.Detached {
+			search(m.Node, m.Path)
+		}
+	}
+	return yamlpath.Dedupe(found)
+}
+
+// parentMatches replaces each match by the mapping or sequence it's in, at
+// its path less the last part, so a pattern ending in a wildcard gives the
+// node it ranged over, once. A slice or index list builds a sequence of its
+// own at the path of the one it selects from, which is its elements'
+// parent. A top-level key's parent is the document's root; the root
+// itself, and a --default value, have none.
+func parentMatches(doc *yaml.Node, matches []yamlpath.Match) []yamlpath.Match {
+	var parents []yamlpath.Match
+	for _, m := range matches {
+		if m.Detached {
+			continue
+		}
+		path := m.Path
+		if at := yamlpath.WalkParts(doc, path); at == nil || at == m.Node || at.Kind == yaml.DocumentNode && len(at.Content) > 0 && at.Content[0] == m.Node {
+			if len(path) == 0 {
+				continue
+			}
+			path = path[:len(path)-1]
+		}
+		node := yamlpath.WalkParts(doc, path)
+		if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+			node = node.Content[0]
+		}
+		if node != nil {
+			parents = append(parents, yamlpath.Match{Node: node, Path: append([]string(nil), path...)})
+		}
+	}
+	return yamlpath.Dedupe(parents)
+}
+
+// countMatches is the --count of a result: how many nodes the pattern
+// matched, null ones included. A slice or index list counts the elements
+// it selected rather than the sub-sequence it builds from them.
+func countMatches(r result) int {
+	n := 0
+	for _, m := range r.matches {
+		if !m.Detached && m.Node.Kind == yaml.SequenceNode {
+			if original := yamlpath.WalkParts(r.doc, m.Path); original != nil && original.Kind == yaml.SequenceNode && original != m.Node {
+				n += len(m.Node.Content)
+				continue
+			}
+		}
+		n++
+	}
+	return n
+}
+
+// allScalars reports whether every match is a scalar.
+func allScalars(matches []yamlpath.Match) bool {
+	for _, m := range matches {
+		if m.Node.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// printLabeled prints the results for each document as a single mapping
+// from pattern to value, e.g. `name: web` - the --labels form, which stays
+// valid YAML (or JSON) however many patterns there are. A pattern with
+// several matches maps to a sequence of them.
+func printLabeled(results []result, opts options) {
+	var labeled *yaml.Node
+	flush := func() {
+		if labeled == nil {
+			return
+		}
+		if opts.json {
+			printJSON(jsonValue(labeled, opts.jsonOptions()), opts.jsonIndent)
+		} else {
+			printNode(labeled, opts)
+		}
+	}
+	for i, r := range results {
+		if i == 0 || r.docIndex != results[i-1].docIndex {
+			flush()
+			if i > 0 && !opts.json {
+				fmt.Println("---")
+			}
+			labeled = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		value := r.matches[0].Node
+		if len(r.matches) > 1 {
+			value = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for _, m := range r.matches {
+				value.Content = append(value.Content, m.Node)
+			}
+		}
+		label := strings.TrimPrefix(r.pattern, ".")
+		if label == "" {
+			label = "."
+		}
+		labeled.Content = append(labeled.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: label}, value)
+	}
+	flush()
+}
+
+// usage prints the -h/--help text and is shown for unknown flags.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gy [flags] [pattern...] [-f|--file FILE]")
+	fmt.Fprintln(os.Stderr, "       gy [flags] [pattern] [FILE]")
+	fmt.Fprintln(os.Stderr, "Flags may come before or after patterns; -f - reads stdin, and -- ends the flags.")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+// parseArgs parses flags wherever they appear among the arguments, not just
+// before the first positional one as flag.Parse does, so `gy .key -f
+// config.yml` works as well as `gy -f config.yml .key`. Everything after
+// `--` is positional, for a pattern that itself starts with a dash.
+func parseArgs(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args) // exits on a bad flag
+		rest := flag.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...)
+		}
+		if len(rest) == 0 {
+			return positional
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// flagName is a flag as it's written on the command line: -x for a
+// single letter, --name otherwise.
+func flagName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// loadDocuments reads and parses every document from filename, or from
+// stdin when filename is empty or "-", exiting with a clean message if it
+// can't. format is "yaml", "json", "toml" or "auto"; the format actually
+// parsed is returned alongside the documents.
+func loadDocuments(filename, format string) ([]*yaml.Node, string) {
+	var input []byte
+	var err error
+	source := "stdin"
+	if filename != "" && filename != "-" {
+		source = filename
+		input, err = os.ReadFile(filename)
+	} else {
+		input, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot read %s: %v\n", source, describeReadError(err))
+		os.Exit(exitIO)
+	}
+
+	// Parse every document in the stream, not just the first
+	docs, format, err := parseInput(input, filename, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to parse %s in %s: %s\n", strings.ToUpper(format), source, strings.TrimPrefix(err.Error(), "yaml: "))
+		os.Exit(exitIO)
+	}
+	return docs, format
+}
+
+// envVar matches a $VAR or ${VAR} reference in a filename.
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// expandPath expands a leading ~ in a filename to the home directory, and
+// $VAR or ${VAR} to the variable's value, the way a shell would have if
+// the name hadn't been quoted. A variable that isn't set is left as
+// written, as is ~ when there's no home directory. --no-expand turns it
+// off for names that really contain them.
+func expandPath(name string) string {
+	if *noExpand || name == "" || name == "-" {
+		return name
+	}
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			name = home + name[1:]
+		}
+	}
+	return envVar.ReplaceAllStringFunc(name, func(ref string) string {
+		match := envVar.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(match[1] + match[2]); ok {
+			return value
+		}
+		return ref
+	})
+}
+
+// readPatternFile reads the patterns --pattern-file names, one per line.
+// Blank lines and lines starting with # are skipped, and surrounding space
+// is trimmed. "-" reads them from stdin, so the document, input, must then
+// come from a file.
+func readPatternFile(name, input string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	source := "stdin"
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %v", name, describeReadError(err))
+		}
+		defer f.Close()
+		r, source = f, name
+	} else if input == "" || input == "-" {
+		return nil, errors.New("--pattern-file - reads the patterns from stdin, so the document must come from a file")
+	}
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", source, describeReadError(err))
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("--pattern-file found no patterns in %s", source)
+	}
+	return patterns, nil
+}
+
+// readPatternLine reads the pattern --pattern-stdin takes from the first
+// line of r. Anything after that line is ignored.
+func readPatternLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("cannot read the pattern from stdin: %v", describeReadError(err))
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return "", errors.New("--pattern-stdin found no pattern on the first line of stdin")
+	}
+	return line, nil
+}
+
+// tomlKeyLine matches a TOML key/value line, which YAML would otherwise
+// read as one plain string.
+var tomlKeyLine = regexp.MustCompile(`^\s*[A-Za-z0-9_.\-"' ]+=`)
+
+// parseInput parses input as format. For "auto" the filename's extension
+// decides; failing that, input that opens with a TOML key = value line is
+// TOML, and anything else is YAML (JSON included), falling back to TOML
+// when it doesn't parse as YAML.
+func parseInput(input []byte, filename, format string) ([]*yaml.Node, string, error) {
+	input = normalizeInput(input)
+	if format == "auto" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".toml":
+			format = "toml"
+		case ".json":
+			format = "json"
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			if tomlKeyLine.MatchString(firstContentLine(input)) {
+				format = "toml"
+				break
+			}
+			docs, err := parseDocuments(input)
+			if err != nil {
+				if tomlDocs, tomlErr := parseTOML(input); tomlErr == nil {
+					return tomlDocs, "toml", nil
+				}
+			}
+			return docs, "yaml", err
+		}
+	}
+	if format == "toml" {
+		docs, err := parseTOML(input)
+		return docs, format, err
+	}
+	// JSON is YAML, so one parser serves both
+	docs, err := parseDocuments(input)
+	return docs, format, err
+}
+
+// utf8BOM is the byte order mark some Windows editors start a file with.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// normalizeInput strips a leading UTF-8 byte order mark and turns CRLF
+// line endings into LF, so a file saved on Windows reads the same as one
+// that wasn't: no stray \r at the end of a value, and no BOM in front of
+// the first key. Clean input is returned unchanged.
+func normalizeInput(input []byte) []byte {
+	input = bytes.TrimPrefix(input, utf8BOM)
+	if bytes.Contains(input, []byte("\r\n")) {
+		input = bytes.ReplaceAll(input, []byte("\r\n"), []byte("\n"))
+	}
+	return input
+}
+
+// firstContentLine returns the first line of input that isn't blank or a
+// comment.
+func firstContentLine(input []byte) string {
+	for _, line := range strings.Split(string(input), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return line
+		}
+	}
+	return ""
+}
+
+// describeReadError strips the operation and path from file errors, which
+// the caller already names, leaving e.g. "no such file or directory".
+func describeReadError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// options holds the output-shaping flags, resolved from their long and short
+// forms.
+type options struct {
+	trim        bool
+	list        bool
+	flow        bool
+	block       bool
+	depth       int
+	json        bool
+	jsonIndent  int
+	yamlIndent  int
+	sortKeys    bool // sort every mapping's keys, in YAML and JSON
+	flushSeqs   bool // --seq-indent flush
+	raw         bool
+	noNewline   bool // with raw, omit the newline after the last scalar
+	values      bool // with list, show leaf scalars' values; with paths, each match's
+	types       bool // with list, show each entry's type
+	tree        bool // with list, draw tree-style connectors
+	color       bool // color list mode and YAML output
+	noComments  bool
+	keepTags    bool               // write every scalar's tag
+	stripTags   bool               // drop standard tags the values don't need
+	noMerge     bool               // with list and JSON, show << merge keys instead of the merged keys
+	length      bool               // print each match's size instead of its value
+	paths       bool               // print each match's path instead of its value
+	leaves      bool               // print each scalar below each match as path: value
+	sortLeaves  bool               // with leaves, sort the lines by path
+	flatten     bool               // print the matches as one mapping of leaf paths to values
+	nullFill    bool               // pad rebuilt sequences with nulls up to each element's index
+	ndjson      bool               // with json, print each match as its own value
+	table       string             // "csv" or "tsv" to print the matches as a table
+	env         string             // "dotenv", "properties" or "shell" to print their leaves as key=value
+	prefix      string             // with env, put before every name
+	scalarStyle string             // --style: how to quote string values, "keep" for as written
+	template    *template.Template // print each match through this instead
+	records     *recordWriter      // with --print0 or --delimiter, what separates raw values, paths and list lines
+}
+
+// jsonOptions are the settings of opts that JSON output is converted with.
+func (opts options) jsonOptions() jsonOptions {
+	return jsonOptions{noMerge: opts.noMerge, sortKeys: opts.sortKeys}
+}
+
+// yamlOptions are the settings of opts that YAML output is encoded with.
+func (opts options) yamlOptions() yamlOptions {
+	return yamlOptions{indent: opts.yamlIndent, sortKeys: opts.sortKeys, flushSeqs: opts.flushSeqs}
+}
+
+// parseDocuments decodes every document in a (possibly multi-document) YAML
+// stream. Empty input yields a single empty node, so it still round-trips
+// the way a single yaml.Unmarshal would.
+func parseDocuments(input []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(input))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &node)
+	}
+	if len(docs) == 0 {
+		docs = append(docs, &yaml.Node{})
+	}
+	return docs, nil
+}
+
+// emptyDocument reports whether node is a document with nothing in it: an
+// empty or comment-only stream, which parses to a zero node, or a bare `---`,
+// whose content is an empty null.
+func emptyDocument(node *yaml.Node) bool {
+	switch {
+	case node.Kind == 0:
+		return true
+	case node.Kind != yaml.DocumentNode:
+		return false
+	case len(node.Content) == 0:
+		return true
+	}
+	content := node.Content[0]
+	return len(node.Content) == 1 && content.Kind == yaml.ScalarNode && content.Value == "" &&
+		content.Tag == "!!null" && content.Style == 0 && content.HeadComment == "" && content.LineComment == ""
+}
+
+// printMatches writes the matches found in a single document according to
+// the output mode.
+func printMatches(doc *yaml.Node, matches []yamlpath.Match, opts options) {
+	// A value that isn't in the document has nowhere to be wrapped
+	for _, m := range matches {
+		if m.Detached {
+			opts.trim = true
+		}
+	}
+
+	// --flatten gathers every leaf below the matches into one mapping,
+	// which is then printed like any value that isn't in the document
+	if opts.flatten {
+		matches = []yamlpath.Match{{Node: flattenMatches(matches, opts.noMerge), Detached: true}}
+		opts.trim = true
+	}
+
+	// A table's rows are the matches, or the elements of one sequence
+	if opts.table != "" {
+		if err := printTable(matches, opts.table == "tsv", opts.jsonOptions()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		return
+	}
+
+	// --template prints what it makes of each match, and nothing else
+	if opts.template != nil {
+		text, err := renderTemplate(opts.template, matches)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		fmt.Print(text)
+		return
+	}
+
+	// dotenv, properties and shell output are a key=value line per leaf
+	if opts.env != "" {
+		lines, _ := envLines(opts.env, matches, opts.prefix, opts.noMerge)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	// --paths prints where each match is, one per line, and with --values
+	// what's there too, as path: value. A value that isn't in the document
+	// has no path to print.
+	if opts.paths {
+		for _, m := range matches {
+			switch {
+			case m.Detached:
+			case opts.values:
+				printRecord(fmt.Sprintf("%s: %s", paint(pathOf(m), colorKey, opts.color), paint(leafValue(m.Node), colorValue, opts.color)), opts)
+			default:
+				printRecord(pathOf(m), opts)
+			}
+		}
+		return
+	}
+
+	// --leaves flattens each match to a `path: value` line per leaf, for
+	// grepping. A value that isn't in the document is printed alone.
+	if opts.leaves && !opts.list {
+		var lines []yamlpath.Match
+		for _, m := range matches {
+			if m.Detached {
+				lines = append(lines, m)
+				continue
+			}
+			w := yamlpath.Walker{NoMerge: opts.noMerge}
+			lines = append(lines, w.Leaves(m)...)
+		}
+		if opts.sortLeaves {
+			sort.SliceStable(lines, func(i, j int) bool { return comparePaths(lines[i].Path, lines[j].Path) < 0 })
+		}
+		for _, m := range lines {
+			if m.Detached {
+				fmt.Println(paint(leafValue(m.Node), colorValue, opts.color))
+				continue
+			}
+			fmt.Printf("%s: %s\n", paint(pathOf(m), colorKey, opts.color), paint(leafValue(m.Node), colorValue, opts.color))
+		}
+		return
+	}
+
+	// --length prints one bare integer per match, for shell arithmetic
+	if opts.length {
+		for _, m := range matches {
+			fmt.Println(yamlpath.Length(m.Node))
+		}
+		return
+	}
+
+	// --list mode. A single match lists its children directly; with several
+	// (e.g. from a wildcard) each match's listing is headed by its concrete
+	// path and indented beneath it, so it's clear which children belong
+	// where.
+	if opts.list {
+		// With --leaves every line is a full path, so none need a heading
+		if opts.leaves {
+			for _, m := range matches {
+				listNode(m.Node, yamlpath.FormatPath(m.Path), opts, 0)
+			}
+			return
+		}
+		if len(matches) == 1 {
+			listNode(matches[0].Node, "", opts, 0)
+			return
+		}
+		for _, m := range matches {
+			printRecord(yamlpath.FormatPath(m.Path), opts)
+			listNode(m.Node, "  ", opts, 0)
+		}
+		return
+	}
+
+	// JSON mode prints one JSON value: a trimmed single match as itself,
+	// several trimmed matches as an array, or the wrapped tree.
+	if opts.json {
+		if opts.ndjson {
+			for _, m := range matches {
+				printJSON(jsonValue(m.Node, opts.jsonOptions()), 0)
+			}
+			return
+		}
+		var value interface{}
+		switch {
+		case !opts.trim:
+			value = jsonValue(wrapMatches(doc, matches, opts), opts.jsonOptions())
+		case len(matches) == 1:
+			value = jsonValue(matches[0].Node, opts.jsonOptions())
+		default:
+			values := make([]interface{}, 0, len(matches))
+			for _, m := range matches {
+				values = append(values, jsonValue(m.Node, opts.jsonOptions()))
+			}
+			value = values
+		}
+		printJSON(value, opts.jsonIndent)
+		return
+	}
+
+	// Trim mode prints each match on its own; scalars one per line,
+	// collections separated by document markers so they stay distinguishable.
+	// Raw mode prints scalars as their bare value, unquoted; collections
+	// have no raw form and fall back to YAML.
+	if opts.trim {
+		for i, m := range matches {
+			// --print0 and --delimiter set every value apart, collections
+			// as YAML
+			if opts.records != nil {
+				if m.Node.Kind == yaml.ScalarNode {
+					opts.records.write(spelledNull(m.Node).Value)
+				} else {
+					opts.records.write(strings.TrimSuffix(formatNode(spelledNull(withKeyComments(doc, m)), opts), "\n"))
+				}
+				continue
+			}
+			if i > 0 && (m.Node.Kind != yaml.ScalarNode || matches[i-1].Node.Kind != yaml.ScalarNode) {
+				fmt.Println("---")
+			}
+			if opts.raw && m.Node.Kind == yaml.ScalarNode {
+				fmt.Print(spelledNull(m.Node).Value)
+				if !opts.noNewline || i < len(matches)-1 {
+					fmt.Println()
+				}
+				continue
+			}
+			printNode(spelledNull(withKeyComments(doc, m)), opts)
+		}
+		return
+	}
+
+	// Normal extraction mode - every match is wrapped back into its real
+	// path, sharing ancestors, so the output is a subset of the source tree.
+	printNode(wrapMatches(doc, matches, opts), opts)
+}
+
+// wrapMatches rebuilds the matches' ancestry for printing, padding each
+// sequence with nulls to the matched indexes with --null-fill.
+func wrapMatches(doc *yaml.Node, matches []yamlpath.Match, opts options) *yaml.Node {
+	if opts.nullFill {
+		return yamlpath.WrapNullFill(doc, matches)
+	}
+	return yamlpath.Wrap(doc, matches)
+}
+
+// withKeyComments returns a trimmed match carrying the head and foot
+// comments of the key it sat under, which would otherwise be lost with the
+// key. The match itself is copied, not modified.
+func withKeyComments(doc *yaml.Node, m yamlpath.Match) *yaml.Node {
+	parent, index := parentOf(doc, m)
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return m.Node
+	}
+	key := parent.Content[index]
+	if key.HeadComment == "" && key.FootComment == "" {
+		return m.Node
+	}
+	node := *m.Node
+	if node.HeadComment == "" {
+		node.HeadComment = key.HeadComment
+	}
+	if node.FootComment == "" {
+		node.FootComment = key.FootComment
+	}
+	return &node
+}
+
+// spelledNull returns node, or for a null written as nothing at all, like
+// `strategy:`, a copy spelled `null` - so a key that's there with no value
+// prints as null rather than a blank line that looks like nothing was found.
+func spelledNull(node *yaml.Node) *yaml.Node {
+	if node.Kind != yaml.ScalarNode || node.Value != "" || node.ShortTag() != "!!null" {
+		return node
+	}
+	null := *node
+	null.Value, null.Style = "null", 0
+	return &null
+}
+
+// stripComments clears every comment in the tree under node.
+func stripComments(node *yaml.Node) {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	for _, child := range node.Content {
+		stripComments(child)
+	}
+}
+
+func printNode(result *yaml.Node, opts options) {
+	fmt.Print(formatNode(result, opts))
+}
+
+// formatNode is result as printNode prints it.
+func formatNode(result *yaml.Node, opts options) string {
+	// The result can share its nodes with the document, which other
+	// patterns go on reading, so anything restyled is a copy
+	style, restyle := scalarStyles[opts.scalarStyle]
+	if opts.noComments || opts.flow || opts.block || restyle || opts.keepTags || opts.stripTags || opts.sortKeys {
+		result = copyNode(result)
+	}
+	// A comment would end the line --flow keeps everything on
+	if opts.noComments || opts.flow {
+		stripComments(result)
+	}
+	if opts.flow {
+		forceStyle(result, yaml.FlowStyle)
+	} else if opts.block {
+		forceStyle(result, 0)
+	}
+	if restyle {
+		setScalarStyle(result, style)
+	}
+	if opts.flow {
+		singleLineScalars(result)
+	}
+	if opts.keepTags || opts.stripTags {
+		setTagged(result, opts.keepTags)
+	}
+
+	output, err := encodeYAML([]*yaml.Node{result}, opts.yamlOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to encode YAML: %v\n", err)
+		os.Exit(exitIO)
+	}
+	if opts.color {
+		return highlightYAML(string(output), result)
+	}
+	return string(output)
+}
+
+// singleLineScalars double-quotes every scalar that would otherwise take
+// more than one line - a literal or folded block, or any text with a line
+// break in it - so --flow output is a single line, with \n escapes.
+func singleLineScalars(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && (node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || strings.Contains(node.Value, "\n")) {
+		node.Style = node.Style&yaml.TaggedStyle | yaml.DoubleQuotedStyle
+	}
+	for _, child := range node.Content {
+		singleLineScalars(child)
+	}
+}
+
+// forceStyle recursively overrides the flow/block style of every mapping and
+// sequence node in the tree, letting --flow/--block override whatever style
+// Wrap inherited from the source document. Scalar nodes have their
+// explicit quote style cleared too (e.g. JSON's mandatory double quotes),
+// so yaml.Marshal picks the cleanest safe representation for the target
+// style instead of dragging along quoting artifacts from the source format.
+func forceStyle(node *yaml.Node, style yaml.Style) {
+	if node == nil {
+		return
+	}
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		node.Style = style
+	case yaml.ScalarNode:
+		// A tag written on the value stays written
+		node.Style &= yaml.TaggedStyle
+	}
+	for _, child := range node.Content {
+		forceStyle(child, style)
+	}
+}
+
+// scalarStyles are the --style overrides. keep, the default, isn't one:
+// each scalar is printed as it was written.
+var scalarStyles = map[string]yaml.Style{
+	"plain":   0,
+	"double":  yaml.DoubleQuotedStyle,
+	"single":  yaml.SingleQuotedStyle,
+	"literal": yaml.LiteralStyle,
+	"folded":  yaml.FoldedStyle,
+}
+
+// setScalarStyle gives every string value in the tree style. Keys keep
+// theirs, and so do numbers, booleans and the like, which would turn into
+// strings if they were quoted. Where a string can't be written in style,
+// such as plain text that would read as a number, the encoder quotes it.
+func setScalarStyle(node *yaml.Node, style yaml.Style) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+		node.Style = node.Style&yaml.TaggedStyle | style
+	}
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		setScalarStyle(child, style)
+	}
+}
+
+// setTagged writes every scalar's tag for --keep-tags, keys included, or
+// for --strip-tags writes none of the standard ones it can leave out. A
+// string that would read as something else without its !!str is quoted
+// instead, a quoted value another tag made a number is unquoted, and a tag
+// the value can't do without, such as !!float on 1, is still written.
+// Custom tags like !Ref are always kept.
+func setTagged(node *yaml.Node, keep bool) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.ScalarNode {
+		switch {
+		case keep:
+			node.Tag = node.ShortTag()
+			node.Style |= yaml.TaggedStyle
+		case node.ShortTag() != "!!str" && node.ShortTag() == (&yaml.Node{Kind: yaml.ScalarNode, Value: node.Value}).ShortTag():
+			// `!!int "30"` is 30, unquoted
+			node.Style = 0
+		case strings.HasPrefix(node.ShortTag(), "!!"):
+			node.Style &^= yaml.TaggedStyle
+		}
+	}
+	for _, child := range node.Content {
+		setTagged(child, keep)
+	}
+}
+
+// listNode prints the keys or indexes under node, one per line, nesting
+// each level below its parent down to opts.depth. Nesting is two spaces, or
+// with opts.tree the connectors of the tree command.
+func listNode(node *yaml.Node, prefix string, opts options, currentDepth int) {
+	if node == nil || (opts.depth > 0 && currentDepth >= opts.depth) {
+		return
+	}
+
+	// Each entry keeps the node as written, for --types, and the node it
+	// lists, which follows an alias
+	type entry struct {
+		key    string
+		path   string
+		raw    *yaml.Node
+		listed *yaml.Node
+	}
+	var entries []entry
 	switch node.Kind {
 	case yaml.DocumentNode:
 		if len(node.Content) > 0 {
-			listNode(node.Content[0], prefix, maxDepth, currentDepth)
+			listNode(node.Content[0], prefix, opts, currentDepth)
 		}
 	case yaml.MappingNode:
-		for i := 0; i < len(node.Content); i += 2 {
-			if i+1 < len(node.Content) {
-				keyNode := node.Content[i]
-				valueNode := node.Content[i+1]
-				fmt.Printf("%s%s\n", prefix, keyNode.Value)
-				listNode(valueNode, prefix+"  ", maxDepth, currentDepth+1)
+		content := node.Content
+		if !opts.noMerge {
+			content = yamlpath.MergedContent(node)
+		}
+		for i := 0; i+1 < len(content); i += 2 {
+			key := content[i].Value
+			path := yamlpath.EscapeKey(key)
+			if prefix != "" {
+				path = prefix + "." + path
 			}
+			entries = append(entries, entry{key, path, content[i+1], listedNode(content[i+1])})
 		}
 	case yaml.SequenceNode:
 		for i, item := range node.Content {
-			fmt.Printf("%s[%d]\n", prefix, i)
-			listNode(item, prefix+"  ", maxDepth, currentDepth+1)
+			key := fmt.Sprintf("[%d]", i)
+			entries = append(entries, entry{key, prefix + key, item, listedNode(item)})
 		}
 	default:
 		// Scalar - no children to list
 	}
+
+	// --leaves lists no structure, only the full path to each leaf, which
+	// the recursion builds up in prefix in place of the indentation
+	if opts.leaves {
+		for _, e := range entries {
+			if !isLeaf(e.listed) {
+				listNode(e.listed, e.path, opts, currentDepth+1)
+				continue
+			}
+			line := paint(e.path, colorKey, opts.color)
+			if opts.values {
+				line += " = " + paint(leafValue(e.listed), colorValue, opts.color)
+			}
+			printRecord(line+listType(e.raw, opts), opts)
+		}
+		return
+	}
+
+	for i, e := range entries {
+		line, childPrefix := prefix, prefix+"  "
+		if opts.tree {
+			// The last child closes its branch, so nothing hangs below it
+			if i == len(entries)-1 {
+				line, childPrefix = prefix+"└── ", prefix+"    "
+			} else {
+				line, childPrefix = prefix+"├── ", prefix+"│   "
+			}
+		}
+		printRecord(line+paint(e.key, colorKey, opts.color)+listValue(e.listed, opts)+listType(e.raw, opts), opts)
+		listNode(e.listed, childPrefix, opts, currentDepth+1)
+	}
+}
+
+// isLeaf reports whether node has nothing below it to list: a scalar, an
+// empty mapping or sequence, or an alias left unfollowed.
+func isLeaf(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode, yaml.DocumentNode:
+		return len(node.Content) == 0
+	}
+	return true
+}
+
+// listedNode is the node list mode shows for an entry: an alias is listed
+// as the node it refers to, unless that node contains the alias, which
+// would never finish.
+func listedNode(node *yaml.Node) *yaml.Node {
+	if node.Kind != yaml.AliasNode || node.Alias == nil || containsNode(node.Alias, node) {
+		return node
+	}
+	return node.Alias
+}
+
+// containsNode reports whether target is root or one of its descendants.
+func containsNode(root, target *yaml.Node) bool {
+	if root == target {
+		return true
+	}
+	for _, child := range root.Content {
+		if containsNode(child, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// listType is the ` (type)` suffix --types adds in list mode: the friendly
+// name of a core tag (`int`, `str`, ...), any custom tag as written, and
+// the entry count of a collection, e.g. `map[2]` or `seq[3]`.
+func listType(node *yaml.Node, opts options) string {
+	if !opts.types {
+		return ""
+	}
+	var name string
+	switch node.Kind {
+	case yaml.MappingNode:
+		name = fmt.Sprintf("map[%d]", len(node.Content)/2)
+	case yaml.SequenceNode:
+		name = fmt.Sprintf("seq[%d]", len(node.Content))
+	case yaml.AliasNode:
+		name = "alias"
+	default:
+		name = strings.TrimPrefix(node.ShortTag(), "!!")
+	}
+	return " " + paint("("+name+")", colorType, opts.color)
+}
+
+// listValue is the `: value` suffix --values adds to a leaf scalar's line in
+// list mode. It stays on one line: a multi-line value shows its first line
+// followed by an ellipsis.
+func listValue(node *yaml.Node, opts options) string {
+	if !opts.values || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	value := node.Value
+	if value == "" {
+		value = `""`
+	}
+	if first, _, multiline := strings.Cut(value, "\n"); multiline {
+		value = first + " ..."
+	}
+	return ": " + paint(value, colorValue, opts.color)
 }
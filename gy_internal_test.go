@@ -1,45 +1,21 @@
-// Unit tests for gy's internal path parsing and node manipulation.
+// Unit tests for gy's output, editing and input handling.
 // Run with: go test ./...
 
 package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/tsettle/gy/yamlpath"
 	"gopkg.in/yaml.v3"
 )
 
-func TestSplitPath(t *testing.T) {
-	cases := []struct {
-		pattern string
-		want    []string
-	}{
-		{"", nil},
-		{"a", []string{"a"}},
-		{"a.b.c", []string{"a", "b", "c"}},
-		{"a[0]", []string{"a", "[0]"}},
-		{"a[0].b", []string{"a", "[0]", "b"}},
-		{"[0]", []string{"[0]"}},
-		{"[0][1]", []string{"[0]", "[1]"}},
-		{"a..b", []string{"a", "b"}},
-		{"users[0].roles[1]", []string{"users", "[0]", "roles", "[1]"}},
-		{"special_keys.1", []string{"special_keys", "1"}},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.pattern, func(t *testing.T) {
-			got := splitPath(tc.pattern)
-			if !stringSlicesEqual(got, tc.want) {
-				t.Errorf("splitPath(%q) = %v, want %v", tc.pattern, got, tc.want)
-			}
-		})
-	}
-}
-
 func stringSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -90,175 +66,977 @@ func marshal(t *testing.T, node *yaml.Node) string {
 	return string(out)
 }
 
-func TestExtractPath(t *testing.T) {
-	root := mustParse(t, sampleYAML)
+// extractPath returns the first match of pattern in node, or nil.
+func extractPath(node *yaml.Node, pattern string) *yaml.Node {
+	if m := extractAll(node, pattern); len(m) > 0 {
+		return m[0].Node
+	}
+	return nil
+}
+
+// extractAll returns every match of pattern in node, ignoring why there
+// were none.
+func extractAll(node *yaml.Node, pattern string) []yamlpath.Match {
+	var w yamlpath.Walker
+	m, _ := w.Search(node, pattern)
+	return m
+}
+
+func TestValueMatches(t *testing.T) {
+	doc := mustParse(t, "base: &b {host: db1}\nsvc:\n  <<: *b\n  hosts: [db1, db2]\n  primary: *b\n")
+	equal := func(value string) bool { return value == "db1" }
+
+	var paths []string
+	for _, m := range valueMatches([]yamlpath.Match{{Node: doc}}, equal) {
+		paths = append(paths, yamlpath.FormatPath(m.Path))
+	}
+	// The merged and aliased copies of base.host aren't found again
+	if want := []string{"base.host", "svc.hosts[0]"}; !stringSlicesEqual(paths, want) {
+		t.Errorf("valueMatches paths = %q, want %q", paths, want)
+	}
+
+	detached := yamlpath.Match{Node: &yaml.Node{Kind: yaml.ScalarNode, Value: "db1"}, Detached: true}
+	if got := valueMatches([]yamlpath.Match{detached}, equal); len(got) != 1 {
+		t.Errorf("valueMatches(detached db1) = %d matches, want 1", len(got))
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	doc := mustParse(t, "base: &b {token: a}\nsvc:\n  <<: *b\n  token: b\n  list:\n    - {token: c, inner: {token: d}}\n")
+	var paths []string
+	for _, m := range keyMatches([]yamlpath.Match{{Node: doc}}, func(key string) bool { return key == "token" }) {
+		paths = append(paths, yamlpath.FormatPath(m.Path))
+	}
+	want := []string{"base.token", "svc.token", "svc.list[0].token", "svc.list[0].inner.token"}
+	if !stringSlicesEqual(paths, want) {
+		t.Errorf("keyMatches paths = %q, want %q", paths, want)
+	}
+}
+
+func TestParentMatches(t *testing.T) {
+	doc := mustParse(t, "a:\n  b: 1\n  c: [x, y, z]\nd: 2\n")
+	cases := map[string][]string{
+		"a.b":       {"a"},
+		"a.*":       {"a"},
+		"a.c[1]":    {"a.c"},
+		"a.c[0:2]":  {"a.c"},
+		"a.c[0,2]":  {"a.c"},
+		"a.c[*]":    {"a.c"},
+		"d":         {""},
+		"..b":       {"a"},
+		".":         nil,
+		"a.missing": nil,
+	}
+	for pattern, want := range cases {
+		var paths []string
+		for _, m := range parentMatches(doc, extractAll(doc, pattern)) {
+			paths = append(paths, yamlpath.FormatPath(m.Path))
+		}
+		if !stringSlicesEqual(paths, want) {
+			t.Errorf("parentMatches(%s) paths = %q, want %q", pattern, paths, want)
+		}
+	}
+
+	// A top-level key's parent is the root mapping itself
+	if got := parentMatches(doc, extractAll(doc, "d")); len(got) != 1 || got[0].Node != doc.Content[0] {
+		t.Errorf("parentMatches(d) = %v, want the root mapping", got)
+	}
+	detached := yamlpath.Match{Node: &yaml.Node{Kind: yaml.ScalarNode, Value: "x"}, Detached: true}
+	if got := parentMatches(doc, []yamlpath.Match{detached}); len(got) != 0 {
+		t.Errorf("parentMatches of a --default value = %v, want none", got)
+	}
+}
+
+func TestEmptyDocument(t *testing.T) {
+	for src, want := range map[string]bool{
+		"":            true,
+		"# comment\n": true,
+		"---\n":       true,
+		"--- ~\n":     false,
+		"--- \"\"\n":  false,
+		"a: 1\n":      false,
+	} {
+		docs, err := parseDocuments([]byte(src))
+		if err != nil {
+			t.Fatalf("parseDocuments(%q) failed: %v", src, err)
+		}
+		if got := emptyDocument(docs[0]); got != want {
+			t.Errorf("emptyDocument(%q) = %v, want %v", src, got, want)
+		}
+		if want && extractPath(docs[0], "a") != nil {
+			t.Errorf("extractPath(%q, a) found a node in an empty document", src)
+		}
+	}
+}
+
+func TestLeafValue(t *testing.T) {
+	for src, want := range map[string]string{
+		"plain":          "plain",
+		"'1.0'":          "'1.0'",
+		"|\n  a\n  b\n":  `"a\nb\n"`,
+		"{}":             "{}",
+		"[]":             "[]",
+		"&x 5 # comment": "5",
+	} {
+		if got := leafValue(mustParse(t, src).Content[0]); got != want {
+			t.Errorf("leafValue(%q) = %s, want %s", src, got, want)
+		}
+	}
+}
+
+func TestFlattenMatches(t *testing.T) {
+	doc := mustParse(t, "a:\n  b:\n    - c: 1 # one\n      d: [x, y]\n    - {}\n  # above\n  e: &x two\n  f: *x\n")
 
 	cases := []struct {
-		name    string
 		pattern string
-		want    string // trimmed scalar value; empty means "check kind instead"
+		want    string
 	}{
-		{"top level scalar", "app.name", "MyApp"},
-		{"nested scalar", "database.credentials.user", "admin"},
-		{"leading dot", ".app.name", "MyApp"},
-		{"array index then field", "services[0].name", "web"},
-		{"second array element", "services[1].port", "3000"},
-		{"boolean scalar", "app.debug", "false"},
-		{"numeric scalar", "database.port", "5432"},
+		{".", "a.b[0].c: 1 # one\na.b[0].d[0]: x\na.b[0].d[1]: y\na.b[1]: {}\na.e: two\na.f: two\n"},
+		{"a.b[0].d", "a.b[0].d[0]: x\na.b[0].d[1]: y\n"},
+		{"a.e", "a.e: two\n"},
+		{"..d", "a.b[0].d[0]: x\na.b[0].d[1]: y\n"},
+		{"a, a.b", "a.b[0].c: 1 # one\na.b[0].d[0]: x\na.b[0].d[1]: y\na.b[1]: {}\na.e: two\na.f: two\n"},
 	}
-
 	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			got := extractPath(root, tc.pattern)
-			if got == nil {
-				t.Fatalf("extractPath(%q) = nil, want scalar %q", tc.pattern, tc.want)
+		t.Run(tc.pattern, func(t *testing.T) {
+			var matches []yamlpath.Match
+			for _, member := range yamlpath.SplitUnion(tc.pattern) {
+				matches = append(matches, extractAll(doc, member)...)
 			}
-			if got.Value != tc.want {
-				t.Errorf("extractPath(%q) = %q, want %q", tc.pattern, got.Value, tc.want)
+			if got := marshal(t, flattenMatches(matches, false)); got != tc.want {
+				t.Errorf("flattenMatches(%s) =\n%s\nwant:\n%s", tc.pattern, got, tc.want)
 			}
 		})
 	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("GY_TEST_DIR", "configs")
 
-	t.Run("root pattern returns whole document", func(t *testing.T) {
-		got := extractPath(root, ".")
-		if got == nil || got.Kind != yaml.DocumentNode {
-			t.Fatalf("extractPath(\".\") = %v, want the document node", got)
+	cases := map[string]string{
+		"~/app.yaml":                  "/home/test/app.yaml",
+		"~":                           "/home/test",
+		"~other/app.yaml":             "~other/app.yaml",
+		"a/~/b.yaml":                  "a/~/b.yaml",
+		"$HOME/$GY_TEST_DIR/app.yaml": "/home/test/configs/app.yaml",
+		"${GY_TEST_DIR}_old/app.yaml": "configs_old/app.yaml",
+		"$GY_TEST_UNSET/app.yaml":     "$GY_TEST_UNSET/app.yaml",
+		"${GY_TEST_UNSET}/app.yaml":   "${GY_TEST_UNSET}/app.yaml",
+		"price$5.yaml":                "price$5.yaml",
+		"-":                           "-",
+	}
+	for name, want := range cases {
+		if got := expandPath(name); got != want {
+			t.Errorf("expandPath(%q) = %q, want %q", name, got, want)
 		}
+	}
+}
+
+func TestStreamDocuments(t *testing.T) {
+	input := "a: 1\n---\na: 2\n---\na: 3\n"
+	var seen []string
+	err := streamDocuments(strings.NewReader(input), func(doc *yaml.Node) bool {
+		seen = append(seen, extractPath(doc, "a").Value)
+		return len(seen) < 2
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stringSlicesEqual(seen, []string{"1", "2"}) {
+		t.Errorf("streamDocuments stopping after two gave %q", seen)
+	}
 
-	t.Run("empty pattern returns whole document", func(t *testing.T) {
-		got := extractPath(root, "")
-		if got == nil || got.Kind != yaml.DocumentNode {
-			t.Fatalf("extractPath(\"\") = %v, want the document node", got)
+	// Empty input is one empty document, as with parseDocuments
+	var docs []*yaml.Node
+	if err := streamDocuments(strings.NewReader(""), func(doc *yaml.Node) bool {
+		docs = append(docs, doc)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || !emptyDocument(docs[0]) {
+		t.Errorf("streamDocuments on empty input gave %d documents, want one empty one", len(docs))
+	}
+
+	// A syntax error past where it stopped is never read
+	broken := "a: 1\n---\na: [2\n"
+	if err := streamDocuments(strings.NewReader(broken), func(*yaml.Node) bool { return false }); err != nil {
+		t.Errorf("streamDocuments stopping at the first document: %v", err)
+	}
+	if err := streamDocuments(strings.NewReader(broken), func(*yaml.Node) bool { return true }); err == nil {
+		t.Error("streamDocuments reading a broken document: expected an error")
+	}
+}
+
+// BenchmarkDocuments compares loading a large multi-document stream whole
+// with streaming it a document at a time, reporting the peak heap in use
+// while the documents are searched as peak-heap-MB.
+func BenchmarkDocuments(b *testing.B) {
+	doc := "---\nkind: Deployment\nspec:\n  containers:\n" + strings.Repeat("    - name: web\n      image: nginx:1.25\n      ports: [80, 443]\n", 20)
+	input := []byte(strings.Repeat(doc, 2000))
+	var w yamlpath.Walker
+	var peak uint64
+	sample := func(d int) {
+		if d%100 != 0 {
+			return
+		}
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > peak {
+			peak = stats.HeapInuse
 		}
+	}
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		peak = 0
+		for i := 0; i < b.N; i++ {
+			docs, err := parseDocuments(input)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for d, doc := range docs {
+				w.Find(doc, "spec.containers[0].name")
+				sample(d)
+			}
+		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
 	})
-
-	t.Run("missing key returns nil", func(t *testing.T) {
-		if got := extractPath(root, "app.nonexistent"); got != nil {
-			t.Errorf("extractPath(missing key) = %v, want nil", got)
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		peak = 0
+		for i := 0; i < b.N; i++ {
+			d := 0
+			err := streamDocuments(bytes.NewReader(input), func(doc *yaml.Node) bool {
+				w.Find(doc, "spec.containers[0].name")
+				sample(d)
+				d++
+				return true
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
 		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
 	})
+}
+
+func TestTableRows(t *testing.T) {
+	const src = "base: &b {port: 22}\n" +
+		"hosts:\n" +
+		"  - {name: web, tags: [a, b]}\n" +
+		"  - {name: db, ip: null, <<: *b}\n" +
+		"names: [x, ~]\n" +
+		"nested: [[1]]\n" +
+		"mixed: [x, {y: 1}]\n"
+	doc := mustParse(t, src)
+
+	cases := []struct {
+		name    string
+		pattern string
+		want    [][]string
+	}{
+		{"keys are a union, in first-seen order", "hosts",
+			[][]string{{"name", "tags", "ip", "port"}, {"web", `["a","b"]`, "", ""}, {"db", "", "", "22"}}},
+		{"several matches are the rows", "hosts[*]",
+			[][]string{{"name", "tags", "ip", "port"}, {"web", `["a","b"]`, "", ""}, {"db", "", "", "22"}}},
+		{"scalars are a single column", "names", [][]string{{"value"}, {"x"}, {""}}},
+		{"a mapping is one row", "base", [][]string{{"port"}, {"22"}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			table, err := tableRows(extractAll(doc, tc.pattern), jsonOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table) != len(tc.want) {
+				t.Fatalf("tableRows(%s) = %q, want %q", tc.pattern, table, tc.want)
+			}
+			for i := range table {
+				if !stringSlicesEqual(table[i], tc.want[i]) {
+					t.Errorf("tableRows(%s) row %d = %q, want %q", tc.pattern, i, table[i], tc.want[i])
+				}
+			}
+		})
+	}
 
-	t.Run("missing nested path returns nil", func(t *testing.T) {
-		if got := extractPath(root, "database.credentials.token"); got != nil {
-			t.Errorf("extractPath(missing nested key) = %v, want nil", got)
+	for pattern, want := range map[string]string{
+		"nested": "a table needs a sequence of mappings or of scalars, but nested[0] is a sequence",
+		"mixed":  "a table needs a sequence of mappings or of scalars, not a mix of the two",
+	} {
+		if _, err := tableRows(extractAll(doc, pattern), jsonOptions{}); err == nil || err.Error() != want {
+			t.Errorf("tableRows(%s) error = %v, want %q", pattern, err, want)
 		}
-	})
+	}
+}
 
-	t.Run("array index out of bounds returns nil", func(t *testing.T) {
-		if got := extractPath(root, "services[5]"); got != nil {
-			t.Errorf("extractPath(out of bounds index) = %v, want nil", got)
+func TestKeyValueOutput(t *testing.T) {
+	doc := mustParse(t, "app:\n  env:\n    db: {host: localhost, port: 5432}\n    motd: \"hi # there\"\n    hosts: [a, b]\n    empty: null\n    \"x.y-z\": \" lead\"\n")
+	entries := flatEntries(extractAll(doc, "app.env"), false)
+
+	lines, err := dotenvLines(entries, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DB_HOST=localhost", "DB_PORT=5432", `MOTD="hi # there"`, "HOSTS_0=a", "HOSTS_1=b", "EMPTY=", `X_Y_Z=" lead"`}
+	if !stringSlicesEqual(lines, want) {
+		t.Errorf("dotenvLines =\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	lines, err = propertiesLines(entries, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"db.host=localhost", "db.port=5432", "motd=hi # there", "hosts.0=a", "hosts.1=b", "empty=", `x.y-z=\ lead`}
+	if !stringSlicesEqual(lines, want) {
+		t.Errorf("propertiesLines =\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A scalar match is named by its own key
+	if lines, _ := dotenvLines(flatEntries(extractAll(doc, "app.env.db.port"), false), ""); !stringSlicesEqual(lines, []string{"PORT=5432"}) {
+		t.Errorf("dotenvLines of a scalar = %q, want PORT=5432", lines)
+	}
+
+	// A name that would start with a digit starts with _, as in shell
+	// output, and an empty collection is an empty value in both
+	odd := mustParse(t, "e:\n  2x: {a: 1}\n  none: {}\n")
+	if lines, _ := dotenvLines(flatEntries(extractAll(odd, "e"), false), ""); !stringSlicesEqual(lines, []string{"_2X_A=1", "NONE="}) {
+		t.Errorf("dotenvLines = %q, want _2X_A=1 and NONE=", lines)
+	}
+	if lines, _ := shellLines(extractAll(odd, "e.*"), "", false); !stringSlicesEqual(lines, []string{"export _2X_A='1'", "export NONE=''"}) {
+		t.Errorf("shellLines = %q, want _2X_A and an empty NONE", lines)
+	}
+
+	// Names that collide are an error rather than a duplicate line
+	dup := mustParse(t, "a: {b: 1}\na_b: 2\nc: {D: 3}\nC: {d: 4}\n")
+	if _, err := dotenvLines(flatEntries(extractAll(dup, "."), false), ""); err == nil || err.Error() != "a.b and a_b would both be written as A_B" {
+		t.Errorf("colliding dotenv names: err = %v", err)
+	}
+	if _, err := propertiesLines(flatEntries(extractAll(dup, "."), false), ""); err == nil || err.Error() != "c.D and C.d would both be written as c.d" {
+		t.Errorf("colliding properties names: err = %v", err)
+	}
+
+	for value, want := range map[string]string{
+		"plain":   "plain",
+		"a\nb":    `"a\nb"`,
+		`say "x"`: `"say \"x\""`,
+		"$HOME":   `"\$HOME"`,
+	} {
+		if got := dotenvValue(value); got != want {
+			t.Errorf("dotenvValue(%q) = %s, want %s", value, got, want)
 		}
-	})
+	}
+	if got := propertiesEscape("a key=b:c", true); got != `a\ key\=b\:c` {
+		t.Errorf("propertiesEscape of a key = %s", got)
+	}
+}
+
+func TestShellLines(t *testing.T) {
+	doc := mustParse(t, "deploy:\n  image: nginx:1.25\n  cmd: \"x'; rm -rf / #\"\n  hosts: [a, b]\n  9lives: ~\ndup:\n  a-b: 1\n  a_b: 2\n")
+
+	lines, err := shellLines(extractAll(doc, "deploy"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"export DEPLOY_IMAGE='nginx:1.25'",
+		`export DEPLOY_CMD='x'\''; rm -rf / #'`,
+		"export DEPLOY_HOSTS_0='a'",
+		"export DEPLOY_HOSTS_1='b'",
+		"export DEPLOY_HOSTS_COUNT='2'",
+		"export DEPLOY_9LIVES=''",
+	}
+	if !stringSlicesEqual(lines, want) {
+		t.Errorf("shellLines(deploy) =\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	lines, _ = shellLines(extractAll(doc, "deploy.hosts[0]"), "", false)
+	if !stringSlicesEqual(lines, []string{"export _0='a'"}) {
+		t.Errorf("a name starting with a digit = %q, want it to start with _", lines)
+	}
+	lines, _ = shellLines(extractAll(doc, "deploy.image"), "my-app", false)
+	if !stringSlicesEqual(lines, []string{"export MY_APP_IMAGE='nginx:1.25'"}) {
+		t.Errorf("with a prefix = %q", lines)
+	}
 
-	t.Run("negative array index returns nil", func(t *testing.T) {
-		if got := extractPath(root, "services[-1]"); got != nil {
-			t.Errorf("extractPath(negative index) = %v, want nil", got)
+	// Nested sequences are counted after their own elements, and merge
+	// keys and aliases are followed as they are for every other output
+	nested := mustParse(t, "base: &b {tag: v1}\nd:\n  <<: *b\n  m: [[1, 2], [3]]\n  e: []\n  after: *b\n")
+	lines, _ = shellLines(extractAll(nested, "d"), "", false)
+	want = []string{
+		"export D_TAG='v1'",
+		"export D_M_0_0='1'",
+		"export D_M_0_1='2'",
+		"export D_M_0_COUNT='2'",
+		"export D_M_1_0='3'",
+		"export D_M_1_COUNT='1'",
+		"export D_M_COUNT='2'",
+		"export D_E=''",
+		"export D_E_COUNT='0'",
+		"export D_AFTER_TAG='v1'",
+	}
+	if !stringSlicesEqual(lines, want) {
+		t.Errorf("shellLines(d) =\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := shellLines(extractAll(doc, "dup"), "", false); err == nil || err.Error() != "dup.a-b and dup.a_b would both be exported as DUP_A_B" {
+		t.Errorf("colliding names: err = %v", err)
+	}
+}
+
+func TestSortMappingKeys(t *testing.T) {
+	doc := mustParse(t, "zeta: 1\nalpha:\n  # about m\n  m: [b, a]\n  c: {z: 1, y: 2} # flow\nmid: 3\n")
+	sortMappingKeys(doc)
+	out, err := encodeYAML([]*yaml.Node{doc}, yamlOptions{indent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "alpha:\n  c: {y: 2, z: 1} # flow\n  # about m\n  m: [b, a]\nmid: 3\nzeta: 1\n"
+	if string(out) != want {
+		t.Errorf("sorted =\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestComparePaths(t *testing.T) {
+	sorted := [][]string{
+		nil,
+		{"[2]"},
+		{"[10]"},
+		{"a"},
+		{"a", "[9]"},
+		{"a", "[10]"},
+		{"a", "b"},
+		{`"a.b"`},
+		{"b"},
+	}
+	for i := range sorted {
+		for j := range sorted {
+			got := comparePaths(sorted[i], sorted[j])
+			if i < j && got >= 0 || i > j && got <= 0 || i == j && got != 0 {
+				t.Errorf("comparePaths(%v, %v) = %d", sorted[i], sorted[j], got)
+			}
 		}
-	})
+	}
+}
+
+func TestNormalizeInput(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"clean", "a: 1\nb: 2\n", "a: 1\nb: 2\n"},
+		{"BOM", "\xef\xbb\xbfa: 1\n", "a: 1\n"},
+		{"CRLF", "a: 1\r\nb: |\r\n  x\r\n", "a: 1\nb: |\n  x\n"},
+		{"BOM and CRLF", "\xef\xbb\xbfa = 1\r\n", "a = 1\n"},
+		{"lone CR kept", "a: \"x\ry\"\n", "a: \"x\ry\"\n"},
+		{"BOM only at the start", "a: \xef\xbb\xbf\n", "a: \xef\xbb\xbf\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(normalizeInput([]byte(tt.input))); got != tt.want {
+				t.Errorf("normalizeInput(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	doc := mustParse(t, "packages:\n  - name: gy\n    tags: [cli, yaml]\n    notes: \"one\\ntwo\"\n  - name: yq\n    tags: []\n    notes: null\n")
+	matches := extractAll(doc, "packages[*]")
+	tests := []struct {
+		template, want string
+	}{
+		{"{{ .name }}", "gy\nyq\n"},
+		{"{{ .name }}\n", "gy\nyq\n"},
+		{"{{ .name | upper }} {{ \"A\" | lower }}", "GY a\nYQ a\n"},
+		{"{{ .tags | join \",\" }}", "cli,yaml\n\n"},
+		{"{{ .tags | join \",\" | default \"none\" }}", "cli,yaml\nnone\n"},
+		{"{{ .notes | default \"-\" }}|{{ .missing | default 0 }}", "one\ntwo|0\n-|0\n"},
+		{"{{ .notes | indent 2 }}", "  one\n  two\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := parseTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := renderTemplate(tmpl, matches)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	tmpl, err := parseTemplate("{{ .name.first }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = renderTemplate(tmpl, matches)
+	if err == nil || !strings.HasPrefix(err.Error(), "--template failed on packages[0]: ") {
+		t.Errorf("error = %v, want one naming packages[0]", err)
+	}
+}
+
+func TestDiffNodes(t *testing.T) {
+	a := mustParse(t, "base: &base {x: 1}\nname: web\nport: 80\ndebug: true\ntags: [a, b]\nenv: {A: 1}\nnote:\nmerged: {<<: *base, y: 2}\n")
+	b := mustParse(t, "merged: {x: 1, y: 3}\nnote: ~\nenv: [A]\ntags: [a, b, c]\nport: \"80\"\nname: web\nbase: {x: 1}\nadded: {z: [1]}\n")
+	var got []string
+	for _, d := range diffNodes(a, b, false) {
+		line := fmt.Sprintf("%c %s", d.op, yamlpath.FormatPath(d.path))
+		if d.from != nil {
+			line += " " + leafValue(diffTarget(d.from))
+		}
+		if d.to != nil {
+			line += " " + leafValue(diffTarget(d.to))
+		}
+		got = append(got, line)
+	}
+	// Key order doesn't matter, a merge key is its merged keys, and a
+	// null is the same however it's written
+	want := []string{
+		`~ port 80 "80"`,
+		"- debug true",
+		"+ tags[2] c",
+		"~ env {A: 1} [A]",
+		"~ merged.y 2 3",
+		"+ added {z: [1]}",
+	}
+	if !stringSlicesEqual(got, want) {
+		t.Errorf("diffNodes =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if diffs := diffNodes(a, a, false); len(diffs) != 0 {
+		t.Errorf("diffNodes(a, a) = %v, want no differences", diffs)
+	}
+}
+
+func TestFlushSequences(t *testing.T) {
+	const input = "a:\n  - x\n  - y: &list\n      # first\n      - 1\n    lit: |\n      k:\n        - text\n  - - nested\nb:\n  c: !custom\n    - d\n  e: [f]\n"
+	doc := mustParse(t, input)
+	for indent := 2; indent <= 9; indent++ {
+		encoded, err := encodeYAML([]*yaml.Node{doc}, yamlOptions{indent: indent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		flushed := flushSequences(encoded)
+		if indent == 2 {
+			want := "a:\n- x\n- y: &list\n  # first\n  - 1\n  lit: |\n    k:\n      - text\n- - nested\nb:\n  c: !custom\n  - d\n  e: [f]\n"
+			if string(flushed) != want {
+				t.Errorf("flushed =\n%s\nwant:\n%s", flushed, want)
+			}
+		}
+		// Moving the lines never changes what they say
+		var got, want interface{}
+		if err := yaml.Unmarshal(flushed, &got); err != nil {
+			t.Fatalf("indent %d: %v in\n%s", indent, err, flushed)
+		}
+		if err := doc.Decode(&want); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("indent %d: flushed reads as %v, want %v", indent, got, want)
+		}
+	}
+}
+
+func TestFlushSequencesText(t *testing.T) {
+	// A block scalar's "- " lines are text, not items, and a flow
+	// sequence stays where it is
+	const input = "script: |\n    - not an item\n    - nor this\nsteps:\n    - run: a\n      with: [x, y]\n    - note: >\n        - folded\ntags: [a, b]\n"
+	const want = "script: |\n    - not an item\n    - nor this\nsteps:\n- run: a\n  with: [x, y]\n- note: >\n    - folded\ntags: [a, b]\n"
+	if got := string(flushSequences([]byte(input))); got != want {
+		t.Errorf("flushSequences =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeYAMLOptions(t *testing.T) {
+	const src = "b:\n  - 1\na:\n  d: x\n  c: y\n"
+	for _, tc := range []struct {
+		opts yamlOptions
+		want string
+	}{
+		{yamlOptions{indent: 2}, src},
+		{yamlOptions{indent: 4}, "b:\n    - 1\na:\n    d: x\n    c: y\n"},
+		{yamlOptions{indent: 2, sortKeys: true}, "a:\n  c: y\n  d: x\nb:\n  - 1\n"},
+		{yamlOptions{indent: 2, flushSeqs: true}, "b:\n- 1\na:\n  d: x\n  c: y\n"},
+	} {
+		out, err := encodeYAML([]*yaml.Node{mustParse(t, src)}, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.want {
+			t.Errorf("encodeYAML with %+v =\n%s\nwant:\n%s", tc.opts, out, tc.want)
+		}
+	}
+}
+
+func TestPrintNodeLeavesDocument(t *testing.T) {
+	// Restyling one pattern's result mustn't change what the next one
+	// finds in the same document
+	const src = "# head\nweb:\n  name: web # n\n  ports: [80]\n  note: |\n    a\n    b\n"
+	doc := mustParse(t, src)
+	for _, opts := range []options{
+		{flow: true, yamlIndent: 2},
+		{block: true, noComments: true, yamlIndent: 2},
+		{scalarStyle: "double", yamlIndent: 2},
+	} {
+		captureStdout(t, func() { printNode(doc, opts) })
+	}
+	out, err := encodeYAML([]*yaml.Node{doc}, yamlOptions{indent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != src {
+		t.Errorf("document after printing =\n%s\nwant it unchanged:\n%s", out, src)
+	}
+}
+
+func TestSplitAssignment(t *testing.T) {
+	cases := []struct {
+		expr, pattern, value string
+		ok                   bool
+	}{
+		{"image.tag=v2.0", "image.tag", "v2.0", true},
+		{"a=b=c", "a", "b=c", true},
+		{"users[name=bob].email=b@x", "users[name=bob].email", "b@x", true},
+		{`labels."a=b"=c`, `labels."a=b"`, "c", true},
+		{`a\=b=c`, `a\=b`, "c", true},
+		{"a=", "a", "", true},
+		{"=x", "", "", false},
+		{"image.tag", "", "", false},
+	}
+	for _, tc := range cases {
+		pattern, value, ok := splitAssignment(tc.expr)
+		if pattern != tc.pattern || value != tc.value || ok != tc.ok {
+			t.Errorf("splitAssignment(%q) = %q, %q, %v, want %q, %q, %v", tc.expr, pattern, value, ok, tc.pattern, tc.value, tc.ok)
+		}
+	}
+}
 
-	t.Run("indexing into a mapping returns nil", func(t *testing.T) {
-		if got := extractPath(root, "app[0]"); got != nil {
-			t.Errorf("extractPath(index into mapping) = %v, want nil", got)
+func TestSetScalar(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		value string
+		want  string
+	}{
+		{"plain string to int", "v: latest\n", "3", "v: 3\n"},
+		{"plain int to string", "v: 3\n", "auto", "v: auto\n"},
+		{"plain string to bool", "v: x\n", "true", "v: true\n"},
+		{"double-quoted stays a string", "v: \"1.0\"\n", "2", "v: \"2\"\n"},
+		{"single-quoted stays a string", "v: 'a'\n", "b", "v: 'b'\n"},
+		{"explicit tag is kept", "v: !!str 1\n", "2", "v: !!str 2\n"},
+		{"custom tag is kept", "v: !Ref x\n", "y", "v: !Ref y\n"},
+	}
+	plainCases := []struct {
+		name  string
+		input string
+		value string
+		want  string
+	}{
+		{"plain drops single quotes", "v: 'abc'\n", "3", "v: 3\n"},
+		{"plain drops double quotes", "v: \"1.0\"\n", "2", "v: 2\n"},
+		{"plain drops an explicit tag", "v: !!str 1\n", "true", "v: true\n"},
+		{"plain still quotes what needs it", "v: x\n", "a: b", "v: 'a: b'\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := mustParse(t, tc.input)
+			if err := setScalar(extractPath(doc, "v"), tc.value, false); err != nil {
+				t.Fatalf("setScalar: %v", err)
+			}
+			if got := marshal(t, doc); got != tc.want {
+				t.Errorf("after setScalar(%q) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+	for _, tc := range plainCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := mustParse(t, tc.input)
+			if err := setScalar(extractPath(doc, "v"), tc.value, true); err != nil {
+				t.Fatalf("setScalar: %v", err)
+			}
+			if got := marshal(t, doc); got != tc.want {
+				t.Errorf("after setScalar(%q, plain) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("non-scalar is an error", func(t *testing.T) {
+		doc := mustParse(t, "v: [1]\n")
+		if err := setScalar(extractPath(doc, "v"), "x", false); err == nil {
+			t.Error("setScalar on a sequence = nil, want an error")
 		}
 	})
+}
 
-	t.Run("field access into a sequence returns nil", func(t *testing.T) {
-		if got := extractPath(root, "services.name"); got != nil {
-			t.Errorf("extractPath(field into sequence) = %v, want nil", got)
+func TestCreatePath(t *testing.T) {
+	t.Run("builds missing maps and replaces nulls", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  keep: 1\nspec: ~\n")
+		for _, pattern := range []string{"a.b.c", "spec.x", `"d.e"`} {
+			node, err := createPath(doc, yamlpath.SplitPath(pattern))
+			if err != nil {
+				t.Fatalf("createPath(%s): %v", pattern, err)
+			}
+			if err := setScalar(node, "v", false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := "a:\n    keep: 1\n    b:\n        c: v\nspec:\n    x: v\nd.e: v\n"
+		if got := marshal(t, doc); got != want {
+			t.Errorf("createPath result = %q, want %q", got, want)
 		}
 	})
 
-	t.Run("nested array element", func(t *testing.T) {
-		nested := mustParse(t, "matrix:\n  - [1, 2]\n  - [3, 4]\n")
-		got := extractPath(nested, "matrix[1][0]")
-		if got == nil || got.Value != "3" {
-			t.Errorf("extractPath(matrix[1][0]) = %v, want 3", got)
+	t.Run("empty document", func(t *testing.T) {
+		doc := &yaml.Node{}
+		node, err := createPath(doc, yamlpath.SplitPath("a.b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setScalar(node, "1", false); err != nil {
+			t.Fatal(err)
+		}
+		if got := marshal(t, doc); got != "a:\n    b: 1\n" {
+			t.Errorf("createPath on empty doc = %q", got)
 		}
 	})
 
-	t.Run("numeric-looking string keys are matched as strings", func(t *testing.T) {
-		typesDoc := mustParse(t, "special_keys:\n  1: \"numeric key\"\n  \"key.with.dots\": value\n")
-		got := extractPath(typesDoc, "special_keys.1")
-		if got == nil || got.Value != "numeric key" {
-			t.Errorf("extractPath(special_keys.1) = %v, want %q", got, "numeric key")
+	errCases := []struct {
+		pattern string
+		want    string
+	}{
+		{"n.x", "cannot create n.x: n is a scalar"},
+		{"list[5]", "cannot create list[5]: index out of range (length 1)"},
+		{"list.x", "cannot create list.x: list is a sequence"},
+		{"*.x", "cannot create *: wildcards can't be created"},
+		{"a.^", "cannot create a.^: ^ can't be created"},
+		{"&x.a", "cannot create &x: &x can't be created"},
+		{"env_*", "cannot create env_*: wildcards can't be created"},
+		{"[0]", "cannot create [0]: the document root is a mapping"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			doc := mustParse(t, "n: 1\nlist: [a]\n")
+			_, err := createPath(doc, yamlpath.SplitPath(tc.pattern))
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("createPath(%s) error = %v, want %q", tc.pattern, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParentOf(t *testing.T) {
+	doc := mustParse(t, "a:\n  b: 1\n  c: 2\nlist: [x, y, z]\n")
+	parentOf := func(pattern string) (*yaml.Node, int) {
+		matches := extractAll(doc, pattern)
+		if len(matches) == 0 {
+			t.Fatalf("%s matched nothing", pattern)
+		}
+		return parentOf(doc, matches[0])
+	}
+
+	if parent, index := parentOf("a.c"); parent != extractPath(doc, "a") || index != 2 {
+		t.Errorf("parentOf(a.c) = %v at %d, want a at key index 2", parent, index)
+	}
+	if parent, index := parentOf("list[-1]"); parent != extractPath(doc, "list") || index != 2 {
+		t.Errorf("parentOf(list[-1]) = %v at %d, want list at 2", parent, index)
+	}
+	if parent, index := parentOf("list"); parent != doc.Content[0] || index != 2 {
+		t.Errorf("parentOf(list) = %v at %d, want the root mapping at 2", parent, index)
+	}
+	if parent, index := parentOf("."); parent != nil || index != -1 {
+		t.Errorf("parentOf(.) = %v at %d, want nil for the root", parent, index)
+	}
+	// A slice builds a sequence of its own, which is in nothing
+	if parent, index := parentOf("list[0:2]"); parent != nil || index != -1 {
+		t.Errorf("parentOf(list[0:2]) = %v at %d, want nil", parent, index)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	const input = "a:\n  b: 1\n  c: 2\nlist: [x, y, z, w]\nusers:\n  - name: p\n    tmp: 1\n  - name: q\n    tmp: 2\n"
+
+	cases := []struct {
+		pattern string
+		deleted int
+		want    string
+	}{
+		{"a.b", 1, "a:\n    c: 2\nlist: [x, y, z, w]\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+		{"list[1]", 1, "a:\n    b: 1\n    c: 2\nlist: [x, z, w]\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+		{"list[0,2]", 2, "a:\n    b: 1\n    c: 2\nlist: [y, w]\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+		{"list[1:]", 3, "a:\n    b: 1\n    c: 2\nlist: [x]\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+		{"list[*]", 4, "a:\n    b: 1\n    c: 2\nlist: []\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+		{"users[*].tmp", 2, "a:\n    b: 1\n    c: 2\nlist: [x, y, z, w]\nusers:\n    - name: p\n    - name: q\n"},
+		{"nope", 0, "a:\n    b: 1\n    c: 2\nlist: [x, y, z, w]\nusers:\n    - name: p\n      tmp: 1\n    - name: q\n      tmp: 2\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			doc := mustParse(t, input)
+			deleted, err := deleteAll(doc, tc.pattern)
+			if err != nil {
+				t.Fatalf("deleteAll(%s): %v", tc.pattern, err)
+			}
+			if deleted != tc.deleted {
+				t.Errorf("deleteAll(%s) deleted %d, want %d", tc.pattern, deleted, tc.deleted)
+			}
+			if got := marshal(t, doc); got != tc.want {
+				t.Errorf("after deleteAll(%s) =\n%s\nwant:\n%s", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("root cannot be deleted", func(t *testing.T) {
+		if _, err := deleteAll(mustParse(t, input), "."); err == nil {
+			t.Error("deleteAll(.) = nil error, want an error")
 		}
 	})
 }
 
-func TestWrapInPath(t *testing.T) {
-	root := mustParse(t, sampleYAML)
+func TestDeepMerge(t *testing.T) {
+	const base = "spec:\n  replicas: 2 # by hand\n  selector:\n    app: web\n  ports: [80]\n"
+	const patch = "replicas: 3\nselector:\n  tier: front\nports: [443]\nextra: x\n"
 
-	t.Run("wraps scalar back in its mapping ancestry", func(t *testing.T) {
-		extracted := extractPath(root, "app.name")
-		wrapped := wrapInPath(root, "app.name", extracted)
-		got := marshal(t, wrapped)
-		want := "app:\n    name: MyApp\n"
-		if got != want {
-			t.Errorf("wrapInPath(app.name) =\n%s\nwant:\n%s", got, want)
+	cases := []struct {
+		name       string
+		appendSeqs bool
+		want       string
+	}{
+		{"replace", false, "spec:\n    replicas: 3 # by hand\n    selector:\n        app: web\n        tier: front\n    ports: [443]\n    extra: x\n"},
+		{"append", true, "spec:\n    replicas: 3 # by hand\n    selector:\n        app: web\n        tier: front\n    ports: [80, 443]\n    extra: x\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := mustParse(t, base)
+			src := mustParse(t, patch).Content[0]
+			deepMerge(extractPath(doc, "spec"), src, tc.appendSeqs)
+			if got := marshal(t, doc); got != tc.want {
+				t.Errorf("after deepMerge =\n%s\nwant:\n%s", got, tc.want)
+			}
+			if got := marshal(t, src); got != "replicas: 3\nselector:\n    tier: front\nports: [443]\nextra: x\n" {
+				t.Errorf("deepMerge changed the patch to\n%s", got)
+			}
+		})
+	}
+
+	t.Run("an alias is merged as a copy", func(t *testing.T) {
+		doc := mustParse(t, "base: &b\n  a: 1\nprod: *b\n")
+		deepMerge(editMatches(doc, "prod")[0].Node, mustParse(t, "b: 2\n").Content[0], false)
+		want := "base: &b\n    a: 1\nprod:\n    a: 1\n    b: 2\n"
+		if got := marshal(t, doc); got != want {
+			t.Errorf("after deepMerge =\n%s\nwant:\n%s", got, want)
 		}
 	})
+}
 
-	t.Run("array index reconstruction drops the index, keeps only the match", func(t *testing.T) {
-		extracted := extractPath(root, "services[1].name")
-		wrapped := wrapInPath(root, "services[1].name", extracted)
-		got := marshal(t, wrapped)
-		// services[1] is "api", but wrapInPath doesn't reconstruct services[0]
-		// (unknown value) - it emits a single-element array with just the
-		// match rather than fabricating a `null` placeholder for the skipped
-		// index, which would misrepresent the source document.
-		want := "services:\n    - name: api\n"
+func TestCountMatches(t *testing.T) {
+	doc := mustParse(t, "items: [a, b, c]\nmeta: {x: 1, y: 2}\nname: web\nnothing:\nsvc:\n  a: {image: x}\n  b: {image: y}\n")
+	cases := map[string]int{
+		"items":       1,
+		"meta":        1,
+		"name":        1,
+		"nothing":     1,
+		"missing":     0,
+		"svc.a":       1,
+		"svc.*":       2,
+		"svc.*.image": 2,
+		"..image":     2,
+		"items[*]":    3,
+		"items[0:2]":  2,
+		"items[0,2]":  2,
+		"items[::-1]": 3,
+	}
+	for pattern, want := range cases {
+		r := result{doc: doc, pattern: pattern, matches: extractAll(doc, pattern)}
+		if got := countMatches(r); got != want {
+			t.Errorf("countMatches(%q) = %d, want %d", pattern, got, want)
+		}
+	}
+}
+
+func TestTaggedNodes(t *testing.T) {
+	doc := mustParse(t, "db:\n  password: !vault abc\n  created: 2024-01-02\napi:\n  token: !vault xyz\n  keys: [!vault k1, plain]\nwhen: \"2023-05-06\"\n")
+
+	cases := []struct {
+		pattern, tag string
+		paths        []string
+	}{
+		{".", "!vault", []string{"db.password", "api.token", "api.keys[0]"}},
+		{"api", "!vault", []string{"api.token", "api.keys[0]"}},
+		{".", "!!timestamp", []string{"db.created"}},
+		{".", "tag:yaml.org,2002:timestamp", []string{"db.created"}},
+		{"db", "!!map", []string{"db"}},
+		{".", "!nope", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern+" "+tc.tag, func(t *testing.T) {
+			var paths []string
+			for _, m := range taggedNodes(extractAll(doc, tc.pattern), tc.tag) {
+				paths = append(paths, yamlpath.FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("taggedNodes(%s, %s) paths = %q, want %q", tc.pattern, tc.tag, paths, tc.paths)
+			}
+		})
+	}
+}
+
+func TestComments(t *testing.T) {
+	const input = `# top
+db:
+  # the host
+  host: localhost # inline
+  # port comment
+  port: 5432
+`
+
+	t.Run("wrapping keeps head comments on keys and line comments on scalars", func(t *testing.T) {
+		doc := mustParse(t, input)
+		got := marshal(t, yamlpath.Wrap(doc, extractAll(doc, "db.host")))
+		want := "# top\ndb:\n    # the host\n    host: localhost # inline\n"
 		if got != want {
-			t.Errorf("wrapInPath(services[1].name) =\n%s\nwant:\n%s", got, want)
+			t.Errorf("wrapped db.host =\n%s\nwant:\n%s", got, want)
 		}
 	})
 
-	t.Run("unparseable index falls back to the extracted node", func(t *testing.T) {
-		extracted := extractPath(root, "app.name")
-		wrapped := wrapInPath(root, "app[bad].name", extracted)
-		if wrapped != extracted {
-			t.Errorf("wrapInPath with unparseable index should return the extracted node unchanged")
+	t.Run("a rebuilt element keeps the comments on it", func(t *testing.T) {
+		doc := mustParse(t, "items:\n  # first\n  - name: a\n    size: 1\n  # second\n  - name: b\n  - [x, y] # pair\n")
+		got := marshal(t, yamlpath.Wrap(doc, append(extractAll(doc, "items[*].name"), extractAll(doc, "items[2][0]")...)))
+		want := "items:\n    # first\n    - name: a\n    # second\n    - name: b\n    - [x] # pair\n"
+		if got != want {
+			t.Errorf("wrapped names =\n%s\nwant:\n%s", got, want)
 		}
 	})
 
-	t.Run("reconstructed ancestors inherit the source's flow style", func(t *testing.T) {
-		// JSON is valid YAML flow syntax, and yaml.v3 records that per-node
-		// (Node.Style). wrapInPath used to always fabricate block-style
-		// wrapper nodes, so extracting from a JSON source reverted to block
-		// YAML. It should now look up the original node at each ancestor
-		// path and match its style, so JSON in stays JSON-shaped out.
-		jsonRoot := mustParse(t, `{"database": {"host": "localhost", "tags": ["a", "b"]}}`)
-
-		extracted := extractPath(jsonRoot, "database.host")
-		wrapped := wrapInPath(jsonRoot, "database.host", extracted)
-		got := marshal(t, wrapped)
-		want := "{\"database\": {\"host\": \"localhost\"}}\n"
+	t.Run("a merged key keeps its comments", func(t *testing.T) {
+		doc := mustParse(t, "base: &b\n  # the host\n  host: db\ndev:\n  <<: *b\n")
+		got := marshal(t, yamlpath.Wrap(doc, extractAll(doc, "dev.host")))
+		want := "dev:\n    # the host\n    host: db\n"
 		if got != want {
-			t.Errorf("wrapInPath(database.host) on JSON source =\n%q\nwant:\n%q", got, want)
+			t.Errorf("wrapped dev.host =\n%s\nwant:\n%s", got, want)
 		}
 	})
 
-	t.Run("reconstructed array ancestor inherits flow style", func(t *testing.T) {
-		jsonRoot := mustParse(t, `{"database": {"tags": ["a", "b"]}}`)
-
-		extracted := extractPath(jsonRoot, "database.tags[1]")
-		wrapped := wrapInPath(jsonRoot, "database.tags[1]", extracted)
-		got := marshal(t, wrapped)
-		want := "{\"database\": {\"tags\": [\"b\"]}}\n"
+	t.Run("trimming carries the key's head comment", func(t *testing.T) {
+		doc := mustParse(t, input)
+		got := marshal(t, withKeyComments(doc, extractAll(doc, "db.host")[0]))
+		want := "# the host\nlocalhost # inline\n"
 		if got != want {
-			t.Errorf("wrapInPath(database.tags[1]) on JSON source =\n%q\nwant:\n%q", got, want)
+			t.Errorf("trimmed db.host = %q, want %q", got, want)
+		}
+		if extractPath(doc, "db.host").HeadComment != "" {
+			t.Error("withKeyComments modified the source node")
 		}
 	})
 
-	t.Run("block-style source still reconstructs as block style", func(t *testing.T) {
-		// Regression check: the style-inheritance lookup shouldn't change
-		// behavior for ordinary block YAML, which is the common case.
-		extracted := extractPath(root, "app.name")
-		wrapped := wrapInPath(root, "app.name", extracted)
-		got := marshal(t, wrapped)
-		want := "app:\n    name: MyApp\n"
-		if got != want {
-			t.Errorf("wrapInPath(app.name) on block source =\n%q\nwant:\n%q", got, want)
+	t.Run("stripComments clears all three kinds", func(t *testing.T) {
+		doc := mustParse(t, input)
+		stripComments(doc)
+		want := "db:\n    host: localhost\n    port: 5432\n"
+		if got := marshal(t, doc); got != want {
+			t.Errorf("after stripComments = %q, want %q", got, want)
 		}
 	})
 }
@@ -316,6 +1094,281 @@ func TestForceStyle(t *testing.T) {
 	})
 }
 
+func TestJSONValue(t *testing.T) {
+	defer func(w io.Writer) { jsonWarnings = w }(jsonWarnings)
+	jsonWarnings = io.Discard
+
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"mapping keeps key order", "b: 1\na: 2\n", `{"b":1,"a":2}`},
+		{"ints and floats become numbers", "[42, -7, 0x1F, 0o17, 1_000, 3.5, 1.0, 1.23e-4]", `[42,-7,31,15,1000,3.5,1.0,1.23e-4]`},
+		{"booleans and nulls", "[true, false, null, ~]", `[true,false,null,null]`},
+		{"quoted numbers stay strings", `["42", "true", "null"]`, `["42","true","null"]`},
+		{"non-finite floats fall back to strings", "[.inf, .nan]", `[".inf",".nan"]`},
+		{"timestamps keep their source text", "[2025-10-27]", `["2025-10-27"]`},
+		{"custom tags keep their text", "!vault secret", `"secret"`},
+		{"aliases are resolved", "a: &x {k: 1}\nb: *x\n", `{"a":{"k":1},"b":{"k":1}}`},
+		{"merge keys are resolved, local keys winning", "a: &x {k: 1, n: 2}\nb:\n  <<: *x\n  n: 3\n", `{"a":{"k":1,"n":2},"b":{"k":1,"n":3}}`},
+		{"several merges, the first winning", "a: &x {k: 1}\nb: &y {k: 2, m: 2}\nc: {<<: [*x, *y]}\n", `{"a":{"k":1},"b":{"k":2,"m":2},"c":{"k":1,"m":2}}`},
+		{"non-string keys are stringified", "1: one\ntrue: yes\n", `{"1":"one","true":"yes"}`},
+		{"collection keys become their JSON", "[1, 2]: pair\n? {k: v}\n: m\n", `{"[1,2]":"pair","{\"k\":\"v\"}":"m"}`},
+		{"no HTML escaping", "a<b: x&y\n", `{"a<b":"x&y"}`},
+		{"empty document is null", "", `null`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := writeJSON(jsonValue(mustParse(t, tc.src), jsonOptions{}), 0)
+			if err != nil {
+				t.Fatalf("writeJSON failed: %v", err)
+			}
+			if got := strings.TrimSpace(string(out)); got != tc.want {
+				t.Errorf("jsonValue(%q) = %s, want %s", tc.src, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("noMerge keeps merge keys as written", func(t *testing.T) {
+		out, err := writeJSON(jsonValue(mustParse(t, "a: &x {k: 1}\nb: {<<: *x, n: 3}\n"), jsonOptions{noMerge: true}), 0)
+		if err != nil {
+			t.Fatalf("writeJSON failed: %v", err)
+		}
+		if got, want := strings.TrimSpace(string(out)), `{"a":{"k":1},"b":{"<<":{"k":1},"n":3}}`; got != want {
+			t.Errorf("jsonValue with noMerge = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("an alias inside its own anchor is null where it repeats", func(t *testing.T) {
+		out, err := writeJSON(jsonValue(mustParse(t, "a: &x\n  b: *x\n  c: 1\n"), jsonOptions{}), 0)
+		if err != nil {
+			t.Fatalf("writeJSON failed: %v", err)
+		}
+		if got, want := strings.TrimSpace(string(out)), `{"a":{"b":{"b":null,"c":1},"c":1}}`; got != want {
+			t.Errorf("jsonValue of a recursive alias = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("sortKeys orders every object", func(t *testing.T) {
+		out, err := writeJSON(jsonValue(mustParse(t, "b: {d: 1, c: 2}\na: 3\n"), jsonOptions{sortKeys: true}), 0)
+		if err != nil {
+			t.Fatalf("writeJSON failed: %v", err)
+		}
+		if got, want := strings.TrimSpace(string(out)), `{"a":3,"b":{"c":2,"d":1}}`; got != want {
+			t.Errorf("jsonValue with sortKeys = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("indent pretty-prints nested values", func(t *testing.T) {
+		out, err := writeJSON(jsonValue(mustParse(t, "a: {b: [1]}\n"), jsonOptions{}), 2)
+		if err != nil {
+			t.Fatalf("writeJSON failed: %v", err)
+		}
+		want := "{\n  \"a\": {\n    \"b\": [\n      1\n    ]\n  }\n}\n"
+		if string(out) != want {
+			t.Errorf("writeJSON(indent=2) =\n%s\nwant:\n%s", out, want)
+		}
+	})
+
+	t.Run("binary and custom tags are warned about once each", func(t *testing.T) {
+		var warnings bytes.Buffer
+		jsonWarnings, warnedTags = &warnings, map[string]bool{}
+		jsonValue(mustParse(t, "[!vault a, !vault b, !!binary aGk=, !!timestamp 2025-10-27, !!str s]\n"), jsonOptions{})
+		want := "Warning: !vault has no JSON type; writing !vault values as strings\n" +
+			"Warning: !!binary has no JSON type; writing !!binary values as their base64 text\n"
+		if warnings.String() != want {
+			t.Errorf("warnings = %q, want %q", warnings.String(), want)
+		}
+	})
+}
+
+func TestParseTOML(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"keys and tables", "a = 1\n[t]\nb = \"x\"\n[t.u]\nc = true\n", `{"a":1,"t":{"b":"x","u":{"c":true}}}`},
+		{"dotted and quoted keys", "a.b = 1\n\"x.y\" = 2\n'z' = 3\n", `{"a":{"b":1},"x.y":2,"z":3}`},
+		{"arrays of tables", "[[s]]\nn = 1\n[[s]]\nn = 2\n[s.meta]\nm = 3\n", `{"s":[{"n":1},{"n":2,"meta":{"m":3}}]}`},
+		{"inline values", "a = [1, [2, 3],\n  # comment\n]\nb = { c = 1, d.e = 2 }\n", `{"a":[1,[2,3]],"b":{"c":1,"d":{"e":2}}}`},
+		{"numbers are normalised", "a = [0xff, 0o17, 0b11, 1_000, -7, 1e3, 6.5_5]\n", `{"a":[255,15,3,1000,-7,1e3,6.55]}`},
+		{"special floats", "a = [inf, -inf, nan]\n", `{"a":[".inf","-.inf",".nan"]}`},
+		{"string escapes", `a = "tab\tquote\"\u00e9"` + "\nb = 'C:\\path'\n", `{"a":"tab\tquote\"é","b":"C:\\path"}`},
+		{"multi-line strings", "a = \"\"\"\none\ntwo\"\"\"\nb = \"\"\"x \\\n   y\"\"\"\nc = '''\nraw\\n'''\n", `{"a":"one\ntwo","b":"x y","c":"raw\\n"}`},
+		{"dates and times", "d = 1979-05-27\nt = 1979-05-27 07:32:00Z\nl = 07:32:00\n", `{"d":"1979-05-27","t":"1979-05-27 07:32:00Z","l":"07:32:00"}`},
+		{"empty input is an empty table", "# nothing\n", `{}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, err := parseTOML([]byte(tc.src))
+			if err != nil {
+				t.Fatalf("parseTOML(%q) failed: %v", tc.src, err)
+			}
+			out, err := writeJSON(jsonValue(docs[0], jsonOptions{}), 0)
+			if err != nil {
+				t.Fatalf("writeJSON failed: %v", err)
+			}
+			if got := strings.TrimSpace(string(out)); got != tc.want {
+				t.Errorf("parseTOML(%q) = %s, want %s", tc.src, got, tc.want)
+			}
+		})
+	}
+
+	errCases := []struct {
+		src  string
+		want string
+	}{
+		{"a = 1\na = 2\n", "line 2: key a is defined more than once"},
+		{"[t]\n[t]\n", "line 2: table [t] is defined more than once"},
+		{"a = 1\n[a]\n", "line 2: [a] is already defined as a value"},
+		{"a = {b = 1}\na.c = 2\n", "line 2: a is already defined as a value"},
+		{"a = \"open\n", "line 1: unterminated string"},
+		{"a = 1 b = 2\n", "line 1: expected the end of the line, found 'b'"},
+		{"a = 012\n", `line 1: invalid value "012"`},
+		{"a\n", "line 1: expected = after key a"},
+	}
+	for _, tc := range errCases {
+		if _, err := parseTOML([]byte(tc.src)); err == nil || err.Error() != tc.want {
+			t.Errorf("parseTOML(%q) error = %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	cases := []struct {
+		name     string
+		src      string
+		filename string
+		format   string
+		want     string
+	}{
+		{"a .toml file is TOML", "a = 1\n", "x.toml", "auto", "toml"},
+		{"a .json file is JSON", `{"a": 1}`, "x.json", "auto", "json"},
+		{"YAML without an extension", "a: 1\n", "", "auto", "yaml"},
+		{"JSON without an extension parses as YAML", `{"a": 1}`, "", "auto", "yaml"},
+		{"a key = value line is TOML", "# settings\na = 1\n", "", "auto", "toml"},
+		{"a table header that isn't YAML is TOML", "[server]\nport = 80\n", "", "auto", "toml"},
+		{"an explicit format wins over the extension", "a: 1\n", "x.toml", "yaml", "yaml"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, format, err := parseInput([]byte(tc.src), tc.filename, tc.format)
+			if err != nil {
+				t.Fatalf("parseInput failed: %v", err)
+			}
+			if format != tc.want {
+				t.Errorf("parseInput format = %q, want %q", format, tc.want)
+			}
+		})
+	}
+}
+
+func TestJSONPathToPattern(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"$", "."},
+		{"$.a.b", "a.b"},
+		{"{.a.b}", "a.b"},
+		{".a", "a"},
+		{"$['a']['b.c']", `a."b.c"`},
+		{`$["it's"]`, `"it's"`},
+		{"$.a[*].b", "a[*].b"},
+		{"$.*", "*"},
+		{"$..name", "..name"},
+		{"$.a..b[0]", "a..b[0]"},
+		{"$..[*]", "..[*]"},
+		{"$.a[-1]", "a[-1]"},
+		{"$.a[1:3]", "a[1:3]"},
+		{"$.a[0, 2]", "a[0,2]"},
+		{"$.a[::-1]", "a[::-1]"},
+		{"$.a[?(@.name == 'web')].port", "a[?(@.name == 'web')].port"},
+		{"$.a[?(@.tls)]", "a[?(@.tls)]"},
+		{"$.a//b", `"a//b"`},
+	}
+	for _, tc := range cases {
+		got, err := jsonPathToPattern(tc.expr)
+		if err != nil {
+			t.Errorf("jsonPathToPattern(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("jsonPathToPattern(%q) = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"$.a[?(@.n < 3)]",
+		"$.a[?(@.n == 1 && @.m == 2)]",
+		"$.a[?(@.n == $.m)]",
+		"$.a[?(!@.n)]",
+		"$.a[(@.length-1)]",
+		"$['a','b']",
+		"$.a.length()",
+		"$..",
+		"$.a.",
+		"@.a",
+		"{range .items[*]}{.name}{end}",
+		"a.b",
+		"$.a[0",
+	} {
+		if got, err := jsonPathToPattern(expr); err == nil {
+			t.Errorf("jsonPathToPattern(%q) = %q, want an error", expr, got)
+		}
+	}
+}
+
+func TestHighlightYAML(t *testing.T) {
+	key := func(s string) string { return colorKey + s + colorReset }
+	value := func(s string) string { return colorValue + s + colorReset }
+	number := func(s string) string { return colorNumber + s + colorReset }
+	boolean := func(s string) string { return colorBool + s + colorReset }
+	anchor := func(s string) string { return colorAnchor + s + colorReset }
+	comment := func(s string) string { return colorComment + s + colorReset }
+
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"key and value", "a: x\n", key("a") + ": " + value("x") + "\n"},
+		{"nested keys", "a:\n  b: x\n", key("a") + ":\n  " + key("b") + ": " + value("x") + "\n"},
+		{"sequence items", "- x\n- k: v\n", "- " + value("x") + "\n- " + key("k") + ": " + value("v") + "\n"},
+		{"numbers, booleans and null", "a: 1\nb: 2.5\nc: true\nd: null\ne: ~\nf: \"1\"\n",
+			key("a") + ": " + number("1") + "\n" + key("b") + ": " + number("2.5") + "\n" + key("c") + ": " + boolean("true") + "\n" +
+				key("d") + ": " + boolean("null") + "\n" + key("e") + ": " + boolean("~") + "\n" + key("f") + ": " + value(`"1"`) + "\n"},
+		{"an empty null", "a:\nb: x\n", key("a") + ":\n" + key("b") + ": " + value("x") + "\n"},
+		{"comments", "# head\na: x # line\n", comment("# head") + "\n" + key("a") + ": " + value("x") + " " + comment("# line") + "\n"},
+		{"quoted key and a # in a quoted value", "\"a b\": \"x #y\"\n", key(`"a b"`) + ": " + value(`"x #y"`) + "\n"},
+		{"a key with a colon in it", "'a: b': x\n", key("'a: b'") + ": " + value("x") + "\n"},
+		{"a block scalar's lines are all value", "a: |\n  k: v\nb: x\n", key("a") + ": " + value("|") + "\n  " + value("k: v") + "\n" + key("b") + ": " + value("x") + "\n"},
+		{"a block scalar in a sequence item", "- |\n  k: v\n- x\n", "- " + value("|") + "\n  " + value("k: v") + "\n- " + value("x") + "\n"},
+		{"a block scalar starting with a space", "a: |2\n    x\n  - y\nb: x\n",
+			key("a") + ": " + value("|2") + "\n    " + value("x") + "\n  " + value("- y") + "\n" + key("b") + ": " + value("x") + "\n"},
+		{"a quoted string over two lines", "a: \"x\n  y\"\nb: x\n", key("a") + ": " + value(`"x`) + "\n  " + value(`y"`) + "\n" + key("b") + ": " + value("x") + "\n"},
+		{"anchors, aliases and tags", "a: &x 1\nb: *x\nc: !!str 2\n", key("a") + ": " + anchor("&x") + " " + number("1") + "\n" + key("b") + ": " + anchor("*x") + "\n" + key("c") + ": " + anchor("!!str") + " " + value("2") + "\n"},
+		{"flow collections", "a: {b: [1, x]}\n", key("a") + ": {" + key("b") + ": [" + number("1") + ", " + value("x") + "]}\n"},
+		{"document markers are left alone", "---\na: x\n", "---\n" + key("a") + ": " + value("x") + "\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := highlightYAML(tc.src, mustParse(t, tc.src)); got != tc.want {
+				t.Errorf("highlightYAML(%q) =\n%q\nwant:\n%q", tc.src, got, tc.want)
+			}
+		})
+	}
+
+	// Text that isn't what the node encodes as is left uncolored from there
+	src := "a: x\nb: y\n"
+	if got, want := highlightYAML(src, mustParse(t, "a: x\nb: z\n")), key("a")+": "+value("x")+"\n"+key("b")+": y\n"; got != want {
+		t.Errorf("highlightYAML with a different node =\n%q\nwant:\n%q", got, want)
+	}
+}
+
 func captureStdout(t *testing.T, fn func()) string {
 	t.Helper()
 	old := os.Stdout
@@ -343,7 +1396,7 @@ func TestListNode(t *testing.T) {
 	t.Run("lists mapping keys at depth 1", func(t *testing.T) {
 		target := extractPath(root, "database")
 		out := captureStdout(t, func() {
-			listNode(target, "", 1, 0)
+			listNode(target, "", options{depth: 1}, 0)
 		})
 		want := "host\nport\ncredentials\n"
 		if out != want {
@@ -354,7 +1407,7 @@ func TestListNode(t *testing.T) {
 	t.Run("unlimited depth (0) recurses fully", func(t *testing.T) {
 		target := extractPath(root, "database")
 		out := captureStdout(t, func() {
-			listNode(target, "", 0, 0)
+			listNode(target, "", options{depth: 0}, 0)
 		})
 		if !strings.Contains(out, "credentials\n") || !strings.Contains(out, "  user\n") {
 			t.Errorf("listNode(database, depth=0) did not recurse into credentials, got %q", out)
@@ -364,7 +1417,7 @@ func TestListNode(t *testing.T) {
 	t.Run("lists sequence indices", func(t *testing.T) {
 		target := extractPath(root, "services")
 		out := captureStdout(t, func() {
-			listNode(target, "", 1, 0)
+			listNode(target, "", options{depth: 1}, 0)
 		})
 		want := "[0]\n[1]\n"
 		if out != want {
@@ -375,48 +1428,54 @@ func TestListNode(t *testing.T) {
 	t.Run("scalar node lists nothing", func(t *testing.T) {
 		target := extractPath(root, "app.name")
 		out := captureStdout(t, func() {
-			listNode(target, "", 0, 0)
+			listNode(target, "", options{depth: 0}, 0)
 		})
 		if out != "" {
 			t.Errorf("listNode(scalar) = %q, want empty output", out)
 		}
 	})
-}
 
-func TestExtractPathHandlesYAMLAnchorsAndAliases(t *testing.T) {
-	// yaml.Node is a low-level AST: merge keys (<<) and aliases (*name) are
-	// NOT resolved the way they would be by yaml.Unmarshal into a struct or
-	// map. This test documents that current, real limitation rather than an
-	// assumption about how anchors "should" behave.
-	doc := mustParse(t, `
-defaults: &defaults
-  pool: 5
-production:
-  <<: *defaults
-  pool: 25
-regions: &regions
-  - us-east-1
-  - us-west-2
-deployment:
-  active_regions: *regions
-`)
-
-	t.Run("merge key is left unresolved as a literal mapping entry", func(t *testing.T) {
-		got := extractPath(doc, "production")
-		out := marshal(t, got)
-		if !strings.Contains(out, "<<: *defaults") {
-			t.Errorf("expected unresolved merge key in output, got:\n%s", out)
+	t.Run("types shows tags and collection sizes", func(t *testing.T) {
+		doc := mustParse(t, "name: web\nreplicas: 3\nratio: 0.5\nenabled: true\nnone: ~\nref: !Ref x\nmeta: {a: 1, b: 2}\nports: [80, 443, 8080]\nbase: &b 1\nalias: *b\n")
+		out := captureStdout(t, func() {
+			listNode(doc, "", options{depth: 1, types: true}, 0)
+		})
+		want := "name (str)\nreplicas (int)\nratio (float)\nenabled (bool)\nnone (null)\nref (!Ref)\nmeta (map[2])\nports (seq[3])\nbase (int)\nalias (alias)\n"
+		if out != want {
+			t.Errorf("listNode(types) = %q, want %q", out, want)
+		}
+	})
+
+	t.Run("values shows leaf scalars on one line each", func(t *testing.T) {
+		doc := mustParse(t, "a: |\n  one\n  two\nb: \"\"\nc: [1, {x: 2}]\n")
+		out := captureStdout(t, func() {
+			listNode(doc, "", options{depth: 0, values: true}, 0)
+		})
+		want := "a: one ...\nb: \"\"\nc\n  [0]: 1\n  [1]\n    x: 2\n"
+		if out != want {
+			t.Errorf("listNode(values) = %q, want %q", out, want)
 		}
 	})
 
-	t.Run("alias is left unresolved as a literal '*name' scalar", func(t *testing.T) {
-		got := extractPath(doc, "deployment.active_regions")
-		if got == nil {
-			t.Fatalf("extractPath(deployment.active_regions) = nil")
+	t.Run("tree draws connectors, closing each branch at its last child", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  b: 1\n  c: [x, y]\nd: 2\n")
+		out := captureStdout(t, func() {
+			listNode(doc, "", options{depth: 0, tree: true}, 0)
+		})
+		want := "├── a\n│   ├── b\n│   └── c\n│       ├── [0]\n│       └── [1]\n└── d\n"
+		if out != want {
+			t.Errorf("listNode(tree) =\n%s\nwant:\n%s", out, want)
 		}
-		out := marshal(t, got)
-		if strings.TrimSpace(out) != "*regions" {
-			t.Errorf("expected unresolved alias literal '*regions', got:\n%s", out)
+	})
+
+	t.Run("leaves prints the full path to each leaf", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  b: 1\n  c: [x, {}]\n\"d.e\": ''\n")
+		out := captureStdout(t, func() {
+			listNode(doc, "top", options{depth: 0, leaves: true, values: true}, 0)
+		})
+		want := "top.a.b = 1\ntop.a.c[0] = x\ntop.a.c[1] = {}\ntop.\"d.e\" = ''\n"
+		if out != want {
+			t.Errorf("listNode(leaves) = %q, want %q", out, want)
 		}
 	})
 }
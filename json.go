@@ -0,0 +1,211 @@
+// JSON output for gy: converts yaml.Node trees to JSON without going through
+// map[string]interface{}, so key order survives and scalars are typed by
+// their YAML tag rather than by guessing.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// orderedMap is a JSON object that marshals its keys in document order.
+type orderedMap []orderedEntry
+
+type orderedEntry struct {
+	key   string
+	value interface{}
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, entry := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSONValue(entry.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := marshalJSONValue(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSONValue is json.Marshal without HTML escaping, so values like
+// "a<b" come out as written.
+func marshalJSONValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// jsonWarnings is where jsonValue says a scalar's tag has no JSON type.
+// Each tag is only mentioned once, however many values carry it.
+var (
+	jsonWarnings io.Writer = os.Stderr
+	warnedTags             = map[string]bool{}
+)
+
+// jsonOptions are the settings jsonValue converts a tree with.
+type jsonOptions struct {
+	noMerge  bool // keep << merge keys as entries rather than merging them
+	sortKeys bool // order each object's keys
+}
+
+// jsonValue converts a node tree into a value encoding/json can marshal.
+// Scalars are resolved by tag: !!int and !!float become numbers, !!bool a
+// boolean and !!null null; everything else (strings, timestamps, custom
+// tags) keeps its original text as a JSON string, with a warning for
+// !!binary and custom tags, whose meaning that loses. A mapping's << merge
+// keys are resolved as they are for paths, its own keys winning, unless
+// opts.noMerge keeps them as they're written. An alias inside the node it
+// refers to, which JSON has no way to write, is null where it repeats.
+func jsonValue(node *yaml.Node, opts jsonOptions) interface{} {
+	return opts.value(node, map[*yaml.Node]bool{})
+}
+
+// value is jsonValue for node, following holding the aliases being
+// followed on the way down to it.
+func (opts jsonOptions) value(node *yaml.Node, following map[*yaml.Node]bool) interface{} {
+	if node == nil {
+		return nil
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return opts.value(node.Content[0], following)
+	case yaml.AliasNode:
+		if following[node] {
+			return nil
+		}
+		following[node] = true
+		defer delete(following, node)
+		return opts.value(node.Alias, following)
+	case yaml.MappingNode:
+		content := node.Content
+		if !opts.noMerge {
+			content = yamlpath.MergedContent(node)
+		}
+		obj := make(orderedMap, 0, len(content)/2)
+		for i := 0; i+1 < len(content); i += 2 {
+			obj = append(obj, orderedEntry{key: jsonKey(content[i], opts), value: opts.value(content[i+1], following)})
+		}
+		if opts.sortKeys {
+			sort.SliceStable(obj, func(i, j int) bool { return obj[i].key < obj[j].key })
+		}
+		return obj
+	case yaml.SequenceNode:
+		arr := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			arr = append(arr, opts.value(item, following))
+		}
+		return arr
+	case yaml.ScalarNode:
+		return jsonScalar(node)
+	}
+	return nil
+}
+
+// jsonKey is the object key for a mapping key: a scalar's text, or for a
+// sequence or mapping used as a key, its compact JSON.
+func jsonKey(key *yaml.Node, opts jsonOptions) string {
+	if key.Kind == yaml.AliasNode {
+		key = key.Alias
+	}
+	if key.Kind == yaml.ScalarNode {
+		return key.Value
+	}
+	out, err := marshalJSONValue(jsonValue(key, opts))
+	if err != nil {
+		return key.Value
+	}
+	return string(out)
+}
+
+func jsonScalar(node *yaml.Node) interface{} {
+	switch tag := node.ShortTag(); tag {
+	case "!!null":
+		return nil
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err == nil {
+			return b
+		}
+	case "!!int":
+		var i int64
+		if err := node.Decode(&i); err == nil {
+			return i
+		}
+		// Too big for int64 - keep the digits rather than lose precision
+		if n := strings.ReplaceAll(node.Value, "_", ""); json.Valid([]byte(n)) {
+			return json.Number(n)
+		}
+	case "!!float":
+		var f float64
+		if err := node.Decode(&f); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			// Keep the source spelling when it's already valid JSON
+			// (1.0 stays 1.0), otherwise fall back to Go's formatting.
+			if json.Valid([]byte(node.Value)) {
+				return json.Number(node.Value)
+			}
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case "!!binary":
+		warnTag(tag, "as their base64 text")
+	default:
+		if !strings.HasPrefix(tag, "!!") {
+			warnTag(tag, "as strings")
+		}
+	}
+	return node.Value
+}
+
+// warnTag warns, once per tag, that values tagged tag are written to JSON
+// as how says.
+func warnTag(tag, how string) {
+	if warnedTags[tag] {
+		return
+	}
+	warnedTags[tag] = true
+	fmt.Fprintf(jsonWarnings, "Warning: %s has no JSON type; writing %s values %s\n", tag, tag, how)
+}
+
+// writeJSON renders v as JSON with the given indent width; 0 means compact,
+// single-line output.
+func writeJSON(v interface{}, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if indent > 0 {
+		enc.SetIndent("", strings.Repeat(" ", indent))
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,201 @@
+// JSONPath patterns for gy: --jsonpath translates an expression such as
+// `$.spec.containers[0].name` into the equivalent gy pattern, so it runs on
+// the same walker as everything else. Anything without a gy equivalent is
+// reported as unsupported rather than quietly matching nothing.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tsettle/gy/yamlpath"
+)
+
+// jsonPathToPattern translates a JSONPath expression into a gy pattern. A
+// kubectl-style `{.items[*].metadata.name}` is accepted too, braces and all.
+func jsonPathToPattern(expr string) (string, error) {
+	unsupported := func(what string) (string, error) {
+		return "", fmt.Errorf("JSONPath %s is not supported: %s", expr, what)
+	}
+
+	path := strings.TrimSpace(expr)
+	if strings.HasPrefix(path, "{") && strings.HasSuffix(path, "}") {
+		path = strings.TrimSpace(path[1 : len(path)-1])
+	}
+	if strings.ContainsAny(path, "{}") {
+		return unsupported("kubectl templates such as {range} are not paths")
+	}
+	switch {
+	case strings.HasPrefix(path, "$"):
+		path = path[1:]
+	case strings.HasPrefix(path, "@"):
+		return unsupported("@ only refers to the current element inside a filter")
+	case path != "" && path[0] != '.' && path[0] != '[':
+		return "", fmt.Errorf("JSONPath %s must start with $", expr)
+	}
+
+	var b strings.Builder
+	// key appends a member name, after a dot unless it follows `..` or
+	// starts the pattern
+	key := func(name string, recursive bool) {
+		if b.Len() > 0 && !recursive {
+			b.WriteByte('.')
+		}
+		b.WriteString(name)
+	}
+	for i := 0; i < len(path); {
+		recursive := false
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			b.WriteString("..")
+			recursive = true
+			i += 2
+		case path[i] == '.':
+			i++
+		}
+		if i >= len(path) {
+			if recursive {
+				return unsupported(".. needs a member or wildcard after it")
+			}
+			return unsupported("a trailing .")
+		}
+
+		if path[i] != '[' {
+			// A dot-notation member runs to the next dot or bracket
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			name := path[i:end]
+			switch {
+			case name == "*":
+				key("*", recursive)
+			case strings.Contains(name, "("):
+				return unsupported("functions such as " + name)
+			default:
+				key(yamlpath.EscapeKey(name), recursive)
+			}
+			i = end
+			continue
+		}
+
+		end := closingBracket(path, i)
+		if end < 0 {
+			return "", fmt.Errorf("JSONPath %s has an unterminated [", expr)
+		}
+		body := strings.TrimSpace(path[i+1 : end])
+		i = end + 1
+		switch {
+		case body == "*":
+			b.WriteString("[*]")
+		case strings.HasPrefix(body, "'") || strings.HasPrefix(body, `"`):
+			name, ok := jsonPathName(body)
+			if !ok {
+				return unsupported("a union of member names, [" + body + "]")
+			}
+			key(yamlpath.EscapeKey(name), recursive)
+		case strings.HasPrefix(body, "?("):
+			filter, err := jsonPathFilter(body)
+			if err != nil {
+				return unsupported(err.Error())
+			}
+			b.WriteString("[" + filter + "]")
+		case strings.HasPrefix(body, "("):
+			return unsupported("script expressions such as [" + body + "]")
+		default:
+			// Indexes, slices and index lists read the same in gy
+			compact := strings.ReplaceAll(body, " ", "")
+			if strings.Trim(compact, "0123456789-:,") != "" {
+				return unsupported("[" + body + "]")
+			}
+			b.WriteString("[" + compact + "]")
+		}
+	}
+	if b.Len() == 0 {
+		return ".", nil
+	}
+	return b.String(), nil
+}
+
+// jsonPathFilter checks a `?(...)` filter body, which gy's own filters read
+// as written - `@.path`, `@.path == value` or `@.path != value`. Anything
+// else, such as `<` or `&&`, is an error naming it.
+func jsonPathFilter(body string) (string, error) {
+	if !strings.HasSuffix(body, ")") {
+		return "", fmt.Errorf("filter [%s] is missing its closing )", body)
+	}
+	expr := strings.TrimSpace(body[2 : len(body)-1])
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '&' || c == '|':
+			return "", fmt.Errorf("filters combining conditions with && or ||")
+		case c == '<' || c == '>' || (c == '=' && i+1 < len(expr) && expr[i+1] == '~'):
+			return "", fmt.Errorf("filter comparisons other than == and !=")
+		case c == '$':
+			return "", fmt.Errorf("filters referring to the root with $")
+		case c == '(' || c == ')':
+			return "", fmt.Errorf("functions and grouping inside filters")
+		case c == '!' && (i+1 >= len(expr) || expr[i+1] != '='):
+			return "", fmt.Errorf("negated filters")
+		}
+	}
+	if !strings.HasPrefix(expr, "@") {
+		return "", fmt.Errorf("filters that don't start from @")
+	}
+	return "?(" + expr + ")", nil
+}
+
+// jsonPathName unquotes a bracketed member name, 'name' or "name". Anything
+// past the closing quote, such as a second name, reports false.
+func jsonPathName(body string) (string, bool) {
+	end := closingQuote(body)
+	if end != len(body)-1 {
+		return "", false
+	}
+	inner := body[1:end]
+	if body[0] == '\'' {
+		inner = strings.ReplaceAll(strings.ReplaceAll(inner, `\'`, "'"), `"`, `\"`)
+	}
+	name, err := strconv.Unquote(`"` + inner + `"`)
+	return name, err == nil
+}
+
+// closingBracket returns the index of the ] closing the [ at start, skipping
+// quoted strings and nested brackets, or -1.
+func closingBracket(path string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
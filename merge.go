@@ -0,0 +1,189 @@
+// Merging for gy: --merge deep-merges a YAML fragment into the node at a
+// path and writes the whole stream out the way --set and --delete do.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+// runMerge implements --merge PATH: the fragment in patchFile is merged
+// into every node the path matches, in each selected document. With create,
+// a path that matches nothing is built first, as --set --create does.
+func runMerge(pattern string, args []string, docIndex int, appendSeqs, create, inPlace bool, outputFile string, yamlOpts yamlOptions, format string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: gy --merge PATH [--merge-seq replace|append] [--in-place|-i] [--create] patch.yaml [filename]")
+		os.Exit(exitUsage)
+	}
+	if err := yamlpath.CheckPath(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	patchFile := expandPath(args[0])
+	var filename string
+	if len(args) == 2 {
+		filename = expandPath(args[1])
+	}
+	if patchFile == "-" && (filename == "" || filename == "-") {
+		fmt.Fprintln(os.Stderr, "Error: the patch and the document can't both come from stdin")
+		os.Exit(exitUsage)
+	}
+	if inPlace && (filename == "" || filename == "-") {
+		fmt.Fprintln(os.Stderr, "Error: --in-place needs a filename, not stdin")
+		os.Exit(exitUsage)
+	}
+	if err := checkOutputFile(outputFile, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	patch := loadPatch(patchFile)
+	docs, selected := loadEditDocuments(filename, docIndex, format, inPlace)
+	if err := mergeAll(selected, pattern, patch, appendSeqs, create); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitNotFound)
+	}
+	if inPlace {
+		outputFile = filename
+	}
+	writeDocuments(docs, outputFile, yamlOpts)
+}
+
+// loadPatch reads the fragment --merge applies, which must be a single
+// document.
+func loadPatch(filename string) *yaml.Node {
+	source := filename
+	if filename == "-" {
+		source = "stdin"
+	}
+	docs, _ := loadDocuments(filename, "auto")
+	if len(docs) == 0 || len(docs[0].Content) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: the patch in %s is empty\n", source)
+		os.Exit(exitUsage)
+	}
+	if len(docs) > 1 {
+		fmt.Fprintf(os.Stderr, "Error: the patch in %s must be a single document, found %d\n", source, len(docs))
+		os.Exit(exitUsage)
+	}
+	return docs[0].Content[0]
+}
+
+// mergeAll merges patch into every node pattern matches across docs. When
+// nothing matches anywhere, create builds the path in each document
+// instead; without it that's an error.
+func mergeAll(docs []*yaml.Node, pattern string, patch *yaml.Node, appendSeqs, create bool) error {
+	found := false
+	for _, doc := range docs {
+		for _, m := range editMatches(doc, pattern) {
+			found = true
+			// A slice builds a new sequence; merging into that would
+			// change nothing in the document
+			if parent, _ := parentOf(doc, m); parent == nil && len(m.Path) > 0 {
+				return fmt.Errorf("cannot merge into %s: it selects several elements, not one node", yamlpath.FormatPath(m.Path))
+			}
+			deepMerge(m.Node, patch, appendSeqs)
+		}
+	}
+	if found {
+		return nil
+	}
+	if !create {
+		return fmt.Errorf("path not found: %s (use --create to add it)", pattern)
+	}
+	for _, doc := range docs {
+		node, err := createPath(doc, yamlpath.SplitPath(pattern))
+		if err != nil {
+			return err
+		}
+		deepMerge(node, patch, appendSeqs)
+	}
+	return nil
+}
+
+// deepMerge merges src into dst in place. Mapping keys are merged
+// recursively, keys that are only in src being added at the end; a
+// sequence is replaced by src's, or with appendSeqs has src's elements
+// added after its own. Anything else - a scalar, or two nodes of
+// different kinds - is replaced by src, keeping dst's comments when src
+// has none. src itself is never changed or shared with dst.
+func deepMerge(dst, src *yaml.Node, appendSeqs bool) {
+	if src.Kind == yaml.AliasNode {
+		src = src.Alias
+	}
+	// The document root is merged into what it holds, not replaced
+	if dst.Kind == yaml.DocumentNode {
+		if len(dst.Content) == 0 {
+			dst.Content = []*yaml.Node{copyNode(src)}
+			return
+		}
+		dst = dst.Content[0]
+	}
+	// An alias in dst is the entry, not the anchored node it shares with
+	// every other alias of it: merge into a copy of its own
+	if dst.Kind == yaml.AliasNode {
+		lineComment := dst.LineComment
+		*dst = *copyNode(dst.Alias)
+		dst.Anchor, dst.LineComment = "", lineComment
+	}
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			if existing := findMapValue(dst, key.Value); existing != nil {
+				deepMerge(existing, value, appendSeqs)
+				continue
+			}
+			dst.Content = append(dst.Content, copyNode(key), copyNode(value))
+		}
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode && appendSeqs:
+		for _, item := range src.Content {
+			dst.Content = append(dst.Content, copyNode(item))
+		}
+	default:
+		replaced := copyNode(src)
+		if replaced.HeadComment == "" {
+			replaced.HeadComment = dst.HeadComment
+		}
+		if replaced.LineComment == "" {
+			replaced.LineComment = dst.LineComment
+		}
+		if replaced.FootComment == "" {
+			replaced.FootComment = dst.FootComment
+		}
+		*dst = *replaced
+	}
+}
+
+// copyNode returns a deep copy of node. An alias of a node inside the copy
+// points at that node's copy; one of a node outside it is left as it was.
+func copyNode(node *yaml.Node) *yaml.Node {
+	copies := map[*yaml.Node]*yaml.Node{}
+	var walk func(*yaml.Node) *yaml.Node
+	walk = func(n *yaml.Node) *yaml.Node {
+		if n == nil {
+			return nil
+		}
+		if c, ok := copies[n]; ok {
+			return c
+		}
+		c := *n
+		copies[n] = &c
+		if n.Content != nil {
+			c.Content = make([]*yaml.Node, len(n.Content))
+			for i, child := range n.Content {
+				c.Content[i] = walk(child)
+			}
+		}
+		if n.Alias != nil {
+			if a, ok := copies[n.Alias]; ok {
+				c.Alias = a
+			}
+		}
+		return &c
+	}
+	return walk(node)
+}
@@ -0,0 +1,93 @@
+// Writing output to a file for gy: --output-file, and --in-place edits,
+// write to a temporary file beside the target and rename it into place
+// once everything is written, so a failed or interrupted run never leaves
+// a half-written file behind.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is a temporary file that replaces the file at name when it's
+// committed. Readers of name see the old contents or the new, never a mix.
+type atomicFile struct {
+	*os.File
+	name string
+}
+
+// createAtomic creates the temporary file for name in the same directory,
+// so the final rename never crosses filesystems.
+func createAtomic(name string) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: tmp, name: name}, nil
+}
+
+// commit moves what was written into place. A file being replaced keeps
+// its permissions; a new one is 0644 rather than CreateTemp's 0600.
+func (f *atomicFile) commit() error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(f.name); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.File.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.name)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// discard removes the temporary file, leaving the file at name as it was.
+func (f *atomicFile) discard() {
+	f.Close()
+	os.Remove(f.File.Name())
+}
+
+// writeFileAtomic writes data to name through an atomicFile.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := createAtomic(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.discard()
+		return err
+	}
+	return f.commit()
+}
+
+// checkOutputFile refuses an --output-file that is the input itself:
+// writing the result over the document being read is what --in-place is
+// for, and only for edits.
+func checkOutputFile(output, input string) error {
+	if output == "" || input == "" || input == "-" {
+		return nil
+	}
+	out, err := os.Stat(output)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot write %s: %v", output, describeReadError(err))
+	}
+	in, err := os.Stat(input)
+	if err == nil && os.SameFile(in, out) {
+		return fmt.Errorf("--output-file %s is the input file; use --in-place with --set, --delete or --merge to change it", output)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Streaming input for gy: --stream decodes a multi-document file one
+// document at a time, searching and printing each before reading the next,
+// so memory use follows the largest document rather than the whole file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// streamDocuments decodes the documents in r one at a time, handing each to
+// fn until fn returns false or the input ends. Like parseDocuments, empty
+// input yields a single empty node.
+func streamDocuments(r io.Reader, fn func(doc *yaml.Node) bool) error {
+	dec := yaml.NewDecoder(r)
+	decoded := false
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		decoded = true
+		if !fn(&node) {
+			return nil
+		}
+	}
+	if !decoded {
+		fn(&yaml.Node{})
+	}
+	return nil
+}
+
+// streamInput runs streamDocuments over filename, or stdin when filename is
+// empty or "-", exiting with a clean message if it can't be read or parsed.
+// TOML has no documents to stream, so it's refused up front. cleanup runs
+// before any exit, to drop output that was already written.
+func streamInput(filename, format string, cleanup func(), fn func(doc *yaml.Node) bool) {
+	if format == "auto" && strings.ToLower(filepath.Ext(filename)) == ".toml" {
+		format = "toml"
+	}
+	if format == "toml" {
+		fmt.Fprintln(os.Stderr, "Error: --stream reads YAML or JSON; TOML has to be read whole")
+		cleanup()
+		os.Exit(exitUsage)
+	}
+	source := "stdin"
+	var in io.Reader = os.Stdin
+	if filename != "" && filename != "-" {
+		source = filename
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot read %s: %v\n", source, describeReadError(err))
+			cleanup()
+			os.Exit(exitIO)
+		}
+		defer f.Close()
+		in = f
+	}
+	// Unlike parseInput, the input isn't normalized first: the decoder
+	// already skips a byte order mark and reads CRLF as a line break
+	if err := streamDocuments(bufio.NewReader(in), fn); err != nil {
+		if format == "auto" {
+			format = "yaml"
+		}
+		fmt.Fprintf(os.Stderr, "Error: failed to parse %s in %s: %s\n", strings.ToUpper(format), source, strings.TrimPrefix(err.Error(), "yaml: "))
+		cleanup()
+		os.Exit(exitIO)
+	}
+}
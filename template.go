@@ -0,0 +1,104 @@
+// Template output for gy: --template formats each match with a Go
+// text/template, so a simple report needs no other tool.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/tsettle/gy/yamlpath"
+)
+
+// templateFuncs are the helpers a --template can call, on top of
+// text/template's own. Each takes the value it works on last, so it can
+// end a pipeline: {{ .tags | join ", " }}.
+var templateFuncs = template.FuncMap{
+	"upper": func(v interface{}) string { return strings.ToUpper(templateText(v)) },
+	"lower": func(v interface{}) string { return strings.ToLower(templateText(v)) },
+	// join joins a sequence's elements with sep; anything else is itself
+	"join": func(sep string, v interface{}) string {
+		items, ok := v.([]interface{})
+		if !ok {
+			return templateText(v)
+		}
+		texts := make([]string, len(items))
+		for i, item := range items {
+			texts[i] = templateText(item)
+		}
+		return strings.Join(texts, sep)
+	},
+	// default stands in for a missing key, null, an empty string or an
+	// empty collection. false and 0 are values, so they're kept.
+	"default": func(fallback, v interface{}) interface{} {
+		if v == nil {
+			return fallback
+		}
+		switch rv := reflect.ValueOf(v); rv.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			if rv.Len() == 0 {
+				return fallback
+			}
+		}
+		return v
+	},
+	// indent puts n spaces before every line that isn't empty
+	"indent": func(n int, v interface{}) string {
+		lines := strings.Split(templateText(v), "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = strings.Repeat(" ", n) + line
+			}
+		}
+		return strings.Join(lines, "\n")
+	},
+}
+
+// templateText is v as a template prints it, except that null is empty
+// rather than <nil>.
+func templateText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// parseTemplate parses a --template. Errors are worded without
+// text/template's own "template: " prefix, as the caller adds its own.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("--template").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes tmpl once per match, with the match decoded into
+// plain Go values - a mapping as a map, a sequence as a slice - as its
+// data. Each match's output ends in a newline, added if the template
+// doesn't end with one. An error names the match it happened on.
+func renderTemplate(tmpl *template.Template, matches []yamlpath.Match) (string, error) {
+	var b bytes.Buffer
+	for _, m := range matches {
+		where := yamlpath.DescribePath(m.Path)
+		if m.Detached {
+			where = "the --default value"
+		}
+		var data interface{}
+		if err := m.Node.Decode(&data); err != nil {
+			return "", fmt.Errorf("--template failed on %s: %v", where, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return "", fmt.Errorf("--template failed on %s: %s", where, strings.TrimPrefix(err.Error(), "template: "))
+		}
+		b.Write(out.Bytes())
+		if out.Len() == 0 || out.Bytes()[out.Len()-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
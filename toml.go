@@ -0,0 +1,582 @@
+// TOML input for gy: parses a TOML document straight into a yaml.Node
+// tree, so every path, flag and output format works on it unchanged.
+// Tables and inline tables become mappings, arrays become sequences, and
+// scalars carry the YAML tag of the matching type.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	tomlBareKey   = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+	tomlInteger   = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$|^0x[0-9A-Fa-f](_?[0-9A-Fa-f])*$|^0o[0-7](_?[0-7])*$|^0b[01](_?[01])*$`)
+	tomlFloat     = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	tomlDateTime  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})?)?$`)
+	tomlLocalTime = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(\.\d+)?$`)
+)
+
+// tomlParser holds the state of one parse: the input, the read position
+// and the table new key/value pairs go into.
+type tomlParser struct {
+	src     string
+	pos     int
+	line    int
+	root    *yaml.Node
+	current *yaml.Node
+	defined map[*yaml.Node]bool // tables given their own [header]
+	arrays  map[*yaml.Node]bool // sequences built by [[header]]
+	frozen  map[*yaml.Node]bool // inline tables and arrays, closed to later keys
+}
+
+// parseTOML parses a TOML document into a single-document node, the same
+// shape parseDocuments returns for YAML.
+func parseTOML(input []byte) ([]*yaml.Node, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	p := &tomlParser{
+		src:     string(input),
+		line:    1,
+		root:    root,
+		current: root,
+		defined: map[*yaml.Node]bool{},
+		arrays:  map[*yaml.Node]bool{},
+		frozen:  map[*yaml.Node]bool{},
+	}
+	if !utf8.ValidString(p.src) {
+		return nil, fmt.Errorf("input is not valid UTF-8")
+	}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.line, err)
+	}
+	return []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}}, nil
+}
+
+func (p *tomlParser) parse() error {
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return nil
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			err = p.arrayTable()
+		case p.peek() == '[':
+			err = p.table()
+		default:
+			err = p.keyValue(p.current)
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// table handles a [a.b] header, making that table the current one.
+func (p *tomlParser) table() error {
+	p.pos++
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if !p.consume("]") {
+		return fmt.Errorf("expected ] after table name")
+	}
+	parent, err := p.descend(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	name := keys[len(keys)-1]
+	table := mappingValue(parent, name)
+	switch {
+	case table == nil:
+		table = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: p.line}
+		appendPair(parent, name, table, p.line)
+	case table.Kind != yaml.MappingNode || p.frozen[table]:
+		return fmt.Errorf("[%s] is already defined as a value", strings.Join(keys, "."))
+	case p.defined[table]:
+		return fmt.Errorf("table [%s] is defined more than once", strings.Join(keys, "."))
+	}
+	p.defined[table] = true
+	p.current = table
+	return nil
+}
+
+// arrayTable handles a [[a.b]] header, appending a new table to the array
+// and making it the current one.
+func (p *tomlParser) arrayTable() error {
+	p.pos += 2
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if !p.consume("]]") {
+		return fmt.Errorf("expected ]] after array of tables name")
+	}
+	parent, err := p.descend(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	name := keys[len(keys)-1]
+	array := mappingValue(parent, name)
+	switch {
+	case array == nil:
+		array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: p.line}
+		appendPair(parent, name, array, p.line)
+		p.arrays[array] = true
+	case !p.arrays[array]:
+		return fmt.Errorf("[[%s]] is already defined as a value", strings.Join(keys, "."))
+	}
+	table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: p.line}
+	array.Content = append(array.Content, table)
+	p.current = table
+	return nil
+}
+
+// descend walks from table through keys, creating missing tables. A key
+// naming an array of tables continues in its last element.
+func (p *tomlParser) descend(table *yaml.Node, keys []string) (*yaml.Node, error) {
+	for i, k := range keys {
+		next := mappingValue(table, k)
+		switch {
+		case next == nil:
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: p.line}
+			appendPair(table, k, next, p.line)
+		case p.arrays[next]:
+			next = next.Content[len(next.Content)-1]
+		case next.Kind != yaml.MappingNode || p.frozen[next]:
+			return nil, fmt.Errorf("%s is already defined as a value", strings.Join(keys[:i+1], "."))
+		}
+		table = next
+	}
+	return table, nil
+}
+
+// keyValue parses key = value into table; a dotted key creates the tables
+// along the way.
+func (p *tomlParser) keyValue(table *yaml.Node) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.consume("=") {
+		return fmt.Errorf("expected = after key %s", strings.Join(keys, "."))
+	}
+	p.skipSpace()
+	line := p.line
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.descend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	name := keys[len(keys)-1]
+	if mappingValue(parent, name) != nil {
+		return fmt.Errorf("key %s is defined more than once", strings.Join(keys, "."))
+	}
+	appendPair(parent, name, value, line)
+	return nil
+}
+
+// key parses a bare, quoted or dotted key into its parts.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var k string
+		switch p.peek() {
+		case '"':
+			s, err := p.basicString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		case '\'':
+			s, err := p.literalString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			k = tomlBareKey.FindString(p.rest())
+			if k == "" {
+				return nil, fmt.Errorf("expected a key, found %s", p.describeNext())
+			}
+			p.pos += len(k)
+		}
+		keys = append(keys, k)
+		p.skipSpace()
+		if !p.consume(".") {
+			return keys, nil
+		}
+	}
+}
+
+// value parses any TOML value.
+func (p *tomlParser) value() (*yaml.Node, error) {
+	line := p.line
+	scalar := func(tag, value string) (*yaml.Node, error) {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value, Line: line}, nil
+	}
+	rest := p.rest()
+	switch {
+	case strings.HasPrefix(rest, `"""`):
+		s, err := p.multilineString(`"""`)
+		if err != nil {
+			return nil, err
+		}
+		return scalar("!!str", s)
+	case strings.HasPrefix(rest, "'''"):
+		s, err := p.multilineString("'''")
+		if err != nil {
+			return nil, err
+		}
+		return scalar("!!str", s)
+	case p.peek() == '"':
+		s, err := p.basicString()
+		if err != nil {
+			return nil, err
+		}
+		return scalar("!!str", s)
+	case p.peek() == '\'':
+		s, err := p.literalString()
+		if err != nil {
+			return nil, err
+		}
+		return scalar("!!str", s)
+	case p.peek() == '[':
+		return p.array()
+	case p.peek() == '{':
+		return p.inlineTable()
+	}
+
+	token := p.token()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("expected a value, found %s", p.describeNext())
+	case token == "true" || token == "false":
+		return scalar("!!bool", token)
+	case token == "inf" || token == "+inf" || token == "-inf" || token == "nan" || token == "+nan" || token == "-nan":
+		value := strings.Replace(strings.TrimPrefix(token, "+"), "inf", ".inf", 1)
+		if strings.HasSuffix(value, "nan") {
+			value = ".nan"
+		}
+		return scalar("!!float", value)
+	case tomlInteger.MatchString(token):
+		n, err := strconv.ParseInt(token, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer %s is out of range", token)
+		}
+		return scalar("!!int", strconv.FormatInt(n, 10))
+	case tomlFloat.MatchString(token):
+		value := strings.ReplaceAll(token, "_", "")
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("float %s is out of range", token)
+		}
+		return scalar("!!float", value)
+	case tomlDateTime.MatchString(token):
+		return scalar("!!timestamp", token)
+	case tomlLocalTime.MatchString(token):
+		// YAML has no time-of-day type, so it stays a string
+		return scalar("!!str", token)
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// token reads an unquoted value: a number, boolean or date-time. A date
+// followed by a space and a time is one token.
+func (p *tomlParser) token() string {
+	start := p.pos
+	for !p.eof() && strings.IndexByte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_+-.:", p.peek()) >= 0 {
+		p.pos++
+	}
+	token := p.src[start:p.pos]
+	if len(token) == 10 && tomlDateTime.MatchString(token) && len(p.rest()) >= 3 && p.peek() == ' ' &&
+		isDigit(p.src[p.pos+1]) && isDigit(p.src[p.pos+2]) {
+		p.pos++
+		return token + " " + p.token()
+	}
+	return token
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// array parses [v, v, ...], which may span lines and hold comments.
+func (p *tomlParser) array() (*yaml.Node, error) {
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle, Line: p.line}
+	p.frozen[seq] = true
+	p.pos++
+	for {
+		p.skipBlank(true)
+		if p.consume("]") {
+			return seq, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		seq.Content = append(seq.Content, value)
+		p.skipBlank(true)
+		if p.consume("]") {
+			return seq, nil
+		}
+		if !p.consume(",") {
+			return nil, fmt.Errorf("expected , or ] in array, found %s", p.describeNext())
+		}
+	}
+}
+
+// inlineTable parses { k = v, ... }, which must stay on one line.
+func (p *tomlParser) inlineTable() (*yaml.Node, error) {
+	table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Style: yaml.FlowStyle, Line: p.line}
+	p.pos++
+	p.skipSpace()
+	if p.consume("}") {
+		p.frozen[table] = true
+		return table, nil
+	}
+	for {
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.consume("}") {
+			p.frozen[table] = true
+			return table, nil
+		}
+		if !p.consume(",") {
+			return nil, fmt.Errorf("expected , or } in inline table, found %s", p.describeNext())
+		}
+	}
+}
+
+// basicString parses a "..." string, resolving escapes.
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// literalString parses a '...' string, which has no escapes.
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.rest(), "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// multilineString parses a """...""" or ”'...”' string. A newline right
+// after the opening quotes is dropped, and in the basic form a backslash
+// at the end of a line joins it to the next non-blank text.
+func (p *tomlParser) multilineString(quote string) (string, error) {
+	p.pos += 3
+	if p.consume("\r\n") || p.consume("\n") {
+		p.line++
+	}
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated multi-line string")
+		}
+		if strings.HasPrefix(p.rest(), quote) {
+			// Up to two quotes may sit right before the closing three
+			n := 3
+			for n < 5 && p.pos+n < len(p.src) && p.src[p.pos+n] == quote[0] {
+				n++
+			}
+			b.WriteString(p.src[p.pos : p.pos+n-3])
+			p.pos += n
+			return b.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case c == '\\' && quote == `"""`:
+			if lineEnd := strings.TrimLeft(p.rest()[1:], " \t"); strings.HasPrefix(lineEnd, "\n") || strings.HasPrefix(lineEnd, "\r\n") {
+				p.pos++
+				for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+					if p.peek() == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+				continue
+			}
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case c == '\n':
+			p.line++
+			b.WriteByte(c)
+			p.pos++
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// escape decodes the backslash escape at the read position into b.
+func (p *tomlParser) escape(b *strings.Builder) error {
+	p.pos++
+	if p.eof() {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte('\x1b')
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return fmt.Errorf("invalid escape \\%c", c)
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid escape \\%c%s", c, p.src[p.pos:p.pos+size])
+		}
+		b.WriteRune(rune(code))
+		p.pos += size
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+// endOfLine requires the rest of the line to be blank or a comment.
+func (p *tomlParser) endOfLine() error {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil
+	}
+	if p.consume("\r\n") || p.consume("\n") {
+		p.line++
+		return nil
+	}
+	return fmt.Errorf("expected the end of the line, found %s", p.describeNext())
+}
+
+// skipBlank skips spaces and comments, and newlines too when newlines is
+// set.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case newlines && c == '\n':
+			p.line++
+			p.pos++
+		case newlines && strings.HasPrefix(p.rest(), "\r\n"):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) consume(s string) bool {
+	if strings.HasPrefix(p.rest(), s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *tomlParser) describeNext() string {
+	if p.eof() {
+		return "the end of the input"
+	}
+	if p.peek() == '\n' || strings.HasPrefix(p.rest(), "\r\n") {
+		return "the end of the line"
+	}
+	r, _ := utf8.DecodeRuneInString(p.rest())
+	return strconv.QuoteRune(r)
+}
+
+func (p *tomlParser) eof() bool    { return p.pos >= len(p.src) }
+func (p *tomlParser) rest() string { return p.src[p.pos:] }
+
+// peek returns the byte at the read position, or 0 at the end of input.
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// mappingValue returns the value under key in m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// appendPair adds key: value to the end of m.
+func appendPair(m *yaml.Node, key string, value *yaml.Node, line int) {
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: line}
+	m.Content = append(m.Content, k, value)
+}
@@ -0,0 +1,84 @@
+package yamlpath_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tsettle/gy/yamlpath"
+	"gopkg.in/yaml.v3"
+)
+
+const manifest = `
+services:
+  web:
+    image: nginx:1.25  # pinned
+    ports: [80, 443]
+  db:
+    image: postgres:16
+`
+
+func ExampleExtract() {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+		panic(err)
+	}
+	node, err := yamlpath.Extract(&doc, "services.web.ports[-1]")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(node.Value)
+	// Output: 443
+}
+
+func ExampleExtractAll() {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+		panic(err)
+	}
+	matches, err := yamlpath.ExtractAll(&doc, "services.*.image")
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range matches {
+		fmt.Println(yamlpath.FormatPath(m.Path), m.Node.Value)
+	}
+	// Output:
+	// services.web.image nginx:1.25
+	// services.db.image postgres:16
+}
+
+func ExampleWrap() {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+		panic(err)
+	}
+	matches, err := yamlpath.ExtractAll(&doc, "services.*.image")
+	if err != nil {
+		panic(err)
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(yamlpath.Wrap(&doc, matches)); err != nil {
+		panic(err)
+	}
+	// Output:
+	// services:
+	//   web:
+	//     image: nginx:1.25 # pinned
+	//   db:
+	//     image: postgres:16
+}
+
+func ExampleWalker() {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("Name: gy\nTags: [yaml, cli]\n"), &doc); err != nil {
+		panic(err)
+	}
+	w := yamlpath.Walker{IgnoreCase: true}
+	matches, err := w.Find(&doc, "tags | length")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(matches[0].Node.Value)
+	// Output: 2
+}
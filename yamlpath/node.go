@@ -0,0 +1,89 @@
+// Small questions about nodes that both the walker and the CLI ask.
+
+package yamlpath
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Length returns the size of a node: the number of elements of a
+// sequence, keys of a mapping or characters of a scalar, and 0 for null.
+// An alias measures the node it refers to.
+func Length(node *yaml.Node) int {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return len(node.Content)
+	case yaml.MappingNode:
+		return len(node.Content) / 2
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!null" {
+			return 0
+		}
+		return utf8.RuneCountInString(node.Value)
+	}
+	return 0
+}
+
+// Keys returns copies of the keys of a matched mapping, without
+// their comments, in document order or sorted. Any other node is an error
+// naming op, the operation that wanted the keys.
+func Keys(m Match, sorted bool, op string) ([]*yaml.Node, error) {
+	node := m.Node
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s needs a mapping, but %s is %s", op, DescribePath(m.Path), KindName(node))
+	}
+	keys := make([]*yaml.Node, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := *node.Content[i]
+		key.HeadComment, key.LineComment, key.FootComment = "", "", ""
+		keys = append(keys, &key)
+	}
+	if sorted {
+		sort.SliceStable(keys, func(i, j int) bool { return keys[i].Value < keys[j].Value })
+	}
+	return keys, nil
+}
+
+// Values returns copies of the values of a matched mapping in document
+// order, each alias followed to the node it refers to and any anchor
+// dropped, so the values stand on their own. Any other node is an error
+// naming op, as with Keys.
+func Values(m Match, op string) ([]*yaml.Node, error) {
+	node := m.Node
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s needs a mapping, but %s is %s", op, DescribePath(m.Path), KindName(node))
+	}
+	values := make([]*yaml.Node, 0, len(node.Content)/2)
+	for i := 1; i < len(node.Content); i += 2 {
+		value := *resolveAlias(node.Content[i])
+		value.Anchor = ""
+		values = append(values, &value)
+	}
+	return values, nil
+}
+
+// KindName describes a node's kind for error messages, e.g. "a mapping".
+func KindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a sequence"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	return "a scalar"
+}
@@ -0,0 +1,796 @@
+// Pattern syntax: splitting a pattern into its unions, pipe stages,
+// fallbacks and path parts, checking each of them, and classifying parts.
+
+package yamlpath
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IsIndexPart reports whether a path part is a bracketed sequence index
+// like "[0]".
+func IsIndexPart(part string) bool {
+	return len(part) > 2 && part[0] == '[' && part[len(part)-1] == ']'
+}
+
+// plainInt parses a path part that is a bare integer, such as the 2023 in
+// `history.2023`.
+func plainInt(part string) (int64, bool) {
+	if part == "" || part[0] == '+' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(part, 10, 64)
+	return n, err == nil
+}
+
+// indexInt parses a bracketed integer index part, such as `[2]` or `[-1]`.
+func indexInt(part string) (int64, bool) {
+	if !IsIndexPart(part) {
+		return 0, false
+	}
+	return plainInt(part[1 : len(part)-1])
+}
+
+// intKey returns want when a mapping has that key as written, or else the
+// first key that resolves to the integer n, so `history.16` finds a `0x10:`
+// key. With neither, want is returned unchanged to match nothing.
+func intKey(content []*yaml.Node, want string, n int64) string {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == want {
+			return want
+		}
+	}
+	for i := 0; i+1 < len(content); i += 2 {
+		var key int64
+		if content[i].ShortTag() == "!!int" && content[i].Decode(&key) == nil && key == n {
+			return content[i].Value
+		}
+	}
+	return want
+}
+
+// boolWords are the plain scalars YAML 1.1 reads as booleans. yaml.v3
+// follows YAML 1.2, where only true and false are, so a GitHub Actions
+// `on:` key stays the string "on" - unless a YAML 1.1 tool such as PyYAML
+// has been through the file and rewritten it as `true:`.
+var boolWords = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"true": true, "True": true, "TRUE": true,
+	"on": true, "On": true, "ON": true,
+	"n": false, "N": false, "no": false, "No": false, "NO": false,
+	"false": false, "False": false, "FALSE": false,
+	"off": false, "Off": false, "OFF": false,
+}
+
+// plainBool parses a path part that is a bare boolean word, such as the on
+// in `on.push`, the way YAML 1.1 reads it.
+func plainBool(part string) (value, ok bool) {
+	value, ok = boolWords[part]
+	return value, ok
+}
+
+// boolKey returns want when a mapping has that key as written, or else the
+// first key that resolves to the boolean b - a `True:` for `true` - or
+// failing that the first plain key YAML 1.1 reads as b, so `on.push` finds
+// a `true:` key and `yes` finds `on:`. A quoted key is a string to both
+// versions and only matches as written.
+func boolKey(content []*yaml.Node, want string, b bool) string {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == want {
+			return want
+		}
+	}
+	for i := 0; i+1 < len(content); i += 2 {
+		var key bool
+		if content[i].ShortTag() == "!!bool" && content[i].Decode(&key) == nil && key == b {
+			return content[i].Value
+		}
+	}
+	for i := 0; i+1 < len(content); i += 2 {
+		key := content[i]
+		if value, ok := plainBool(key.Value); ok && value == b && key.Kind == yaml.ScalarNode && key.Style == 0 {
+			return key.Value
+		}
+	}
+	return want
+}
+
+// isRegexPart reports whether a path part is a `~regexp` key match. A key
+// that really starts with `~` is addressed as `"~key"` or `\~key`.
+func isRegexPart(part string) bool {
+	return len(part) > 1 && part[0] == '~'
+}
+
+// IsAnchorPart reports whether a path part is an `&anchor` reference, or
+// `&*` for every anchor. A key that really starts with `&` is addressed
+// as `"&key"` or `\&key`.
+func IsAnchorPart(part string) bool {
+	return len(part) > 1 && part[0] == '&'
+}
+
+// IsGlobPart reports whether a path part is a key glob like `env_*` or
+// `node?`: an unquoted part other than `*` itself with an unescaped `*` or
+// `?`. Its keys are matched with path.Match, so `\*` and `\?` stay literal.
+func IsGlobPart(part string) bool {
+	if part == "*" || part == "" || part[0] == '"' || part[0] == '\'' || IsIndexPart(part) || isRegexPart(part) || IsAnchorPart(part) {
+		return false
+	}
+	for i := 0; i < len(part); i++ {
+		switch part[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
+
+// BracketKey returns the key named by a jq-style bracketed string part like
+// `["app.kubernetes.io/name"]`, and whether part has that form.
+func BracketKey(part string) (string, bool) {
+	if !IsIndexPart(part) {
+		return "", false
+	}
+	body := part[1 : len(part)-1]
+	if len(body) < 2 || (body[0] != '"' && body[0] != '\'') || body[len(body)-1] != body[0] {
+		return "", false
+	}
+	return KeyName(body), true
+}
+
+// KeyName returns the literal mapping key a path part refers to. A part
+// may be quoted (`"app.kubernetes.io/name"` or `'a.b'`) to take dots and
+// brackets literally, and backslash escapes are removed either way - `\*`
+// or `"*"` addresses a key literally named `*` rather than acting as a
+// wildcard.
+func KeyName(part string) string {
+	if !strings.ContainsAny(part, `\"'`) {
+		return part
+	}
+	var b strings.Builder
+	i := 0
+	if part[0] == '"' || part[0] == '\'' {
+		quote := part[0]
+		for i = 1; i < len(part) && part[i] != quote; i++ {
+			if part[i] == '\\' && i+1 < len(part) {
+				i++
+			}
+			b.WriteByte(part[i])
+		}
+		i++ // skip the closing quote
+	}
+	for ; i < len(part); i++ {
+		if part[i] == '\\' && i+1 < len(part) {
+			i++
+		}
+		b.WriteByte(part[i])
+	}
+	return b.String()
+}
+
+// EscapeKey is the inverse of KeyName: it produces a part that addresses
+// key literally, double-quoting keys that would otherwise be read as path
+// syntax (dots, brackets, wildcards and globs, quotes, union commas,
+// pipes, a leading `~` or `&`, a lone `^`) or that are empty.
+func EscapeKey(key string) string {
+	if key != "" && !strings.ContainsAny(key, `\.[]*?"',|`) && !strings.Contains(key, "//") && key[0] != '~' && key[0] != '&' && key != "^" {
+		return key
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(key); i++ {
+		if key[i] == '"' || key[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(key[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Find returns the matches of a pattern that may be a pipe, "items[*] |
+// metadata | keys": each stage runs on every match of the stage before,
+// and what the last stage matches is the result. A stage is a path, which
+// may hold fallbacks, or `keys` or `length`. A path keeps building on the
+// concrete paths of its input, so the result can still be wrapped in its
+// real location. A Limit applies to the last stage: the ones before it
+// must find everything, since any of their matches may come to nothing.
+func (w *Walker) Find(node *yaml.Node, pattern string) ([]Match, error) {
+	stages := splitPipe(pattern)
+	limit := w.Limit
+	defer func() { w.Limit = limit }()
+	if len(stages) > 1 {
+		w.Limit = 0
+	}
+	matches, err := w.resolve(node, stages[0])
+	for s, stage := range stages[1:] {
+		if w.err != nil {
+			break
+		}
+		last := s == len(stages)-2
+		var next []Match
+		var stageErr error
+		for _, in := range matches {
+			if last && limit > 0 {
+				if len(next) >= limit {
+					break
+				}
+				w.Limit = limit - len(next)
+			}
+			out, err := w.stage(in, stage)
+			if w.err != nil {
+				return nil, w.err
+			}
+			if err != nil && stageErr == nil {
+				stageErr = err
+			}
+			next = append(next, out...)
+		}
+		matches, err = Dedupe(next), nil
+		if len(matches) == 0 {
+			err = stageErr
+		}
+	}
+	return matches, err
+}
+
+// pipeStages are the operations a pipe stage can name instead of a path.
+// Each produces a detached value; a key literally named `keys`, `values`
+// or `length` is still reachable as `.keys`.
+var pipeStages = map[string]func(m Match) (*yaml.Node, error){
+	"keys": func(m Match) (*yaml.Node, error) {
+		names, err := Keys(m, false, "keys")
+		if err != nil {
+			return nil, err
+		}
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: names}, nil
+	},
+	"values": func(m Match) (*yaml.Node, error) {
+		values, err := Values(m, "values")
+		if err != nil {
+			return nil, err
+		}
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: values}, nil
+	},
+	"length": func(m Match) (*yaml.Node, error) {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(Length(m.Node))}, nil
+	},
+}
+
+// stage runs one pipe stage on a match from the stage before.
+func (w *Walker) stage(in Match, stage string) ([]Match, error) {
+	if op, ok := pipeStages[stage]; ok {
+		node, err := op(in)
+		if err != nil {
+			return nil, err
+		}
+		return []Match{{Node: node, Detached: true}}, nil
+	}
+	if !in.Detached {
+		w.base = in.Path[:len(in.Path):len(in.Path)]
+		defer func() { w.base = nil }()
+	}
+	found, err := w.resolve(in.Node, stage)
+	if in.Detached {
+		for i := range found {
+			found[i].Path, found[i].Detached = nil, true
+		}
+	}
+	return found, err
+}
+
+// A SyntaxError is a malformed pattern, such as an unclosed bracket or an
+// index that isn't a number. Offset is the byte offset of the problem in
+// the pattern that was checked.
+type SyntaxError struct {
+	Msg    string
+	Offset int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+// Check reports syntax errors in pattern - an unterminated quote, an
+// unclosed bracket, a misplaced `**` - before it's run, in each stage of a
+// pipe and each of its fallback alternatives. Errors that can be pinned
+// to a place in pattern are a *SyntaxError.
+func Check(pattern string) error {
+	stages, offsets := splitTopLevelAt(pattern, "|")
+	for i, stage := range stages {
+		if len(stages) > 1 && stage == "" {
+			return fmt.Errorf("empty stage in pipe %q", pattern)
+		}
+		if _, ok := pipeStages[stage]; ok {
+			continue
+		}
+		if err := checkFallback(stage); err != nil {
+			return Shift(err, offsets[i])
+		}
+	}
+	return nil
+}
+
+// Paths returns the paths pattern is made of, in the order they're
+// written: each fallback alternative of each pipe stage. A stage that is a
+// keyword like `keys`, or a fallback's literal default, isn't a path and
+// is left out. Pass each member of a union separately.
+func Paths(pattern string) []string {
+	var paths []string
+	for _, stage := range splitPipe(pattern) {
+		if _, ok := pipeStages[stage]; ok {
+			continue
+		}
+		alternatives := splitFallback(stage)
+		for i, alt := range alternatives {
+			if i > 0 && i == len(alternatives)-1 && fallbackLiteral(alt) != nil {
+				continue
+			}
+			paths = append(paths, alt)
+		}
+	}
+	return paths
+}
+
+// Optional splits the `?` suffix off a pattern, `spec.strategy?`, which
+// marks it as one that may match nothing without that being an error. The
+// suffix is a single `?` at the very end that isn't escaped, so a key glob
+// ending in `?` needs a second one to be optional: `node??`.
+func Optional(pattern string) (string, bool) {
+	if !strings.HasSuffix(pattern, "?") {
+		return pattern, false
+	}
+	escapes := 0
+	for i := len(pattern) - 2; i >= 0 && pattern[i] == '\\'; i-- {
+		escapes++
+	}
+	if escapes%2 == 1 {
+		return pattern, false
+	}
+	return strings.TrimRightFunc(pattern[:len(pattern)-1], unicode.IsSpace), true
+}
+
+// Shift moves a *SyntaxError found in a piece of a larger pattern, such as
+// one member of a union, to where that piece starts in the whole pattern.
+// Any other error is returned as it is.
+func Shift(err error, offset int) error {
+	var syntax *SyntaxError
+	if errors.As(err, &syntax) {
+		syntax.Offset += offset
+	}
+	return err
+}
+
+// resolve is search for a pattern that may hold fallbacks, "a.b // c.d //
+// 30": each alternative is tried in turn and the first that is present -
+// matched, and not just null - wins. A final alternative that is a literal
+// (a number, boolean, null or quoted string) is the default value itself.
+// If every alternative is missing, the last one's result stands.
+func (w *Walker) resolve(node *yaml.Node, pattern string) ([]Match, error) {
+	alternatives := splitFallback(pattern)
+	var matches []Match
+	var err error
+	for i, alt := range alternatives {
+		if i > 0 && i == len(alternatives)-1 {
+			if literal := fallbackLiteral(alt); literal != nil {
+				return []Match{{Node: literal, Detached: true}}, nil
+			}
+		}
+		matches, err = w.Search(node, alt)
+		if w.err != nil || present(matches) {
+			return matches, err
+		}
+	}
+	return matches, err
+}
+
+// present reports whether matches found something other than nulls - the
+// test a fallback alternative has to pass.
+func present(matches []Match) bool {
+	for _, m := range matches {
+		if m.Node.Kind != yaml.ScalarNode || m.Node.ShortTag() != "!!null" {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackLiteral returns the scalar a literal default like `30`, `true`
+// or `"n/a"` stands for, or nil if alt reads as a path. A quoted key can
+// still be the last alternative when written with a leading dot, `."a.b"`.
+func fallbackLiteral(alt string) *yaml.Node {
+	quoted := len(alt) >= 2 && (alt[0] == '"' || alt[0] == '\'') && alt[len(alt)-1] == alt[0]
+	if !quoted {
+		switch (&yaml.Node{Kind: yaml.ScalarNode, Value: alt}).ShortTag() {
+		case "!!int", "!!float", "!!bool", "!!null":
+		default:
+			return nil
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(alt), &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.ScalarNode {
+		return nil
+	}
+	literal := doc.Content[0]
+	literal.Style = 0 // emitted plain, quoted again only if it must be
+	return literal
+}
+
+// splitFallback splits a pattern at its top-level `//` operators.
+func splitFallback(pattern string) []string {
+	return splitTopLevel(pattern, "//")
+}
+
+// checkFallback is checkPattern for each alternative of a fallback chain.
+func checkFallback(pattern string) error {
+	alternatives, offsets := splitTopLevelAt(pattern, "//")
+	for i, alt := range alternatives {
+		if len(alternatives) > 1 && alt == "" {
+			return fmt.Errorf("empty path in fallback %q", pattern)
+		}
+		if i > 0 && i == len(alternatives)-1 && fallbackLiteral(alt) != nil {
+			continue
+		}
+		if err := CheckPath(alt); err != nil {
+			return Shift(err, offsets[i])
+		}
+	}
+	return nil
+}
+
+// SplitUnion splits a pattern at its top-level commas into the paths of a
+// union, "metadata.name, spec.replicas".
+func SplitUnion(pattern string) []string {
+	return splitTopLevel(pattern, ",")
+}
+
+// splitPipe splits a pattern at its top-level `|` into the stages of a
+// Find, "spec.containers[*] | name".
+func splitPipe(pattern string) []string {
+	return splitTopLevel(pattern, "|")
+}
+
+// splitTopLevel splits pattern at each sep that is part of the pattern's
+// own syntax, trimming the space around the pieces. A sep inside brackets,
+// groups, quotes or after a backslash belongs to the path, so "hosts[0,2]"
+// and "~^(a|b)$" stay whole.
+func splitTopLevel(pattern, sep string) []string {
+	pieces, _ := splitTopLevelAt(pattern, sep)
+	return pieces
+}
+
+// splitTopLevelAt is splitTopLevel that also returns where each piece
+// starts in pattern, after the space trimmed from it.
+func splitTopLevelAt(pattern, sep string) ([]string, []int) {
+	var pieces []string
+	var offsets []int
+	piece := func(start, end int) {
+		text := pattern[start:end]
+		pieces = append(pieces, strings.TrimSpace(text))
+		offsets = append(offsets, start+len(text)-len(strings.TrimLeftFunc(text, unicode.IsSpace)))
+	}
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(' || c == '{':
+			depth++
+		case c == ']' || c == ')' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(pattern[i:], sep):
+			piece(start, i)
+			i += len(sep) - 1
+			start = i + 1
+		}
+	}
+	piece(start, len(pattern))
+	return pieces, offsets
+}
+
+// CheckPath reports syntax errors SplitPath would otherwise paper over,
+// such as an unterminated quote.
+func CheckPath(pattern string) error {
+	parts, err := scanPath(pattern)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if isRegexPart(part) {
+			if _, err := regexp.Compile(part[1:]); err != nil {
+				return fmt.Errorf("invalid regular expression in %s: %v", part, err)
+			}
+		}
+		if IsIndexPart(part) && isZeroStep(part[1:len(part)-1]) {
+			return fmt.Errorf("slice step can't be zero in %s", part)
+		}
+	}
+	for _, part := range parts[:max(len(parts)-1, 0)] {
+		if part == "**" {
+			return fmt.Errorf("** must end the path, since the leaves it matches have nothing below them")
+		}
+	}
+	return nil
+}
+
+// isZeroStep reports whether a bracket body is a "[start:end:0]" slice,
+// which would never move.
+func isZeroStep(body string) bool {
+	fields := strings.Split(body, ":")
+	if len(fields) != 3 {
+		return false
+	}
+	for _, field := range fields[:2] {
+		if _, err := strconv.Atoi(field); err != nil && field != "" {
+			return false
+		}
+	}
+	step, err := strconv.Atoi(fields[2])
+	return err == nil && step == 0
+}
+
+// SplitPath splits a path, with no pipes or fallbacks, into its parts:
+// "users[0].name" becomes ["users", "[0]", "name"] and "a..b" becomes
+// ["a", "..", "b"]. Quoted keys and `~regexp` parts keep their dots.
+func SplitPath(pattern string) []string {
+	parts, _ := scanPath(pattern)
+	return parts
+}
+
+// scanPath splits a pattern into parts, keeping bracketed indexes, quoted
+// keys and backslash-escaped characters intact. Malformed syntax - an
+// unterminated quote, an unclosed or stray bracket, a bracket that holds
+// no index, an empty part between dots, or a dot or `..` with nothing
+// after it - is reported as the first *SyntaxError; the parts are still
+// returned as best they can be read, with an unterminated remainder as the
+// last part.
+func scanPath(pattern string) ([]string, error) {
+	var parts []string
+	var err error
+	fail := func(offset int, format string, args ...any) {
+		if err == nil {
+			err = &SyntaxError{Msg: fmt.Sprintf(format, args...), Offset: offset}
+		}
+	}
+	start := 0
+	bracketDepth := 0 // brackets nest inside filters, e.g. [?(.a[0]==x)]
+
+	for i := 0; i < len(pattern); i++ {
+		if i == start && pattern[i] == '~' && bracketDepth == 0 {
+			// A regex segment runs to the next separator of its own
+			i = scanRegex(pattern, i+1)
+			parts = append(parts, pattern[start:i])
+			if i+1 < len(pattern) && pattern[i] == '.' && pattern[i+1] == '.' {
+				parts = append(parts, "..")
+				i++
+				if i == len(pattern)-1 {
+					fail(i-1, "path ends with '..'")
+				}
+			}
+			start = i + 1
+			continue
+		}
+		switch pattern[i] {
+		case '\\':
+			// An escaped character is never a separator - `a\.b` is the
+			// single key "a.b" and `a\[0\]` the key "a[0]", not an index.
+			// KeyName strips the backslash when the key is compared. One
+			// with nothing after it to escape is a mistake.
+			if i+1 == len(pattern) {
+				fail(i, `path ends with a lone backslash; write \\ for a literal backslash`)
+			}
+			i++
+		case '"', '\'':
+			// A quote opening a segment runs to its matching close quote,
+			// so dots and brackets inside it are part of the key. Inside
+			// brackets any quote does, so a filter value like "a]b" can't
+			// close the bracket early.
+			if bracketDepth == 0 && i != start {
+				continue
+			}
+			quote, opened := pattern[i], i
+			closed := false
+			for i++; i < len(pattern); i++ {
+				if pattern[i] == '\\' {
+					i++
+				} else if pattern[i] == quote {
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				parts = append(parts, pattern[start:])
+				fail(opened, "unterminated quote in pattern")
+				return parts, err
+			}
+		case '[':
+			if bracketDepth == 0 {
+				// Add the part before the bracket if it's not empty
+				if i > start {
+					parts = append(parts, pattern[start:i])
+				}
+				start = i
+			}
+			bracketDepth++
+		case ']':
+			if bracketDepth == 0 {
+				fail(i, "stray ']'")
+				continue
+			}
+			bracketDepth--
+			if bracketDepth == 0 {
+				// Add the bracket part including the brackets
+				part := pattern[start : i+1]
+				if msg := checkBracket(part); msg != "" {
+					fail(start+1, "%s", msg)
+				}
+				parts = append(parts, part)
+				start = i + 1
+			}
+		case '.':
+			if bracketDepth == 0 {
+				// Only add if there's content between dots. A leading dot
+				// and one after a bracket are separators with nothing
+				// before them; any other dot must have a part on each side
+				if i > start {
+					parts = append(parts, pattern[start:i])
+				} else if i > 0 && pattern[i-1] == '.' {
+					fail(i, "empty path segment")
+				}
+				if i == len(pattern)-1 && i > 0 {
+					fail(i, "path ends with '.'")
+				}
+				// A doubled dot is the recursive descent operator, which
+				// needs something after it to look for
+				if i+1 < len(pattern) && pattern[i+1] == '.' {
+					parts = append(parts, "..")
+					i++
+					if i == len(pattern)-1 {
+						fail(i-1, "path ends with '..'")
+					}
+				}
+				start = i + 1
+			}
+		}
+	}
+
+	if bracketDepth > 0 {
+		fail(start, "unclosed '['")
+	}
+	// Add any remaining part if it's not empty
+	if start < len(pattern) {
+		parts = append(parts, pattern[start:])
+	}
+
+	return parts, err
+}
+
+// checkBracket describes what's wrong with a bracketed part that isn't one
+// the walker reads - an index, `*`, `first` or `last`, a slice, an index
+// list, a filter, a `key=value` selection or a quoted key - or returns "".
+func checkBracket(part string) string {
+	body := part[1 : len(part)-1]
+	if body == "" {
+		return "empty []"
+	}
+	if _, ok := BracketKey(part); ok {
+		return ""
+	}
+	if strings.HasPrefix(body, "?(") {
+		if _, ok := parseFilter(body); !ok {
+			return fmt.Sprintf("invalid filter %q", body)
+		}
+		return ""
+	}
+	if _, ok := parseSelect(part); ok {
+		return ""
+	}
+	valid := true
+	switch {
+	case body == "*" || body == "first" || body == "last":
+	case strings.Contains(body, ","):
+		for _, field := range strings.Split(body, ",") {
+			if _, err := strconv.Atoi(strings.TrimSpace(field)); err != nil {
+				valid = false
+			}
+		}
+	case strings.Contains(body, ":"):
+		fields := strings.Split(body, ":")
+		valid = len(fields) <= 3
+		for _, field := range fields {
+			if _, err := strconv.Atoi(field); err != nil && field != "" {
+				valid = false
+			}
+		}
+	default:
+		_, err := strconv.Atoi(body)
+		valid = err == nil
+	}
+	if !valid {
+		return fmt.Sprintf("invalid index %q", body)
+	}
+	return ""
+}
+
+// scanRegex returns the end of a `~regexp` segment starting at i: the next
+// dot that's outside any group or character class and isn't the regexp's
+// own `.*`, `.+`, `.?` or `.{n}`, or the end of the pattern.
+func scanRegex(pattern string, i int) int {
+	groupDepth, inClass := 0, false
+	for ; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '(':
+			groupDepth++
+		case c == ')':
+			groupDepth--
+		case c == '.' && groupDepth == 0:
+			if i+1 < len(pattern) && strings.IndexByte("*+?{", pattern[i+1]) >= 0 {
+				continue
+			}
+			return i
+		}
+	}
+	return min(i, len(pattern))
+}
+
+// EndsInLeaves reports whether pattern's last stage ends in `**`.
+func EndsInLeaves(pattern string) bool {
+	stages := splitPipe(pattern)
+	alternatives := splitFallback(stages[len(stages)-1])
+	parts := SplitPath(alternatives[len(alternatives)-1])
+	return len(parts) > 0 && parts[len(parts)-1] == "**"
+}
+
+// Singular reports whether pattern can only ever match one node: every
+// part is a key or a plain index, in each stage of a pipe and each of its
+// fallback alternatives.
+func Singular(pattern string) bool {
+	for _, stage := range splitPipe(pattern) {
+		if _, ok := pipeStages[stage]; ok {
+			continue
+		}
+		for _, alt := range splitFallback(stage) {
+			if fallbackLiteral(alt) != nil {
+				continue
+			}
+			for _, part := range SplitPath(alt) {
+				if part == ".." || part == "*" || part == "**" || part == "&*" || isRegexPart(part) || IsGlobPart(part) {
+					return false
+				}
+				if !IsIndexPart(part) || part == "[first]" || part == "[last]" {
+					continue
+				}
+				if _, ok := BracketKey(part); ok {
+					continue
+				}
+				if _, err := strconv.Atoi(part[1 : len(part)-1]); err != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,959 @@
+// The walker: how a split pattern is followed through a node tree, part by
+// part, collecting every node it leads to along with its concrete path.
+
+package yamlpath
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	pathpkg "path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Match is a single node found by a pattern, along with the concrete path
+// parts leading to it from the root - wildcards resolved to the real keys
+// they matched - so it can be wrapped back into its real location.
+type Match struct {
+	Node *yaml.Node
+	Path []string
+	// Detached marks a value that isn't in the document - a fallback
+	// literal, or one computed by a pipe stage like `keys` - so it has no
+	// path to be wrapped in and is printed as it is.
+	Detached bool
+}
+
+// Search is Find for a plain path, without pipes or fallbacks. A leading
+// dot needs no special handling: SplitPath drops it like any other
+// separator, while a leading ".." still reads as recursive descent.
+//
+// It reports errors always for a Strict violation, and otherwise only to
+// explain an empty result: a *PathError for the first part that failed,
+// preferring one that can't apply to the node it reached, such as a
+// `[key=value]` selection landing on a mapping, over a key or index that
+// just isn't there.
+func (w *Walker) Search(node *yaml.Node, pattern string) ([]Match, error) {
+	w.matches, w.misuse, w.miss, w.err, w.seen = nil, nil, nil, nil, nil
+	if w.base == nil {
+		w.root = node
+	}
+	w.walk(node, SplitPath(pattern), w.base)
+	if w.err != nil {
+		return nil, w.err
+	}
+	if len(w.matches) == 0 && w.misuse != nil {
+		return nil, w.misuse
+	}
+	if len(w.matches) == 0 && w.miss != nil {
+		return nil, w.miss
+	}
+	return Dedupe(w.matches), nil
+}
+
+// Dedupe drops repeat matches of the same node at the same path,
+// keeping the first (document-order) one. Recursive descent can reach a node
+// more than once, e.g. `..a..b` finds a nested `b` from each enclosing `a`.
+// The same node at another path - through an alias or a merge key - is a
+// match of its own.
+func Dedupe(matches []Match) []Match {
+	seen := make(map[seenMatch]bool, len(matches))
+	unique := matches[:0]
+	for _, m := range matches {
+		key := matchKey(m)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, m)
+	}
+	return unique
+}
+
+// seenMatch identifies a match for Dedupe: the node, and the path it was
+// found at.
+type seenMatch struct {
+	node *yaml.Node
+	path string
+}
+
+func matchKey(m Match) seenMatch {
+	return seenMatch{m.Node, strings.Join(m.Path, "\x00")}
+}
+
+// WalkParts walks node following pre-split path parts and returns the first
+// node they lead to, or nil. Wrap uses it to look up an ancestor's
+// original node (and thus its original flow/block style) when
+// reconstructing it.
+func WalkParts(node *yaml.Node, parts []string) *yaml.Node {
+	var w Walker
+	w.walk(node, parts, nil)
+	if len(w.matches) == 0 {
+		return nil
+	}
+	return w.matches[0].Node
+}
+
+// Walker searches node trees, collecting the matches of one search at a
+// time. The zero Walker is ready to use; its exported fields change how
+// patterns match.
+type Walker struct {
+	// Strict turns out-of-range entries in an index list like "[0,3,7]"
+	// into an error instead of skipping them.
+	Strict bool
+	// IgnoreCase matches keys, and `~regexp` parts, regardless of case.
+	IgnoreCase bool
+	// NoMerge leaves `<<` merge keys as plain entries instead of making the
+	// merged mappings' keys part of the mapping that merges them.
+	NoMerge bool
+	// KeepAlias matches an alias at the end of a path as itself rather than
+	// the node it refers to, so an edit changes the entry, not the anchor.
+	KeepAlias bool
+	// Limit stops a search once it has found that many matches, leaving
+	// the rest of the tree unvisited. Matches are found in document order,
+	// so they're always the first ones. Zero means no limit.
+	Limit int
+	// Trace, if set, is sent a line for each step of a search: the part
+	// applied, what kind of step it is and the node it reached, every
+	// match, and why a part found nothing. It's meant for people debugging
+	// a pattern; the wording may change.
+	Trace io.Writer
+
+	matches []Match
+	// seen holds the matches found so far under a Limit, so a node that
+	// recursive descent reaches twice only counts once
+	seen map[seenMatch]bool
+	err  error
+	// misuse records the first part that was applied to a node it can't
+	// select from, to explain an otherwise silent miss. miss records the
+	// first key or index that wasn't there, for when there's no misuse.
+	misuse error
+	miss   error
+	// recursive is non-zero under `..`, where parts are tried against
+	// every node and most of them are expected not to fit.
+	recursive int
+	// regexps caches compiled `~regexp` parts, which recursive descent
+	// would otherwise recompile at every node.
+	regexps map[string]*regexp.Regexp
+	// root is the node paths are concrete from, where a `^` part looks up
+	// the parent it steps back to. A pipe stage searches from a match below
+	// it, whose path is base.
+	root *yaml.Node
+	base []string
+}
+
+// add records a match. Under a Limit a repeat of one already found is
+// dropped here, so it doesn't use up the limit.
+func (w *Walker) add(m Match) {
+	if w.Limit > 0 {
+		key := matchKey(m)
+		if w.seen[key] {
+			return
+		}
+		if w.seen == nil {
+			w.seen = make(map[seenMatch]bool)
+		}
+		w.seen[key] = true
+	}
+	w.tracef(m.Path, "match at %s (%s)", DescribePath(m.Path), KindName(m.Node))
+	w.matches = append(w.matches, m)
+}
+
+// tracef writes a line to Trace, indented by how deep path is.
+func (w *Walker) tracef(path []string, format string, args ...any) {
+	if w.Trace != nil {
+		fmt.Fprintf(w.Trace, "%s%s\n", strings.Repeat("  ", len(path)), fmt.Sprintf(format, args...))
+	}
+}
+
+// describePart names the kind of step a part takes, for Trace.
+func describePart(part string) string {
+	switch {
+	case part == "..":
+		return "recursive descent"
+	case part == "**":
+		return "leaves"
+	case part == "^":
+		return "parent"
+	case IsAnchorPart(part):
+		return "anchor"
+	case part == "*" || part == "[*]":
+		return "wildcard"
+	case isRegexPart(part):
+		return "regexp"
+	case IsGlobPart(part):
+		return "glob"
+	}
+	if _, ok := BracketKey(part); ok || !IsIndexPart(part) {
+		return "key"
+	}
+	body := part[1 : len(part)-1]
+	if _, ok := parseFilter(body); ok {
+		return "filter"
+	}
+	if _, ok := parseSelect(part); ok {
+		return "filter"
+	}
+	switch {
+	case strings.Contains(body, ","):
+		return "index list"
+	case strings.Contains(body, ":"):
+		return "slice"
+	}
+	return "index"
+}
+
+// full reports whether a search has found all the matches its Limit asks
+// for, so the walk can stop.
+func (w *Walker) full() bool {
+	return w.Limit > 0 && len(w.matches) >= w.Limit
+}
+
+// regexp compiles a `~regexp` part's expression, once per walker. An
+// invalid one matches nothing; checkPattern rejects those up front.
+func (w *Walker) regexp(expr string) *regexp.Regexp {
+	if re, ok := w.regexps[expr]; ok {
+		return re
+	}
+	flags := ""
+	if w.IgnoreCase {
+		flags = "(?i)"
+	}
+	re, _ := regexp.Compile(flags + expr)
+	if w.regexps == nil {
+		w.regexps = make(map[string]*regexp.Regexp)
+	}
+	w.regexps[expr] = re
+	return re
+}
+
+// walk walks node following pre-split path parts, collecting every node
+// they lead to. A `*` part fans out over every key of a mapping, `[*]` over
+// every element of a sequence and `..` over every descendant, and `^` goes
+// back up to the parent; path holds
+// the concrete parts taken so far. A trailing `[start:end]` slice matches a
+// new sub-sequence, not the elements.
+func (w *Walker) walk(node *yaml.Node, parts []string, path []string) {
+	if node == nil || w.full() {
+		return
+	}
+	// An alias stands for the node it refers to. Every step through one
+	// consumes a part, so even an alias inside its own anchor can't loop.
+	if node.Kind == yaml.AliasNode && node.Alias != nil && !(w.KeepAlias && len(parts) == 0) {
+		if len(parts) > 0 {
+			w.walk(node.Alias, parts, path)
+			return
+		}
+		// Matched here, the node is printed without its anchor, which
+		// belongs to where it's defined
+		target := *node.Alias
+		target.Anchor = ""
+		node = &target
+	}
+	if len(parts) == 0 {
+		w.add(Match{Node: node, Path: append([]string(nil), path...)})
+		return
+	}
+
+	part := parts[0]
+	if node.Kind != yaml.DocumentNode {
+		w.tracef(path, "%s %s at %s (%s)", describePart(part), part, DescribePath(path), KindName(node))
+	}
+	if part == ".." && node.Kind != yaml.DocumentNode {
+		w.walkRecursive(node, parts[1:], path)
+		return
+	}
+	// "&name" jumps to the node carrying that anchor, wherever it is, and
+	// "&*" to every anchored node
+	if IsAnchorPart(part) {
+		w.walkAnchors(part[1:], parts[1:])
+		return
+	}
+	// "**" fans out over every leaf below: each scalar, and each empty
+	// mapping or sequence
+	if part == "**" {
+		w.walkLeaves(node, parts[1:], path, map[*yaml.Node]bool{})
+		return
+	}
+	// "^" steps back to the parent. Nodes don't link to their parents, so
+	// it's looked up again from the root along the path so far.
+	if part == "^" {
+		if len(path) == 0 || w.root == nil {
+			if w.recursive == 0 {
+				w.note(errors.New("^ steps above the document root"))
+			}
+			return
+		}
+		up := path[: len(path)-1 : len(path)-1]
+		w.walk(WalkParts(w.root, up), parts[1:], up)
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		// Descend into the document's root node, reprocessing the same part
+		if len(node.Content) > 0 {
+			w.walk(node.Content[0], parts, path)
+		}
+	case yaml.MappingNode:
+		// "[2023]" only ever indexes a sequence; the key is "2023"
+		if _, ok := indexInt(part); ok {
+			w.fail(ErrKindMismatch, part, path, "%s indexes a sequence, but %s is a mapping; write %s for the key %s", part, DescribePath(path), FormatPath(append(path[:len(path):len(path)], part[1:len(part)-1])), part[1:len(part)-1])
+			return
+		}
+		if sequenceOnly(part) {
+			w.misused(part, node, path)
+			return
+		}
+		content := node.Content
+		if !w.NoMerge {
+			content = MergedContent(node)
+		}
+		// "~regexp" fans out over every key the expression matches
+		if isRegexPart(part) {
+			re := w.regexp(part[1:])
+			if re == nil {
+				return
+			}
+			for i := 0; i+1 < len(content); i += 2 {
+				if key := content[i].Value; re.MatchString(key) {
+					w.walk(content[i+1], parts[1:], append(path, EscapeKey(key)))
+				}
+			}
+			return
+		}
+		// "env_*" globs match keys the way path.Match does; plain keys
+		// skip it and are compared directly
+		if IsGlobPart(part) {
+			glob := part
+			if w.IgnoreCase {
+				glob = strings.ToLower(glob)
+			}
+			for i := 0; i+1 < len(content); i += 2 {
+				key := content[i].Value
+				if w.IgnoreCase {
+					key = strings.ToLower(key)
+				}
+				if ok, _ := pathpkg.Match(glob, key); ok {
+					w.walk(content[i+1], parts[1:], append(path, EscapeKey(content[i].Value)))
+				}
+			}
+			return
+		}
+		want, ok := BracketKey(part)
+		if !ok {
+			want = KeyName(part)
+		}
+		// A plain number matches the key written that way, or failing that
+		// an integer key with the same value, such as 0x10 for 16
+		if n, ok := plainInt(part); ok {
+			want = intKey(content, want, n)
+		}
+		// A bare boolean word does the same for YAML 1.1 booleans, so
+		// `on.push` finds a workflow's `on:` key however it was written
+		if b, ok := plainBool(part); ok {
+			want = boolKey(content, want, b)
+		}
+		if w.IgnoreCase && part != "*" {
+			w.walkFolded(content, want, parts[1:], path)
+			return
+		}
+		for i := 0; i+1 < len(content); i += 2 {
+			key := content[i].Value
+			if part == "*" {
+				w.walk(content[i+1], parts[1:], append(path, EscapeKey(key)))
+			} else if key == want {
+				w.walk(content[i+1], parts[1:], append(path, EscapeKey(key)))
+				return
+			}
+		}
+		if part != "*" {
+			w.fail(ErrKeyNotFound, part, path, "%s has no key %s", DescribePath(path), part)
+		}
+	case yaml.SequenceNode:
+		// A plain number indexes a sequence as its bracketed form does
+		if _, ok := plainInt(part); ok {
+			part = "[" + part + "]"
+		}
+		if !IsIndexPart(part) {
+			if keyPart(part) {
+				w.fail(ErrKindMismatch, part, path, "%s looks up a key, but %s is %s", part, DescribePath(path), KindName(node))
+			}
+			return
+		}
+		// "[*]" fans out over every element, in order
+		if part == "[*]" {
+			for i, item := range node.Content {
+				w.walk(item, parts[1:], append(path, "["+strconv.Itoa(i)+"]"))
+			}
+			return
+		}
+		// "[?(.field==value)]" and its "[field=value]" shorthand keep the
+		// elements matching a filter
+		body := part[1 : len(part)-1]
+		f, ok := parseFilter(body)
+		if !ok {
+			f, ok = parseSelect(part)
+		}
+		if ok {
+			for i, item := range node.Content {
+				if f.matches(item) {
+					w.walk(item, parts[1:], append(path, "["+strconv.Itoa(i)+"]"))
+				}
+			}
+			return
+		}
+		// "[0,3,7]" picks several elements, in the listed order
+		if strings.Contains(body, ",") {
+			indexes, ok := w.parseIndexList(body, len(node.Content), path)
+			if !ok {
+				return
+			}
+			if len(parts) > 1 {
+				for _, i := range indexes {
+					w.walk(node.Content[i], parts[1:], append(path, "["+strconv.Itoa(i)+"]"))
+				}
+				return
+			}
+			// Like a trailing slice, the picked elements become a new
+			// sequence standing in for the original one
+			picked := &yaml.Node{Kind: yaml.SequenceNode, Tag: node.Tag, Style: node.Style}
+			for _, i := range indexes {
+				picked.Content = append(picked.Content, node.Content[i])
+			}
+			w.add(Match{Node: picked, Path: append([]string(nil), path...)})
+			return
+		}
+		// "[start:end:step]" selects a range of elements
+		if strings.Contains(body, ":") {
+			indexes, ok := parseSlice(body, len(node.Content))
+			if !ok {
+				return
+			}
+			if len(parts) > 1 {
+				// More path follows - apply it to each selected element
+				for _, i := range indexes {
+					w.walk(node.Content[i], parts[1:], append(path, "["+strconv.Itoa(i)+"]"))
+				}
+				return
+			}
+			// A trailing slice is itself the result: a new sequence standing
+			// in for the original one at the same path, so wrapping places it
+			// directly under the original key.
+			slice := &yaml.Node{Kind: yaml.SequenceNode, Tag: node.Tag, Style: node.Style}
+			for _, i := range indexes {
+				slice.Content = append(slice.Content, node.Content[i])
+			}
+			w.add(Match{Node: slice, Path: append([]string(nil), path...)})
+			return
+		}
+		// Array access - parse "[0]" into integer. Negative indexes count
+		// back from the end, so "[-1]" is the last element; "[first]" and
+		// "[last]" name the ends outright.
+		var index int
+		switch body {
+		case "first", "last":
+			if len(node.Content) == 0 {
+				w.fail(ErrIndexOutOfRange, part, path, "%s needs a non-empty sequence, but %s has no elements", part, DescribePath(path))
+				return
+			}
+			if body == "last" {
+				index = len(node.Content) - 1
+			}
+		default:
+			var err error
+			if index, err = strconv.Atoi(body); err != nil {
+				if keyPart(part) {
+					w.fail(ErrKindMismatch, part, path, "%s looks up a key, but %s is %s", part, DescribePath(path), KindName(node))
+				}
+				return
+			}
+		}
+		if index < 0 {
+			index += len(node.Content)
+		}
+		if index < 0 || index >= len(node.Content) {
+			w.fail(ErrIndexOutOfRange, part, path, "%s is out of range for %s (length %d)", part, DescribePath(path), len(node.Content))
+			return
+		}
+		// Record the resolved index so wrapping rebuilds the real position
+		w.walk(node.Content[index], parts[1:], append(path, "["+strconv.Itoa(index)+"]"))
+	default:
+		// null has no keys or elements, the way an empty collection has
+		// none; any other scalar has no keys or elements to have
+		_, index := indexInt(part)
+		switch {
+		case node.ShortTag() == "!!null" && index:
+			w.fail(ErrIndexOutOfRange, part, path, "%s is out of range for %s, which is null", part, DescribePath(path))
+		case node.ShortTag() == "!!null" && keyPart(part):
+			w.fail(ErrKeyNotFound, part, path, "%s has no key %s, since it's null", DescribePath(path), part)
+		case node.ShortTag() == "!!null":
+		case sequenceOnly(part):
+			w.misused(part, node, path)
+		case keyPart(part):
+			w.fail(ErrKindMismatch, part, path, "%s looks up a key, but %s is %s", part, DescribePath(path), KindName(node))
+		}
+	}
+}
+
+// misused records that a sequence-only part reached a node of another kind.
+func (w *Walker) misused(part string, node *yaml.Node, path []string) {
+	w.fail(ErrKindMismatch, part, path, "%s selects from a sequence, but %s is %s", part, DescribePath(path), KindName(node))
+}
+
+// fail records why part, applied to the node at path, found nothing: a kind
+// mismatch as the walker's misuse, and a missing key or index as its miss.
+// Like note, it ignores parts tried under `..`.
+func (w *Walker) fail(reason error, part string, path []string, format string, args ...any) {
+	err := &PathError{Part: part, Path: append([]string(nil), path...), Err: reason, msg: fmt.Sprintf(format, args...)}
+	if w.recursive == 0 {
+		w.tracef(path, "no match: %s", err.msg)
+	}
+	switch {
+	case reason == ErrKindMismatch:
+		w.note(err)
+	case w.miss == nil && w.recursive == 0:
+		w.miss = err
+	}
+}
+
+// note keeps the first explanation for a part that couldn't apply, unless
+// it came up under `..`.
+func (w *Walker) note(err error) {
+	if w.misuse == nil && w.recursive == 0 {
+		w.misuse = err
+	}
+}
+
+// sequenceOnly reports whether a part can only select from a sequence, so
+// reaching anything else is worth explaining: any bracketed part other than
+// a quoted key, such as "[*]", "[1:3]", "[key=value]" or "[first]".
+func sequenceOnly(part string) bool {
+	if _, ok := BracketKey(part); ok {
+		return false
+	}
+	return IsIndexPart(part)
+}
+
+// keyPart reports whether a part names a single mapping key, as opposed
+// to a wildcard, glob or `~regexp` over several, or an index.
+func keyPart(part string) bool {
+	if _, ok := BracketKey(part); ok {
+		return true
+	}
+	return !IsIndexPart(part) && part != "*" && !IsGlobPart(part) && !isRegexPart(part)
+}
+
+// DescribePath is FormatPath for messages, naming the root explicitly.
+func DescribePath(parts []string) string {
+	if len(parts) == 0 {
+		return "the document root"
+	}
+	return FormatPath(parts)
+}
+
+// FormatPath joins concrete path parts back into a pattern, e.g.
+// ["users", "[0]", "name"] becomes "users[0].name".
+func FormatPath(parts []string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		if b.Len() > 0 && !IsIndexPart(part) {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// walkAnchors continues the walk from the anchored node(s) name refers to,
+// each at its real path. An anchor defined twice is ambiguous, so it's an
+// error rather than a guess.
+func (w *Walker) walkAnchors(name string, parts []string) {
+	var found []Match
+	var find func(node *yaml.Node, path []string)
+	find = func(node *yaml.Node, path []string) {
+		if node.Kind == yaml.AliasNode {
+			return
+		}
+		if node.Anchor != "" && (name == "*" || node.Anchor == name) {
+			found = append(found, Match{Node: node, Path: append([]string(nil), path...)})
+		}
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				find(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				find(node.Content[i+1], append(path, EscapeKey(node.Content[i].Value)))
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				find(item, append(path, "["+strconv.Itoa(i)+"]"))
+			}
+		}
+	}
+	if w.root != nil {
+		find(w.root, nil)
+	}
+	if name != "*" && len(found) > 1 {
+		w.err = fmt.Errorf("anchor &%s is defined more than once (lines %d and %d)", name, found[0].Node.Line, found[1].Node.Line)
+		return
+	}
+	for _, m := range found {
+		w.walk(m.Node, parts, m.Path)
+	}
+}
+
+// walkFolded follows every key of a mapping's content that equals want
+// under case folding, in document order, for IgnoreCase.
+func (w *Walker) walkFolded(content []*yaml.Node, want string, parts []string, path []string) {
+	for i := 0; i+1 < len(content); i += 2 {
+		if key := content[i].Value; strings.EqualFold(key, want) {
+			w.walk(content[i+1], parts, append(path, EscapeKey(key)))
+		}
+	}
+}
+
+// MergedContent returns a mapping's key/value pairs with each `<<` merge
+// key replaced by the entries of the mapping(s) it merges, as YAML's merge
+// key type defines: the mapping's own keys win, then earlier merged
+// mappings over later ones. A mapping without merge keys is returned as is.
+func MergedContent(node *yaml.Node) []*yaml.Node {
+	return expandMerges(node, map[*yaml.Node]bool{})
+}
+
+// expandMerges is mergedContent, with seen holding the mappings already
+// being expanded so a mapping merging itself can't recurse forever.
+func expandMerges(node *yaml.Node, seen map[*yaml.Node]bool) []*yaml.Node {
+	own := make(map[string]bool)
+	merges := false
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if IsMergeKey(node.Content[i]) {
+			merges = true
+		} else {
+			own[node.Content[i].Value] = true
+		}
+	}
+	if !merges {
+		return node.Content
+	}
+	if seen[node] {
+		return nil
+	}
+	seen[node] = true
+	defer delete(seen, node)
+
+	content := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !IsMergeKey(key) {
+			content = append(content, key, value)
+			continue
+		}
+		// `<<: *a` merges one mapping, `<<: [*a, *b]` several
+		sources := []*yaml.Node{value}
+		if resolveAlias(value).Kind == yaml.SequenceNode {
+			sources = resolveAlias(value).Content
+		}
+		for _, source := range sources {
+			if source = resolveAlias(source); source.Kind != yaml.MappingNode {
+				continue
+			}
+			merged := expandMerges(source, seen)
+			for j := 0; j+1 < len(merged); j += 2 {
+				if k := merged[j].Value; !own[k] {
+					own[k] = true
+					content = append(content, merged[j], merged[j+1])
+				}
+			}
+		}
+	}
+	return content
+}
+
+// IsMergeKey reports whether key is a `<<` merge key. A quoted "<<" is an
+// ordinary string key.
+func IsMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+// resolveAlias returns the node an alias refers to, or node itself.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		return node.Alias
+	}
+	return node
+}
+
+// walkLeaves applies the remaining parts to every leaf at or below node,
+// depth-first in document order - the `**` operator. Unlike `..` it follows
+// aliases and merge keys, so it sees the document the way its consumer
+// does; following stops at an alias already being followed.
+func (w *Walker) walkLeaves(node *yaml.Node, parts []string, path []string, following map[*yaml.Node]bool) {
+	if w.full() {
+		return
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			w.walkLeaves(node.Content[0], parts, path, following)
+		}
+	case yaml.AliasNode:
+		if node.Alias == nil || following[node] {
+			return
+		}
+		following[node] = true
+		w.walkLeaves(node.Alias, parts, path, following)
+		delete(following, node)
+	case yaml.MappingNode:
+		content := node.Content
+		if !w.NoMerge {
+			content = MergedContent(node)
+		}
+		if len(content) == 0 {
+			w.walk(node, parts, path)
+		}
+		for i := 0; i+1 < len(content); i += 2 {
+			w.walkLeaves(content[i+1], parts, append(path, EscapeKey(content[i].Value)), following)
+		}
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			w.walk(node, parts, path)
+		}
+		for i, item := range node.Content {
+			w.walkLeaves(item, parts, append(path, "["+strconv.Itoa(i)+"]"), following)
+		}
+	default:
+		w.walk(node, parts, path)
+	}
+}
+
+// walkRecursive applies the remaining parts to node and to every one of its
+// descendants, depth-first in document order - the `..` operator. Aliases
+// and merge keys are skipped, so a node is found where it's defined rather
+// than again through every reference to it, and an alias pointing back at
+// one of its own ancestors can't send the search into an infinite loop.
+func (w *Walker) walkRecursive(node *yaml.Node, parts []string, path []string) {
+	if node.Kind == yaml.AliasNode || w.full() {
+		return
+	}
+	w.recursive++
+	defer func() { w.recursive-- }()
+	w.walk(node, parts, path)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if IsMergeKey(node.Content[i]) {
+				continue
+			}
+			w.walkRecursive(node.Content[i+1], parts, append(path, EscapeKey(node.Content[i].Value)))
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			w.walkRecursive(item, parts, append(path, "["+strconv.Itoa(i)+"]"))
+		}
+	}
+}
+
+// filter is a predicate on sequence elements, from a "[?(...)]" part:
+// `.path` alone tests that path exists in the element, `.path==value` and
+// `.path!=value` compare the scalar found there against value.
+type filter struct {
+	path  string
+	op    string // "", "==" or "!="
+	value string
+}
+
+// parseFilter parses the body of a "[?(...)]" part. The left-hand side is a
+// path relative to the element (a leading `@` is accepted for JSONPath
+// familiarity); the right-hand side is a literal, optionally quoted.
+func parseFilter(body string) (filter, bool) {
+	if !strings.HasPrefix(body, "?(") || !strings.HasSuffix(body, ")") {
+		return filter{}, false
+	}
+	expr := strings.TrimSpace(body[2 : len(body)-1])
+	var f filter
+	if i := indexOperator(expr); i >= 0 {
+		f.op = expr[i : i+2]
+		f.value = KeyName(strings.TrimSpace(expr[i+2:]))
+		expr = strings.TrimSpace(expr[:i])
+	}
+	f.path = strings.TrimPrefix(expr, "@")
+	if f.path == "" {
+		return filter{}, false
+	}
+	return f, true
+}
+
+// parseSelect parses a "[key=value]" part, shorthand for
+// "[?(.key==value)]". The value is taken literally, quotes and all, so it
+// never needs quoting; the key is a path relative to the element.
+func parseSelect(part string) (filter, bool) {
+	if !IsIndexPart(part) {
+		return filter{}, false
+	}
+	body := part[1 : len(part)-1]
+	if strings.HasPrefix(body, "?(") || indexOperator(body) >= 0 {
+		return filter{}, false
+	}
+	i := indexEquals(body)
+	if i <= 0 {
+		return filter{}, false
+	}
+	return filter{path: body[:i], op: "==", value: body[i+1:]}, true
+}
+
+// indexEquals finds the first = in s that isn't inside quotes or escaped.
+func indexEquals(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOperator finds the first == or != in expr that isn't inside quotes.
+func indexOperator(expr string) int {
+	var quote byte
+	for i := 0; i+1 < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case (c == '=' || c == '!') && expr[i+1] == '=':
+			return i
+		}
+	}
+	return -1
+}
+
+// matches reports whether a sequence element satisfies the filter. For `!=`
+// an element without the field counts as different, so it's kept.
+func (f filter) matches(item *yaml.Node) bool {
+	var w Walker
+	found, _ := w.Search(item, f.path)
+	switch f.op {
+	case "==":
+		for _, m := range found {
+			if m.Node.Kind == yaml.ScalarNode && m.Node.Value == f.value {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		for _, m := range found {
+			if m.Node.Kind == yaml.ScalarNode && m.Node.Value == f.value {
+				return false
+			}
+		}
+		return true
+	}
+	return len(found) > 0
+}
+
+// parseIndexList parses the body of a "[0,3,7]" part against a sequence of
+// the given length, resolving negative indexes. Out-of-range entries are
+// dropped, or recorded as an error under Strict.
+func (w *Walker) parseIndexList(body string, length int, path []string) ([]int, bool) {
+	var indexes []int
+	for _, field := range strings.Split(body, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, false
+		}
+		resolved := index
+		if resolved < 0 {
+			resolved += length
+		}
+		if resolved < 0 || resolved >= length {
+			if w.Strict && w.err == nil {
+				w.err = fmt.Errorf("index %d out of range for %s (length %d)", index, FormatPath(path), length)
+			}
+			continue
+		}
+		indexes = append(indexes, resolved)
+	}
+	return indexes, true
+}
+
+// parseSlice parses the body of a "[start:end:step]" part against a
+// sequence of the given length and returns the indexes it selects, in
+// order. Either bound may be omitted ("[:3]", "[2:]", "[:]") and negative
+// bounds count back from the end like negative indexes do ("[-2:]" is the
+// last two elements). Bounds past either end are clamped rather than
+// treated as errors, like Python slices. The optional step takes every
+// step-th element ("[::10]"); a negative one walks backwards from the end,
+// so "[::-1]" reverses the sequence. A zero step selects nothing, and
+// CheckPath rejects it up front.
+func parseSlice(body string, length int) ([]int, bool) {
+	fields := strings.Split(body, ":")
+	if len(fields) > 3 {
+		return nil, false
+	}
+	var bounds [3]*int
+	for i, field := range fields {
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, false
+		}
+		bounds[i] = &n
+	}
+	step := 1
+	if bounds[2] != nil {
+		step = *bounds[2]
+	}
+	if step == 0 {
+		return nil, false
+	}
+
+	// Clamp the way Python's slice.indices does: a backwards slice may run
+	// down to just before the first element
+	lower, upper := 0, length
+	if step < 0 {
+		lower, upper = -1, length-1
+	}
+	clamp := func(bound *int, omitted int) int {
+		if bound == nil {
+			return omitted
+		}
+		n := *bound
+		if n < 0 {
+			n += length
+		}
+		return min(max(n, lower), upper)
+	}
+	start, end := clamp(bounds[0], lower), clamp(bounds[1], upper)
+	if step < 0 {
+		start, end = clamp(bounds[0], upper), clamp(bounds[1], lower)
+	}
+
+	var indexes []int
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		indexes = append(indexes, i)
+	}
+	return indexes, true
+}
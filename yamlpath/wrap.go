@@ -0,0 +1,167 @@
+// Wrapping: rebuilding the ancestry of the matches, so what a pattern
+// extracts can be printed where it lives in the source document.
+
+package yamlpath
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Wrap rebuilds the ancestry of every match as a single tree. Matches
+// sharing a path prefix (e.g. every service found by `services.*.image`)
+// become siblings under one rebuilt ancestor, so the output reads as a
+// trimmed-down copy of the source document rather than a pile of fragments.
+func Wrap(root *yaml.Node, matches []Match) *yaml.Node {
+	return wrapGroup(root, matches, 0, false)
+}
+
+// WrapNullFill is Wrap, but a rebuilt sequence keeps each matched element
+// at its original index, with a null standing in for every element before
+// it that wasn't matched. `items[3]` becomes `[null, null, null, x]`
+// rather than `[x]`: the index survives, at the cost of entries that look
+// like part of the document but aren't.
+func WrapNullFill(root *yaml.Node, matches []Match) *yaml.Node {
+	return wrapGroup(root, matches, 0, true)
+}
+
+// localTag is node's tag if it's a custom one, such as CloudFormation's
+// !If, written as it was; a rebuilt ancestor keeps it, so it still reads
+// as what it was. A standard tag is left for the encoder to work out.
+func localTag(node *yaml.Node) string {
+	if strings.HasPrefix(node.ShortTag(), "!!") {
+		return ""
+	}
+	return node.Tag
+}
+
+// wrapGroup builds the node at the given depth for matches that all share
+// the same concrete path up to that depth, building the tree from the top
+// down. With nullFill, sequences are padded with nulls as WrapNullFill
+// describes.
+func wrapGroup(root *yaml.Node, matches []Match, depth int, nullFill bool) *yaml.Node {
+	for _, m := range matches {
+		if len(m.Path) == depth {
+			// The match itself lives here; anything deeper is already inside it.
+			return m.Node
+		}
+	}
+
+	// The node being reconstructed at this level originally lived at
+	// path[:depth] in the source document - look it up so the rebuilt
+	// wrapper matches its original flow/block style (e.g. so extracting
+	// from a JSON source keeps looking like JSON) instead of always
+	// defaulting to block style.
+	parent := ancestorNodeAt(root, matches[0].Path[:depth])
+
+	// Group matches by their next path part, keeping first-seen (document) order
+	var order []string
+	groups := make(map[string][]Match)
+	for _, m := range matches {
+		part := m.Path[depth]
+		if _, seen := groups[part]; !seen {
+			order = append(order, part)
+		}
+		groups[part] = append(groups[part], m)
+	}
+
+	if IsIndexPart(order[0]) {
+		// One element per matched index. The original indexes aren't
+		// reconstructed unless asked for - gy doesn't know what the skipped
+		// elements were, so padding with `null` implies entries that don't
+		// exist in the source document.
+		seqNode := &yaml.Node{Kind: yaml.SequenceNode}
+		if parent != nil {
+			seqNode.Style, seqNode.Tag = parent.Style, localTag(parent)
+			seqNode.HeadComment, seqNode.LineComment = parent.HeadComment, parent.LineComment
+		}
+		indexes, ok := partIndexes(order)
+		for i, part := range order {
+			child := wrapGroup(root, groups[part], depth+1, nullFill)
+			if !nullFill || !ok {
+				seqNode.Content = append(seqNode.Content, child)
+				continue
+			}
+			for len(seqNode.Content) <= indexes[i] {
+				seqNode.Content = append(seqNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"})
+			}
+			seqNode.Content[indexes[i]] = child
+		}
+		return seqNode
+	}
+
+	// A rebuilt collection keeps the comments above and beside it, such as
+	// one on the sequence element it was; its foot comment follows
+	// entries that may not have been extracted, so it's left behind
+	mapNode := &yaml.Node{Kind: yaml.MappingNode}
+	if parent != nil {
+		mapNode.Style, mapNode.Tag = parent.Style, localTag(parent)
+		mapNode.HeadComment, mapNode.LineComment = parent.HeadComment, parent.LineComment
+	}
+	for _, part := range order {
+		key := KeyName(part)
+		keyNode := &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Value: key,
+			Tag:   "!!str",
+		}
+		child := wrapGroup(root, groups[part], depth+1, nullFill)
+		if origKey := findMapKey(parent, key); origKey != nil {
+			// The key is the one written in the document, comments, quoting
+			// and tag and all: an integer key stays an integer, `2023:`
+			// rather than "2023":. Its foot comment can describe siblings
+			// that weren't extracted, so it only comes along when the key's
+			// whole value did.
+			copied := *origKey
+			if len(groups[part]) != 1 || len(groups[part][0].Path) != depth+1 {
+				copied.FootComment = ""
+			}
+			keyNode = &copied
+		}
+		mapNode.Content = append(mapNode.Content, keyNode, child)
+	}
+	return mapNode
+}
+
+// partIndexes parses index parts like "[3]" into their indexes. ok is
+// false if any isn't a plain, non-negative index, which can't be padded to.
+func partIndexes(parts []string) (indexes []int, ok bool) {
+	for _, part := range parts {
+		index, err := strconv.Atoi(part[1 : len(part)-1])
+		if err != nil || index < 0 {
+			return nil, false
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, true
+}
+
+// ancestorNodeAt returns the original node found at the given path prefix in
+// root, unwrapping the document node so callers get the actual value node
+// (and its real Style) rather than the always-block DocumentNode wrapper.
+func ancestorNodeAt(root *yaml.Node, parts []string) *yaml.Node {
+	node := WalkParts(root, parts)
+	for node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	return node
+}
+
+// findMapKey returns the original key scalar node named key within mapNode,
+// or merged into it, so a reconstructed key can be a copy of it.
+func findMapKey(mapNode *yaml.Node, key string) *yaml.Node {
+	if mapNode == nil || mapNode.Kind != yaml.MappingNode {
+		return nil
+	}
+	// Its own keys first, which include any << merge key itself
+	for _, content := range [][]*yaml.Node{mapNode.Content, MergedContent(mapNode)} {
+		for i := 0; i+1 < len(content); i += 2 {
+			if content[i].Value == key {
+				return content[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package yamlpath finds nodes in a yaml.v3 node tree by path, the way the
+// gy command does. Patterns are written as gy takes them on the command
+// line:
+//
+//	spec.template.spec.containers[0].image
+//	services.*.ports[*]
+//	users[?(.active==true)].name | keys
+//	overrides.timeout // defaults.timeout // 30
+//
+// Each match carries its concrete path - wildcards resolved to the keys and
+// indexes they matched - so Wrap can rebuild the matches as a trimmed-down
+// copy of the source document, comments and styles included.
+package yamlpath
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotFound is returned by Extract when a pattern matches nothing and
+// there's nothing more specific to say about why.
+var ErrNotFound = errors.New("path not found")
+
+// The reasons a *PathError gives for a path finding nothing.
+var (
+	// ErrKeyNotFound is a mapping, or null, without the key a part names.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrIndexOutOfRange is a sequence, or null, too short for an index.
+	ErrIndexOutOfRange = errors.New("index out of range")
+	// ErrKindMismatch is a part that can't apply to the kind of node it
+	// reached, such as an index into a mapping or a key into a scalar.
+	ErrKindMismatch = errors.New("kind mismatch")
+)
+
+// A PathError explains why a path found nothing: Part, applied to the node
+// at Path, didn't fit it, for the reason Err gives. errors.Is reports a
+// missing key or index as ErrNotFound too - the document just doesn't have
+// that data - where a kind mismatch means the path doesn't fit the shape of
+// the document at all.
+type PathError struct {
+	Part string
+	Path []string
+	Err  error
+	msg  string
+}
+
+func (e *PathError) Error() string { return e.msg }
+
+func (e *PathError) Unwrap() error { return e.Err }
+
+func (e *PathError) Is(target error) bool {
+	return target == ErrNotFound && e.Err != ErrKindMismatch
+}
+
+// Extract returns the first node pattern matches in node, in document
+// order. A pattern that matches nothing is an error: usually a *PathError
+// naming the part that failed and why, otherwise ErrNotFound.
+func Extract(node *yaml.Node, pattern string) (*yaml.Node, error) {
+	matches, err := ExtractAll(node, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return matches[0].Node, nil
+}
+
+// ExtractAll returns every match of pattern in node, in document order. A
+// pattern that matches nothing returns no matches and, where there's one,
+// an error explaining why - a *PathError, unless the pattern itself is
+// malformed.
+func ExtractAll(node *yaml.Node, pattern string) ([]Match, error) {
+	if err := Check(pattern); err != nil {
+		return nil, err
+	}
+	var w Walker
+	return w.Find(node, pattern)
+}
+
+// Err returns the error that stopped the last Find or Search, such as a
+// Strict violation. Unlike the error those return for an empty result,
+// which only explains it, this one means the search itself failed.
+func (w *Walker) Err() error {
+	return w.err
+}
+
+// Leaves returns every leaf at or below m - each scalar and empty
+// collection - in document order, at its concrete path. It's the `**`
+// operator applied to a match already found.
+func (w *Walker) Leaves(m Match) []Match {
+	w.matches = nil
+	w.walkLeaves(m.Node, nil, m.Path, map[*yaml.Node]bool{})
+	return w.matches
+}
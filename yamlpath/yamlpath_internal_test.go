@@ -0,0 +1,1888 @@
+// Unit tests for the path engine: parsing patterns, walking nodes and
+// wrapping matches back into documents.
+// Run with: go test ./...
+
+package yamlpath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// extractPath returns the first match of pattern in node, or nil.
+func extractPath(node *yaml.Node, pattern string) *yaml.Node {
+	if m := extractAll(node, pattern); len(m) > 0 {
+		return m[0].Node
+	}
+	return nil
+}
+
+// extractAll returns every match of pattern in node, ignoring why there
+// were none.
+func extractAll(node *yaml.Node, pattern string) []Match {
+	var w Walker
+	m, _ := w.Search(node, pattern)
+	return m
+}
+
+func TestSplitPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a.b.c", []string{"a", "b", "c"}},
+		{"a[0]", []string{"a", "[0]"}},
+		{"a[0].b", []string{"a", "[0]", "b"}},
+		{"a[1:3].b", []string{"a", "[1:3]", "b"}},
+		{"a[-2:]", []string{"a", "[-2:]"}},
+		{"[0]", []string{"[0]"}},
+		{"jobs.~^deploy-.*.steps", []string{"jobs", "~^deploy-.*", "steps"}},
+		{"a.~^v[0-9.]+$.b", []string{"a", "~^v[0-9.]+$", "b"}},
+		{`a.~^(x.y|z)$.b`, []string{"a", "~^(x.y|z)$", "b"}},
+		{`a.~x\.y.b`, []string{"a", `~x\.y`, "b"}},
+		{"a.~x..b", []string{"a", "~x", "..", "b"}},
+		{"a.b~c", []string{"a", "b~c"}},
+		{"[0][1]", []string{"[0]", "[1]"}},
+		{"a..b", []string{"a", "..", "b"}},
+		{"..name", []string{"..", "name"}},
+		{".a..b[0]", []string{"a", "..", "b", "[0]"}},
+		{"users[0].roles[1]", []string{"users", "[0]", "roles", "[1]"}},
+		{"special_keys.1", []string{"special_keys", "1"}},
+		{`labels."app.kubernetes.io/name"`, []string{"labels", `"app.kubernetes.io/name"`}},
+		{`."a.b".c`, []string{`"a.b"`, "c"}},
+		{`'a[0]'[1]`, []string{`'a[0]'`, "[1]"}},
+		{`"say \"hi\"".x`, []string{`"say \"hi\""`, "x"}},
+		{`..'a.b'`, []string{"..", `'a.b'`}},
+		{`it's.here`, []string{`it's`, "here"}},
+		{`metadata\.name`, []string{`metadata\.name`}},
+		{`a.b\.c.d`, []string{"a", `b\.c`, "d"}},
+		{`foo\[0\]`, []string{`foo\[0\]`}},
+		{`foo\[0\][1]`, []string{`foo\[0\]`, "[1]"}},
+		{`foo[0].b\\.c`, []string{"foo", "[0]", `b\\`, "c"}},
+		{`\..x`, []string{`\.`, "x"}},
+		{`a[?(.name=="web")].b`, []string{"a", `[?(.name=="web")]`, "b"}},
+		{`a[?(.tags[0]=="x.y")]`, []string{"a", `[?(.tags[0]=="x.y")]`}},
+		{`a[?(.v=="]")].b`, []string{"a", `[?(.v=="]")]`, "b"}},
+		{`labels["app.kubernetes.io/name"]`, []string{"labels", `["app.kubernetes.io/name"]`}},
+		{`["a]b"].c`, []string{`["a]b"]`, "c"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			got := SplitPath(tc.pattern)
+			if !stringSlicesEqual(got, tc.want) {
+				t.Errorf("splitPath(%q) = %v, want %v", tc.pattern, got, tc.want)
+			}
+		})
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const sampleYAML = `
+app:
+  name: MyApp
+  debug: false
+database:
+  host: localhost
+  port: 5432
+  credentials:
+    user: admin
+    password: secret
+services:
+  - name: web
+    port: 8080
+  - name: api
+    port: 3000
+`
+
+func mustParse(t *testing.T, src string) *yaml.Node {
+	t.Helper()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &node); err != nil {
+		t.Fatalf("failed to parse fixture YAML: %v", err)
+	}
+	return &node
+}
+
+func marshal(t *testing.T, node *yaml.Node) string {
+	t.Helper()
+	if node == nil {
+		return "<nil>"
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		t.Fatalf("failed to marshal node: %v", err)
+	}
+	return string(out)
+}
+
+func TestExtractPath(t *testing.T) {
+	root := mustParse(t, sampleYAML)
+
+	cases := []struct {
+		name    string
+		pattern string
+		want    string // trimmed scalar value; empty means "check kind instead"
+	}{
+		{"top level scalar", "app.name", "MyApp"},
+		{"nested scalar", "database.credentials.user", "admin"},
+		{"leading dot", ".app.name", "MyApp"},
+		{"array index then field", "services[0].name", "web"},
+		{"second array element", "services[1].port", "3000"},
+		{"boolean scalar", "app.debug", "false"},
+		{"numeric scalar", "database.port", "5432"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractPath(root, tc.pattern)
+			if got == nil {
+				t.Fatalf("extractPath(%q) = nil, want scalar %q", tc.pattern, tc.want)
+			}
+			if got.Value != tc.want {
+				t.Errorf("extractPath(%q) = %q, want %q", tc.pattern, got.Value, tc.want)
+			}
+		})
+	}
+
+	t.Run("root pattern returns whole document", func(t *testing.T) {
+		got := extractPath(root, ".")
+		if got == nil || got.Kind != yaml.DocumentNode {
+			t.Fatalf("extractPath(\".\") = %v, want the document node", got)
+		}
+	})
+
+	t.Run("empty pattern returns whole document", func(t *testing.T) {
+		got := extractPath(root, "")
+		if got == nil || got.Kind != yaml.DocumentNode {
+			t.Fatalf("extractPath(\"\") = %v, want the document node", got)
+		}
+	})
+
+	t.Run("missing key returns nil", func(t *testing.T) {
+		if got := extractPath(root, "app.nonexistent"); got != nil {
+			t.Errorf("extractPath(missing key) = %v, want nil", got)
+		}
+	})
+
+	t.Run("missing nested path returns nil", func(t *testing.T) {
+		if got := extractPath(root, "database.credentials.token"); got != nil {
+			t.Errorf("extractPath(missing nested key) = %v, want nil", got)
+		}
+	})
+
+	t.Run("array index out of bounds returns nil", func(t *testing.T) {
+		if got := extractPath(root, "services[5]"); got != nil {
+			t.Errorf("extractPath(out of bounds index) = %v, want nil", got)
+		}
+	})
+
+	t.Run("negative array index counts from the end", func(t *testing.T) {
+		got := extractPath(root, "services[-1].name")
+		if got == nil || got.Value != "api" {
+			t.Errorf("extractPath(services[-1].name) = %v, want api", got)
+		}
+	})
+
+	t.Run("negative index at -len is the first element", func(t *testing.T) {
+		got := extractPath(root, "services[-2].name")
+		if got == nil || got.Value != "web" {
+			t.Errorf("extractPath(services[-2].name) = %v, want web", got)
+		}
+	})
+
+	t.Run("negative index past -len returns nil", func(t *testing.T) {
+		if got := extractPath(root, "services[-3]"); got != nil {
+			t.Errorf("extractPath(services[-3]) = %v, want nil", got)
+		}
+	})
+
+	t.Run("negative index records the resolved position", func(t *testing.T) {
+		matches := extractAll(root, "services[-1].port")
+		if len(matches) != 1 || FormatPath(matches[0].Path) != "services[1].port" {
+			t.Errorf("extractAll(services[-1].port) = %v, want path services[1].port", matches)
+		}
+	})
+
+	t.Run("indexing into a mapping returns nil", func(t *testing.T) {
+		if got := extractPath(root, "app[0]"); got != nil {
+			t.Errorf("extractPath(index into mapping) = %v, want nil", got)
+		}
+	})
+
+	t.Run("field access into a sequence returns nil", func(t *testing.T) {
+		if got := extractPath(root, "services.name"); got != nil {
+			t.Errorf("extractPath(field into sequence) = %v, want nil", got)
+		}
+	})
+
+	t.Run("nested array element", func(t *testing.T) {
+		nested := mustParse(t, "matrix:\n  - [1, 2]\n  - [3, 4]\n")
+		got := extractPath(nested, "matrix[1][0]")
+		if got == nil || got.Value != "3" {
+			t.Errorf("extractPath(matrix[1][0]) = %v, want 3", got)
+		}
+	})
+
+	t.Run("numeric-looking string keys are matched as strings", func(t *testing.T) {
+		typesDoc := mustParse(t, "special_keys:\n  1: \"numeric key\"\n  \"key.with.dots\": value\n")
+		got := extractPath(typesDoc, "special_keys.1")
+		if got == nil || got.Value != "numeric key" {
+			t.Errorf("extractPath(special_keys.1) = %v, want %q", got, "numeric key")
+		}
+	})
+}
+
+func TestExtractAllWildcard(t *testing.T) {
+	root := mustParse(t, sampleYAML)
+
+	t.Run("star matches every key in a mapping", func(t *testing.T) {
+		matches := extractAll(root, "database.credentials.*")
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Node.Value)
+		}
+		if want := []string{"admin", "secret"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(database.credentials.*) values = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("matches record the concrete key they came from", func(t *testing.T) {
+		matches := extractAll(root, "*.host")
+		if len(matches) != 1 {
+			t.Fatalf("extractAll(*.host) returned %d matches, want 1", len(matches))
+		}
+		if want := []string{"database", "host"}; !stringSlicesEqual(matches[0].Path, want) {
+			t.Errorf("match path = %v, want %v", matches[0].Path, want)
+		}
+	})
+
+	t.Run("branches without the remaining path are skipped", func(t *testing.T) {
+		matches := extractAll(root, "*.port")
+		if len(matches) != 1 || matches[0].Node.Value != "5432" {
+			t.Errorf("extractAll(*.port) = %v, want only database.port", matches)
+		}
+	})
+
+	t.Run("[*] matches every sequence element in order", func(t *testing.T) {
+		matches := extractAll(root, "services[*].name")
+		var got, paths []string
+		for _, m := range matches {
+			got = append(got, m.Node.Value)
+			paths = append(paths, FormatPath(m.Path))
+		}
+		if want := []string{"web", "api"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(services[*].name) values = %v, want %v", got, want)
+		}
+		if want := []string{"services[0].name", "services[1].name"}; !stringSlicesEqual(paths, want) {
+			t.Errorf("extractAll(services[*].name) paths = %v, want %v", paths, want)
+		}
+	})
+
+	t.Run("[*] on a mapping matches nothing", func(t *testing.T) {
+		if matches := extractAll(root, "database[*]"); len(matches) != 0 {
+			t.Errorf("extractAll(database[*]) = %v, want no matches", matches)
+		}
+	})
+
+	t.Run("no match returns an empty result", func(t *testing.T) {
+		if matches := extractAll(root, "*.nonexistent"); len(matches) != 0 {
+			t.Errorf("extractAll(*.nonexistent) = %v, want no matches", matches)
+		}
+	})
+
+	t.Run("escaped star addresses a literal key named *", func(t *testing.T) {
+		doc := mustParse(t, "globs:\n  \"*\": everything\n  \"*.go\": sources\n")
+		matches := extractAll(doc, `globs.\*`)
+		if len(matches) != 1 || matches[0].Node.Value != "everything" {
+			t.Errorf("extractAll(globs.\\*) = %v, want the single literal * key", matches)
+		}
+	})
+
+	t.Run("escaped separators address keys containing them", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  b.c:\n    \"d[0]\": x\n  b:\n    c: wrong\n")
+		matches := extractAll(doc, `a.b\.c.d\[0\]`)
+		if len(matches) != 1 || matches[0].Node.Value != "x" {
+			t.Errorf("extractAll(a.b\\.c.d\\[0\\]) = %v, want x", matches)
+		}
+	})
+
+	t.Run("escaped dots and backslashes are part of the key", func(t *testing.T) {
+		doc := mustParse(t, "metadata:\n  labels:\n    app.kubernetes.io/name: web\n    \"x\\\\y\": z\n    \"end\\\\\": e\n")
+		for pattern, want := range map[string]string{
+			`.metadata.labels.app\.kubernetes\.io/name`: "web",
+			`metadata.labels.x\\y`:                      "z",
+			`metadata.labels.end\\`:                     "e",
+		} {
+			if matches := extractAll(doc, pattern); len(matches) != 1 || matches[0].Node.Value != want {
+				t.Errorf("extractAll(%s) = %v, want %s", pattern, matches, want)
+			}
+		}
+	})
+
+	t.Run("quoted star addresses a literal key named *", func(t *testing.T) {
+		doc := mustParse(t, "globs:\n  \"*\": everything\n  \"*.go\": sources\n")
+		matches := extractAll(doc, `globs."*.go"`)
+		if len(matches) != 1 || matches[0].Node.Value != "sources" {
+			t.Errorf("extractAll(globs.\"*.go\") = %v, want the literal *.go key", matches)
+		}
+	})
+}
+
+func TestExtractAllRecursiveDescent(t *testing.T) {
+	root := mustParse(t, sampleYAML)
+
+	values := func(matches []Match) []string {
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Node.Value)
+		}
+		return got
+	}
+
+	t.Run("finds a key at any depth in document order", func(t *testing.T) {
+		got := values(extractAll(root, "..name"))
+		if want := []string{"MyApp", "web", "api"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(..name) = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("records the full concrete path of each match", func(t *testing.T) {
+		var paths []string
+		for _, m := range extractAll(root, "..port") {
+			paths = append(paths, FormatPath(m.Path))
+		}
+		want := []string{"database.port", "services[0].port", "services[1].port"}
+		if !stringSlicesEqual(paths, want) {
+			t.Errorf("extractAll(..port) paths = %v, want %v", paths, want)
+		}
+	})
+
+	t.Run("is scoped to the subtree it follows", func(t *testing.T) {
+		got := values(extractAll(root, "database..user"))
+		if want := []string{"admin"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(database..user) = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("continues with the rest of the path after the found key", func(t *testing.T) {
+		got := values(extractAll(root, "..credentials.password"))
+		if want := []string{"secret"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(..credentials.password) = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("nested matches are all reported", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  a:\n    a: 1\n")
+		if got := extractAll(doc, "..a"); len(got) != 3 {
+			t.Errorf("extractAll(..a) returned %d matches, want 3", len(got))
+		}
+	})
+
+	t.Run("a node reachable from several ancestors is reported once", func(t *testing.T) {
+		doc := mustParse(t, "a:\n  a:\n    b: 1\n")
+		got := extractAll(doc, "..a..b")
+		if len(got) != 1 || FormatPath(got[0].Path) != "a.a.b" {
+			t.Errorf("extractAll(..a..b) = %v, want the single a.a.b match", got)
+		}
+	})
+
+	t.Run("trailing .. matches every descendant", func(t *testing.T) {
+		got := extractAll(root, "database.credentials..")
+		if len(got) != 3 {
+			t.Errorf("extractAll(database.credentials..) returned %d matches, want 3 (the mapping and both values)", len(got))
+		}
+	})
+
+	t.Run("aliases are not followed", func(t *testing.T) {
+		doc := mustParse(t, "base: &b\n  name: x\nother: *b\n")
+		if got := values(extractAll(doc, "..name")); !stringSlicesEqual(got, []string{"x"}) {
+			t.Errorf("extractAll(..name) = %v, want only the anchored original", got)
+		}
+	})
+}
+
+func TestExtractAllSlice(t *testing.T) {
+	doc := mustParse(t, "items: [a, b, c, d, e, f]\nusers:\n  - name: x\n  - name: y\n  - name: z\n")
+
+	values := func(node *yaml.Node) []string {
+		var got []string
+		for _, item := range node.Content {
+			got = append(got, item.Value)
+		}
+		return got
+	}
+
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"items[2:5]", []string{"c", "d", "e"}},
+		{"items[:2]", []string{"a", "b"}},
+		{"items[4:]", []string{"e", "f"}},
+		{"items[:]", []string{"a", "b", "c", "d", "e", "f"}},
+		{"items[3:100]", []string{"d", "e", "f"}},
+		{"items[-2:]", []string{"e", "f"}},
+		{"items[:-4]", []string{"a", "b"}},
+		{"items[-3:-1]", []string{"d", "e"}},
+		{"items[-100:2]", []string{"a", "b"}},
+		{"items[4:2]", nil},
+		{"items[10:]", nil},
+		{"items[::2]", []string{"a", "c", "e"}},
+		{"items[1:5:2]", []string{"b", "d"}},
+		{"items[::-1]", []string{"f", "e", "d", "c", "b", "a"}},
+		{"items[4:1:-2]", []string{"e", "c"}},
+		{"items[-2::-2]", []string{"e", "c", "a"}},
+		{"items[:-3:-1]", []string{"f", "e"}},
+		{"items[2:4:-1]", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			matches := extractAll(doc, tc.pattern)
+			if len(matches) != 1 {
+				t.Fatalf("extractAll(%q) returned %d matches, want a single sub-sequence", tc.pattern, len(matches))
+			}
+			got := matches[0].Node
+			if got.Kind != yaml.SequenceNode {
+				t.Fatalf("extractAll(%q) kind = %v, want a sequence", tc.pattern, got.Kind)
+			}
+			if !stringSlicesEqual(values(got), tc.want) {
+				t.Errorf("extractAll(%q) = %v, want %v", tc.pattern, values(got), tc.want)
+			}
+		})
+	}
+
+	t.Run("slice does not modify the source sequence", func(t *testing.T) {
+		extractAll(doc, "items[1:2]")
+		if got := extractPath(doc, "items"); len(got.Content) != 6 {
+			t.Errorf("source sequence has %d elements after slicing, want 6", len(got.Content))
+		}
+	})
+
+	t.Run("path after a slice applies to each selected element", func(t *testing.T) {
+		var got, paths []string
+		for _, m := range extractAll(doc, "users[1:].name") {
+			got = append(got, m.Node.Value)
+			paths = append(paths, FormatPath(m.Path))
+		}
+		if want := []string{"y", "z"}; !stringSlicesEqual(got, want) {
+			t.Errorf("extractAll(users[1:].name) = %v, want %v", got, want)
+		}
+		if want := []string{"users[1].name", "users[2].name"}; !stringSlicesEqual(paths, want) {
+			t.Errorf("extractAll(users[1:].name) paths = %v, want %v", paths, want)
+		}
+	})
+
+	t.Run("non-numeric bounds match nothing", func(t *testing.T) {
+		if got := extractAll(doc, "items[a:b]"); len(got) != 0 {
+			t.Errorf("extractAll(items[a:b]) = %v, want no matches", got)
+		}
+	})
+
+	t.Run("wrapping places the slice under its original key", func(t *testing.T) {
+		got := marshal(t, Wrap(doc, extractAll(doc, "items[1:3]")))
+		want := "items: [b, c]\n"
+		if got != want {
+			t.Errorf("wrapMatches(items[1:3]) = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestExtractAllFirstLast(t *testing.T) {
+	doc := mustParse(t, "deployments:\n  - sha: a\n  - sha: b\n  - sha: c\nempty: []\nm: {a: 1}\n")
+
+	for pattern, want := range map[string]string{
+		"deployments[first].sha": "a",
+		"deployments[last].sha":  "c",
+	} {
+		got := extractPath(doc, pattern)
+		if got == nil || got.Value != want {
+			t.Errorf("extractPath(%s) = %v, want %s", pattern, got, want)
+		}
+	}
+
+	t.Run("the resolved index is recorded", func(t *testing.T) {
+		matches := extractAll(doc, "deployments[last]")
+		if len(matches) != 1 || FormatPath(matches[0].Path) != "deployments[2]" {
+			t.Errorf("extractAll(deployments[last]) = %v, want path deployments[2]", matches)
+		}
+	})
+
+	errCases := map[string]string{
+		"empty[last]": "[last] needs a non-empty sequence, but empty has no elements",
+		"m[first]":    "[first] selects from a sequence, but m is a mapping",
+	}
+	for pattern, want := range errCases {
+		var w Walker
+		if _, err := w.Search(doc, pattern); err == nil || err.Error() != want {
+			t.Errorf("search(%s) error = %v, want %q", pattern, err, want)
+		}
+	}
+}
+
+func TestExtractAllParent(t *testing.T) {
+	doc := mustParse(t, "users:\n  - name: alice\n    role: admin\n  - name: bob\n    role: dev\ndb:\n  password: x\n  user: root\napi:\n  auth: {password: y}\n")
+
+	cases := []struct {
+		pattern string
+		paths   []string
+	}{
+		{"users[name=bob].^", []string{"users"}},
+		{"users[name=bob].name.^.role", []string{"users[1].role"}},
+		{"..password.^", []string{"db", "api.auth"}},
+		{"db.*.^", []string{"db"}},
+		{"db.^", []string{""}},
+		{"api.auth.^.^.db.user", []string{"db.user"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var paths []string
+			for _, m := range extractAll(doc, tc.pattern) {
+				paths = append(paths, FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("extractAll(%s) paths = %q, want %q", tc.pattern, paths, tc.paths)
+			}
+		})
+	}
+
+	t.Run("above the root explains the miss", func(t *testing.T) {
+		var w Walker
+		if _, err := w.Search(doc, "db.^.^"); err == nil || err.Error() != "^ steps above the document root" {
+			t.Errorf("search(db.^.^) error = %v, want a steps-above-the-root error", err)
+		}
+	})
+
+	t.Run("a pipe stage can step above its input", func(t *testing.T) {
+		var w Walker
+		matches, err := w.Find(doc, "users[*] | name.^.^ | [0].role")
+		if err != nil || len(matches) != 1 || matches[0].Node.Value != "admin" {
+			t.Errorf("pipe = %v, %v; want the single match admin", matches, err)
+		}
+	})
+
+	t.Run("a key named ^ is quoted", func(t *testing.T) {
+		if got := EscapeKey("^"); got != `"^"` {
+			t.Errorf("escapeKey(^) = %s, want \"^\"", got)
+		}
+	})
+}
+
+func TestExtractIntegerKeys(t *testing.T) {
+	doc := mustParse(t, "history:\n  2023: a\n  0x10: b\n  \"7\": c\n  !!int 8: d\nitems: [x, y, z]\n")
+
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{"history.2023", "a"},
+		{"history.16", "b"},
+		{"history.0x10", "b"},
+		{"history.7", "c"},
+		{"history.8", "d"},
+		{`history."2023"`, "a"},
+		{"items.1", "y"},
+		{"items.-1", "z"},
+		{"items[1]", "y"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			got := extractPath(doc, tc.pattern)
+			if got == nil || got.Value != tc.want {
+				t.Errorf("extractPath(%s) = %v, want %s", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("a quoted number is only a string key", func(t *testing.T) {
+		if got := extractPath(doc, `history."16"`); got != nil {
+			t.Errorf(`extractPath(history."16") = %v, want nil`, got.Value)
+		}
+	})
+
+	t.Run("a bracketed number only indexes sequences", func(t *testing.T) {
+		var w Walker
+		matches, err := w.Search(doc, "history[2023]")
+		if len(matches) != 0 || err == nil || !strings.Contains(err.Error(), "write history.2023 for the key 2023") {
+			t.Errorf("search(history[2023]) = %d matches, %v; want none and a hint", len(matches), err)
+		}
+	})
+}
+
+func TestExtractBoolKeys(t *testing.T) {
+	workflow := mustParse(t, "on:\n  push: {branches: [main]}\n\"yes\": quoted\nTrue: title\n")
+	converted := mustParse(t, "true:\n  push: {branches: [main]}\n'no': quoted\nOFF: off\n")
+
+	cases := []struct {
+		doc     *yaml.Node
+		pattern string
+		want    string
+	}{
+		{workflow, "on.push.branches[0]", "main"},
+		{workflow, `"on".push.branches[0]`, "main"},
+		{workflow, "yes", "quoted"},
+		{workflow, "True", "title"},
+		{workflow, "true", "title"},
+		{converted, "on.push.branches[0]", "main"},
+		{converted, "yes.push.branches[0]", "main"},
+		{converted, "true.push.branches[0]", "main"},
+		{converted, "no", "quoted"},
+		{converted, "off", "off"},
+		{converted, "false", "off"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			got := extractPath(tc.doc, tc.pattern)
+			if got == nil || got.Value != tc.want {
+				t.Errorf("extractPath(%s) = %v, want %s", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("a quoted word is only a string key", func(t *testing.T) {
+		if got := extractPath(converted, `"on"`); got != nil {
+			t.Errorf(`extractPath("on") = %v, want nil`, got.Value)
+		}
+		if got := extractPath(workflow, "no"); got != nil {
+			t.Errorf("extractPath(no) = %v, want nil for a true-only document", got.Value)
+		}
+	})
+
+	t.Run("a quoted key only matches as written", func(t *testing.T) {
+		doc := mustParse(t, "'no': quoted\n\"off\": too\n")
+		if got := extractPath(doc, "n"); got != nil {
+			t.Errorf("extractPath(n) = %v, want nil past the quoted keys", got.Value)
+		}
+	})
+}
+
+func TestExtractErrors(t *testing.T) {
+	doc := mustParse(t, "name: x\nlist: [1, 2]\nmap: {a: 1}\nnone:\nsvc: {web: {port: 80}, db: {}}\n")
+
+	cases := []struct {
+		pattern string
+		reason  error
+		part    string
+		path    string
+		msg     string
+	}{
+		{"map.b", ErrKeyNotFound, "b", "map", "map has no key b"},
+		{"nope.b", ErrKeyNotFound, "nope", "", "the document root has no key nope"},
+		{"none.a", ErrKeyNotFound, "a", "none", "none has no key a, since it's null"},
+		{"list[5]", ErrIndexOutOfRange, "[5]", "list", "[5] is out of range for list (length 2)"},
+		{"list.-3", ErrIndexOutOfRange, "[-3]", "list", "[-3] is out of range for list (length 2)"},
+		{"none[0]", ErrIndexOutOfRange, "[0]", "none", "[0] is out of range for none, which is null"},
+		{"name.first", ErrKindMismatch, "first", "name", "first looks up a key, but name is a scalar"},
+		{"name[0]", ErrKindMismatch, "[0]", "name", "[0] selects from a sequence, but name is a scalar"},
+		{"list.a", ErrKindMismatch, "a", "list", "a looks up a key, but list is a sequence"},
+		{"map[*]", ErrKindMismatch, "[*]", "map", "[*] selects from a sequence, but map is a mapping"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			node, err := Extract(doc, tc.pattern)
+			var perr *PathError
+			if node != nil || !errors.As(err, &perr) {
+				t.Fatalf("Extract(%s) = %v, %v; want a *PathError", tc.pattern, node, err)
+			}
+			if !errors.Is(err, tc.reason) || perr.Part != tc.part || FormatPath(perr.Path) != tc.path || err.Error() != tc.msg {
+				t.Errorf("Extract(%s) = %v at %q in %q (%v); want %v at %q in %q (%q)", tc.pattern, err, perr.Part, FormatPath(perr.Path), perr.Err, tc.reason, tc.part, tc.path, tc.msg)
+			}
+			if errors.Is(err, ErrNotFound) != (tc.reason != ErrKindMismatch) {
+				t.Errorf("errors.Is(%v, ErrNotFound) = %v, want it only for a missing key or index", err, errors.Is(err, ErrNotFound))
+			}
+		})
+	}
+
+	t.Run("a mismatch explains the miss ahead of a missing key", func(t *testing.T) {
+		_, err := Extract(doc, "svc.*.port.x")
+		if !errors.Is(err, ErrKindMismatch) {
+			t.Errorf("Extract(svc.*.port.x) = %v, want the kind mismatch at svc.web.port", err)
+		}
+	})
+
+	t.Run("parts under .. are expected not to fit", func(t *testing.T) {
+		if _, err := Extract(doc, "..nope"); err != ErrNotFound {
+			t.Errorf("Extract(..nope) = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestExtractAllLeaves(t *testing.T) {
+	doc := mustParse(t, "base: &b {x: 1}\nsvc:\n  <<: *b\n  \"a.b\": [2, 3]\n  none: {}\n  self: &s\n    loop: *s\n")
+
+	cases := []struct {
+		pattern string
+		paths   []string
+	}{
+		{"**", []string{"base.x", "svc.x", "svc.\"a.b\"[0]", "svc.\"a.b\"[1]", "svc.none"}},
+		{"svc.\"a.b\".**", []string{"svc.\"a.b\"[0]", "svc.\"a.b\"[1]"}},
+		{"base.x.**", []string{"base.x"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var paths []string
+			for _, m := range extractAll(doc, tc.pattern) {
+				paths = append(paths, FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("extractAll(%s) paths = %q, want %q", tc.pattern, paths, tc.paths)
+			}
+		})
+	}
+
+	t.Run("** must end the path", func(t *testing.T) {
+		if err := CheckPath("**.x"); err == nil {
+			t.Error("checkPattern(**.x) = nil, want an error")
+		}
+	})
+}
+
+func TestExtractAllGlob(t *testing.T) {
+	doc := mustParse(t, "env_prod: {url: p}\nenv_dev: {url: d}\nenvx: {url: x}\nnode1: a\nnode22: b\n\"a*\": lit\nab: no\n")
+
+	cases := []struct {
+		pattern string
+		paths   []string
+	}{
+		{"env_*.url", []string{"env_prod.url", "env_dev.url"}},
+		{"env*", []string{"env_prod", "env_dev", "envx"}},
+		{"node?", []string{"node1"}},
+		{"*_dev", []string{"env_dev"}},
+		{`a\*`, []string{`"a*"`}},
+		{`"a*"`, []string{`"a*"`}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var paths []string
+			for _, m := range extractAll(doc, tc.pattern) {
+				paths = append(paths, FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("extractAll(%s) paths = %q, want %q", tc.pattern, paths, tc.paths)
+			}
+		})
+	}
+
+	for part, want := range map[string]bool{"env_*": true, "node?": true, "*": false, `a\*`: false, `"a*"`: false, "plain": false, "~a*": false} {
+		if got := IsGlobPart(part); got != want {
+			t.Errorf("isGlobPart(%q) = %v, want %v", part, got, want)
+		}
+	}
+}
+
+func TestExtractAllIgnoreCase(t *testing.T) {
+	doc := mustParse(t, "Name: A\nname: b\nMeta:\n  Labels: {Tier: web}\n")
+
+	cases := []struct {
+		pattern string
+		paths   []string
+	}{
+		{"NAME", []string{"Name", "name"}},
+		{"meta.labels.tier", []string{"Meta.Labels.Tier"}},
+		{"meta.*.TIER", []string{"Meta.Labels.Tier"}},
+		{"~^META$.~^lab", []string{"Meta.Labels"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			w := Walker{IgnoreCase: true}
+			matches, _ := w.Search(doc, tc.pattern)
+			var paths []string
+			for _, m := range matches {
+				paths = append(paths, FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("search(%s) paths = %q, want %q", tc.pattern, paths, tc.paths)
+			}
+		})
+	}
+
+	t.Run("case matters without the flag", func(t *testing.T) {
+		if got := extractAll(doc, "meta.labels.tier"); len(got) != 0 {
+			t.Errorf("extractAll(meta.labels.tier) = %v, want no matches", got)
+		}
+	})
+}
+
+func TestExtractAllIndexList(t *testing.T) {
+	doc := mustParse(t, "hosts: [h0, h1, h2, h3, h4, h5, h6, h7]\nusers:\n  - name: x\n  - name: y\n  - name: z\n")
+
+	values := func(pattern string) []string {
+		matches := extractAll(doc, pattern)
+		if len(matches) != 1 || matches[0].Node.Kind != yaml.SequenceNode {
+			t.Fatalf("extractAll(%q) = %v, want a single sub-sequence", pattern, matches)
+		}
+		var got []string
+		for _, item := range matches[0].Node.Content {
+			got = append(got, item.Value)
+		}
+		return got
+	}
+
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"hosts[0,3,7]", []string{"h0", "h3", "h7"}},
+		{"hosts[7,0]", []string{"h7", "h0"}},
+		{"hosts[1, 2]", []string{"h1", "h2"}},
+		{"hosts[-1,0]", []string{"h7", "h0"}},
+		{"hosts[0,9,2]", []string{"h0", "h2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			if got := values(tc.pattern); !stringSlicesEqual(got, tc.want) {
+				t.Errorf("extractAll(%q) = %v, want %v", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("path after a list applies to each picked element", func(t *testing.T) {
+		var paths []string
+		for _, m := range extractAll(doc, "users[2,0].name") {
+			paths = append(paths, FormatPath(m.Path))
+		}
+		if want := []string{"users[2].name", "users[0].name"}; !stringSlicesEqual(paths, want) {
+			t.Errorf("extractAll(users[2,0].name) paths = %v, want %v", paths, want)
+		}
+	})
+
+	t.Run("wrapping does not pad to the highest index", func(t *testing.T) {
+		if got, want := wrap(t, doc, "hosts[0,5]"), "hosts: [h0, h5]\n"; got != want {
+			t.Errorf("Wrap(hosts[0,5]) = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("strict rejects out-of-range entries", func(t *testing.T) {
+		w := Walker{Strict: true}
+		_, err := w.Search(doc, "hosts[0,9]")
+		want := "index 9 out of range for hosts (length 8)"
+		if err == nil || err.Error() != want {
+			t.Errorf("strict search error = %v, want %q", err, want)
+		}
+	})
+}
+
+func TestExtractAllRegex(t *testing.T) {
+	doc := mustParse(t, `
+jobs:
+  deploy-staging: {steps: [a]}
+  build: {steps: [b]}
+  deploy-prod: {steps: [c]}
+  "~literal": x
+`)
+
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"jobs.~^deploy-.*.steps[0]", []string{"a", "c"}},
+		{"jobs.~prod$.steps[0]", []string{"c"}},
+		{"jobs.~^(build|deploy-prod)$.steps[0]", []string{"b", "c"}},
+		{"jobs.~^nope", nil},
+		{`jobs."~literal"`, []string{"x"}},
+		{`jobs.\~literal`, []string{"x"}},
+		{"..~^deploy-staging$.steps[0]", []string{"a"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var got []string
+			for _, m := range extractAll(doc, tc.pattern) {
+				got = append(got, m.Node.Value)
+			}
+			if !stringSlicesEqual(got, tc.want) {
+				t.Errorf("extractAll(%s) = %v, want %v", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("keys starting with ~ round-trip through formatPath", func(t *testing.T) {
+		matches := extractAll(doc, "jobs.~^~")
+		if len(matches) != 1 || FormatPath(matches[0].Path) != `jobs."~literal"` {
+			t.Errorf("extractAll(jobs.~^~) = %v, want path jobs.\"~literal\"", matches)
+		}
+	})
+}
+
+func TestSingular(t *testing.T) {
+	cases := map[string]bool{
+		".":                  true,
+		"a.b":                true,
+		"a[0].b":             true,
+		"a[-1]":              true,
+		"a[last]":            true,
+		`a["x.y"]`:           true,
+		`a."*"`:              true,
+		"a // b.c // 30":     true,
+		"a.*":                false,
+		"a[*]":               false,
+		"..name":             false,
+		"a[1:3]":             false,
+		"a[0,2]":             false,
+		"a[name=web]":        false,
+		`a[?(.name=="web")]`: false,
+		"a.~^x":              false,
+		"a // b.*":           false,
+	}
+	for pattern, want := range cases {
+		if got := Singular(pattern); got != want {
+			t.Errorf("singular(%q) = %v, want %v", pattern, got, want)
+		}
+	}
+}
+
+func TestMappingKeys(t *testing.T) {
+	doc := mustParse(t, "services:\n  web: {}\n  # the database\n  db: {}\n  app: {}\nports: [80]\n")
+
+	keys := func(pattern string, sorted bool) ([]string, error) {
+		names, err := Keys(extractAll(doc, pattern)[0], sorted, "--keys")
+		var values []string
+		for _, name := range names {
+			values = append(values, name.Value)
+		}
+		return values, err
+	}
+
+	got, err := keys("services", false)
+	if err != nil || !stringSlicesEqual(got, []string{"web", "db", "app"}) {
+		t.Errorf("keys of services = %v, %v; want [web db app] in document order", got, err)
+	}
+	got, err = keys("services", true)
+	if err != nil || !stringSlicesEqual(got, []string{"app", "db", "web"}) {
+		t.Errorf("sorted keys of services = %v, %v; want [app db web]", got, err)
+	}
+	got, err = keys(".", false)
+	if err != nil || !stringSlicesEqual(got, []string{"services", "ports"}) {
+		t.Errorf("keys of the root = %v, %v; want [services ports]", got, err)
+	}
+
+	names, _ := Keys(extractAll(doc, "services")[0], false, "--keys")
+	if names[1].HeadComment != "" {
+		t.Errorf("key copy kept its comment %q", names[1].HeadComment)
+	}
+	if extractAll(doc, "services")[0].Node.Content[2].HeadComment == "" {
+		t.Error("mappingKeys modified the source key")
+	}
+
+	for _, pattern := range []string{"ports", "ports[0]"} {
+		if _, err := keys(pattern, false); err == nil || !strings.Contains(err.Error(), "--keys needs a mapping") {
+			t.Errorf("keys of %s: err = %v, want a needs-a-mapping error", pattern, err)
+		}
+	}
+}
+
+func TestMappingValues(t *testing.T) {
+	doc := mustParse(t, "base: &b {port: 22}\nhost:\n  name: web\n  ssh: *b\n  ports: [80]\n")
+
+	values, err := Values(extractAll(doc, "host")[0], "--values")
+	if err != nil || len(values) != 3 {
+		t.Fatalf("values of host = %v, %v; want three", values, err)
+	}
+	if values[0].Value != "web" || values[1].Kind != yaml.MappingNode || values[2].Kind != yaml.SequenceNode {
+		t.Errorf("values of host aren't name, ssh and ports in document order")
+	}
+	if values[1].Anchor != "" {
+		t.Errorf("the alias's value kept the anchor %q", values[1].Anchor)
+	}
+	if extractAll(doc, "base")[0].Node.Anchor != "b" {
+		t.Error("Values modified the anchored node")
+	}
+
+	if _, err := Values(extractAll(doc, "host.ports")[0], "--values"); err == nil || err.Error() != "--values needs a mapping, but host.ports is a sequence" {
+		t.Errorf("values of host.ports: err = %v, want a needs-a-mapping error", err)
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	cases := []struct {
+		part string
+		want string
+	}{
+		{"plain", "plain"},
+		{`"a.b"`, "a.b"},
+		{`'a.b'`, "a.b"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`'it\'s'`, "it's"},
+		{`"*"`, "*"},
+		{`\*`, "*"},
+		{`""`, ""},
+		{`it's`, "it's"},
+		{`metadata\.name`, "metadata.name"},
+		{`foo\[0\]`, "foo[0]"},
+		{`back\\slash`, `back\slash`},
+		{`trailing\`, `trailing\`},
+	}
+
+	for _, tc := range cases {
+		if got := KeyName(tc.part); got != tc.want {
+			t.Errorf("keyName(%q) = %q, want %q", tc.part, got, tc.want)
+		}
+	}
+
+	t.Run("escapeKey round-trips through keyName", func(t *testing.T) {
+		for _, key := range []string{"plain", "a.b", "[0]", "*", `say "hi"`, `back\slash`, "it's", "a|b", "why?", "&ref", ""} {
+			if got := KeyName(EscapeKey(key)); got != key {
+				t.Errorf("keyName(escapeKey(%q)) = %q", key, got)
+			}
+		}
+	})
+}
+
+func TestExtractAllQuotedKeys(t *testing.T) {
+	doc := mustParse(t, `
+labels:
+  app.kubernetes.io/name: web
+  "weird [key]": brackets
+  with space: spaced
+  'say "hi"': quoted
+  plain: value
+`)
+
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{`labels."app.kubernetes.io/name"`, "web"},
+		{`labels.'app.kubernetes.io/name'`, "web"},
+		{`labels["app.kubernetes.io/name"]`, "web"},
+		{`labels['app.kubernetes.io/name']`, "web"},
+		{`labels."weird [key]"`, "brackets"},
+		{`labels["weird [key]"]`, "brackets"},
+		{`labels.with space`, "spaced"},
+		{`labels."with space"`, "spaced"},
+		{`labels["with space"]`, "spaced"},
+		{`labels.'say "hi"'`, "quoted"},
+		{`labels."say \"hi\""`, "quoted"},
+		{`["labels"]["plain"]`, "value"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			got := extractPath(doc, tc.pattern)
+			if got == nil || got.Value != tc.want {
+				t.Errorf("extractPath(%s) = %v, want %q", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("bracketed string on a sequence matches nothing", func(t *testing.T) {
+		seq := mustParse(t, "items: [a, b]\n")
+		if got := extractPath(seq, `items["0"]`); got != nil {
+			t.Errorf("extractPath(items[\"0\"]) = %v, want nil", got)
+		}
+	})
+
+	t.Run("bracketed key is rebuilt as a normal mapping key", func(t *testing.T) {
+		got := marshal(t, Wrap(doc, extractAll(doc, `labels["app.kubernetes.io/name"]`)))
+		want := "labels:\n    app.kubernetes.io/name: web\n"
+		if got != want {
+			t.Errorf("wrapMatches(labels[\"app.kubernetes.io/name\"]) = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestSplitUnion(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"a.b", []string{"a.b"}},
+		{".metadata.name, .spec.replicas", []string{".metadata.name", ".spec.replicas"}},
+		{"a,b,c", []string{"a", "b", "c"}},
+		{"hosts[0,2], x", []string{"hosts[0,2]", "x"}},
+		{`"a,b".c, d`, []string{`"a,b".c`, "d"}},
+		{`a\,b`, []string{`a\,b`}},
+		{"items[?(.a==\"x,y\")]", []string{"items[?(.a==\"x,y\")]"}},
+		{"jobs.~^a{1,2}$", []string{"jobs.~^a{1,2}$"}},
+		{"a,", []string{"a", ""}},
+	}
+	for _, tc := range cases {
+		if got := SplitUnion(tc.pattern); !stringSlicesEqual(got, tc.want) {
+			t.Errorf("splitUnion(%q) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestSplitFallback(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"a.b", []string{"a.b"}},
+		{".a.b // .c.d", []string{".a.b", ".c.d"}},
+		{"a//b // 30", []string{"a", "b", "30"}},
+		{`labels."http://x" // y`, []string{`labels."http://x"`, "y"}},
+		{`a // "n/a"`, []string{"a", `"n/a"`}},
+	}
+	for _, tc := range cases {
+		if got := splitFallback(tc.pattern); !stringSlicesEqual(got, tc.want) {
+			t.Errorf("splitFallback(%q) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestSplitPipe(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"a.b", []string{"a.b"}},
+		{".items[*] | .metadata.name", []string{".items[*]", ".metadata.name"}},
+		{"a|keys|length", []string{"a", "keys", "length"}},
+		{`labels."a|b" | x`, []string{`labels."a|b"`, "x"}},
+		{`a\|b`, []string{`a\|b`}},
+		{"jobs.~^(a|b)$ | steps", []string{"jobs.~^(a|b)$", "steps"}},
+		{`items[?(.v=="x|y")]`, []string{`items[?(.v=="x|y")]`}},
+		{"a // b | c", []string{"a // b", "c"}},
+	}
+	for _, tc := range cases {
+		if got := splitPipe(tc.pattern); !stringSlicesEqual(got, tc.want) {
+			t.Errorf("splitPipe(%q) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	doc := mustParse(t, "items:\n  - metadata: {name: a, labels: {x: 1, y: 2}}\n  - metadata: {name: b}\nconfig:\n  keys: literal\n  debug: true\n")
+
+	cases := []struct {
+		pattern string
+		values  []string
+		paths   []string
+	}{
+		{"items[*] | metadata.name", []string{"a", "b"}, []string{"items[0].metadata.name", "items[1].metadata.name"}},
+		{".items[0] | .metadata | .labels.y", []string{"2"}, []string{"items[0].metadata.labels.y"}},
+		{"items | length", []string{"2"}, []string{""}},
+		{"items[*].metadata | length", []string{"2", "1"}, []string{"", ""}},
+		{"config | keys | [1]", []string{"debug"}, []string{""}},
+		{"config | values | [1]", []string{"true"}, []string{""}},
+		{"config | .keys", []string{"literal"}, []string{"config.keys"}},
+		{"items[*] | metadata.nope // 0", []string{"0", "0"}, []string{"", ""}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var w Walker
+			matches, err := w.Find(doc, tc.pattern)
+			if err != nil {
+				t.Fatalf("pipe(%s) error: %v", tc.pattern, err)
+			}
+			var values, paths []string
+			for _, m := range matches {
+				values = append(values, m.Node.Value)
+				paths = append(paths, FormatPath(m.Path))
+			}
+			if !stringSlicesEqual(values, tc.values) || !stringSlicesEqual(paths, tc.paths) {
+				t.Errorf("pipe(%s) = %q at %q, want %q at %q", tc.pattern, values, paths, tc.values, tc.paths)
+			}
+		})
+	}
+
+	t.Run("computed values are detached", func(t *testing.T) {
+		var w Walker
+		matches, _ := w.Find(doc, "items | length")
+		if !matches[0].Detached {
+			t.Error("length result isn't marked detached")
+		}
+	})
+
+	t.Run("keys of a sequence explains the miss", func(t *testing.T) {
+		var w Walker
+		matches, err := w.Find(doc, "items | keys")
+		if len(matches) != 0 || err == nil || err.Error() != "keys needs a mapping, but items is a sequence" {
+			t.Errorf("pipe(items | keys) = %v, %v; want a needs-a-mapping error", matches, err)
+		}
+	})
+
+	t.Run("empty stage", func(t *testing.T) {
+		if err := Check("items | | keys"); err == nil {
+			t.Error("checkPipe accepted an empty stage")
+		}
+	})
+}
+
+func TestResolveFallback(t *testing.T) {
+	doc := mustParse(t, "overrides:\n  timeout: ~\n  name: custom\ndefaults:\n  timeout: 10\n  name: default\n")
+
+	cases := []struct {
+		pattern string
+		want    string
+		path    string
+	}{
+		{"overrides.name // defaults.name", "custom", "overrides.name"},
+		{"overrides.timeout // defaults.timeout", "10", "defaults.timeout"},
+		{"overrides.retries // defaults.retries // 3", "3", ""},
+		{`overrides.retries // "n/a"`, "n/a", ""},
+		{"overrides.timeout // null", "null", ""},
+		{"overrides.retries // defaults.name", "default", "defaults.name"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			var w Walker
+			matches, err := w.resolve(doc, tc.pattern)
+			if err != nil || len(matches) != 1 {
+				t.Fatalf("resolve(%s) = %v, %v, want a single match", tc.pattern, matches, err)
+			}
+			if got := matches[0].Node.Value; got != tc.want {
+				t.Errorf("resolve(%s) = %q, want %q", tc.pattern, got, tc.want)
+			}
+			if got := FormatPath(matches[0].Path); got != tc.path {
+				t.Errorf("resolve(%s) path = %q, want %q", tc.pattern, got, tc.path)
+			}
+		})
+	}
+
+	t.Run("literal default is typed", func(t *testing.T) {
+		var w Walker
+		matches, _ := w.resolve(doc, "nope // 30")
+		if tag := matches[0].Node.ShortTag(); tag != "!!int" {
+			t.Errorf("literal 30 tag = %s, want !!int", tag)
+		}
+	})
+
+	t.Run("a bare word is a path, not a literal", func(t *testing.T) {
+		var w Walker
+		if matches, _ := w.resolve(doc, "nope // fallback"); len(matches) != 0 {
+			t.Errorf("resolve(nope // fallback) = %v, want no matches", matches)
+		}
+	})
+}
+
+func TestCheckPattern(t *testing.T) {
+	for _, pattern := range []string{"a.b", `."a.b"`, `'x'.y`, "a[0]", ""} {
+		if err := CheckPath(pattern); err != nil {
+			t.Errorf("checkPattern(%q) = %v, want nil", pattern, err)
+		}
+	}
+	for _, pattern := range []string{`."a.b`, `a.'b`, `"x\"`} {
+		if err := CheckPath(pattern); err == nil {
+			t.Errorf("checkPattern(%q) = nil, want an unterminated quote error", pattern)
+		}
+	}
+	if err := CheckPath("jobs.~^(deploy"); err == nil {
+		t.Error("checkPattern(jobs.~^(deploy) = nil, want the regexp compile error")
+	}
+	for pattern, want := range map[string]string{
+		"app.":  "path ends with '.' at offset 3",
+		"app..": "path ends with '..' at offset 3",
+	} {
+		if err := CheckPath(pattern); err == nil || err.Error() != want {
+			t.Errorf("checkPattern(%s) = %v, want %q", pattern, err, want)
+		}
+	}
+	if err := CheckPath("items[::0]"); err == nil || err.Error() != "slice step can't be zero in [::0]" {
+		t.Errorf("checkPattern(items[::0]) = %v, want the zero step error", err)
+	}
+	if err := CheckPath("at[time=1:2:0]"); err != nil {
+		t.Errorf("checkPattern(at[time=1:2:0]) = %v, want nil (a selection, not a slice)", err)
+	}
+}
+
+func TestCheckSyntaxErrors(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{".foo[abc].bar", `invalid index "abc" at offset 5`},
+		{".foo[[0]", "unclosed '[' at offset 4"},
+		{"foo[", "unclosed '[' at offset 3"},
+		{"foo].bar", "stray ']' at offset 3"},
+		{"foo...bar", "empty path segment at offset 5"},
+		{"foo.", "path ends with '.' at offset 3"},
+		{"app..", "path ends with '..' at offset 3"},
+		{"..", "path ends with '..' at offset 0"},
+		{"a.~^x..", "path ends with '..' at offset 5"},
+		{"foo[]", "empty [] at offset 4"},
+		{"foo[1,x]", `invalid index "1,x" at offset 4`},
+		{"foo[1:2:3:4]", `invalid index "1:2:3:4" at offset 4`},
+		{"foo[?(.a]", `invalid filter "?(.a" at offset 4`},
+		{"a.b | c[x]", `invalid index "x" at offset 8`},
+		{"a // b[x] // 3", `invalid index "x" at offset 7`},
+		{`a."b`, "unterminated quote in pattern at offset 2"},
+		{`a.b\`, `path ends with a lone backslash; write \\ for a literal backslash at offset 3`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			err := Check(tc.pattern)
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) || err.Error() != tc.want {
+				t.Errorf("Check(%s) = %v, want the SyntaxError %q", tc.pattern, err, tc.want)
+			}
+		})
+	}
+
+	for _, pattern := range []string{
+		".", "..name", "a..b", ".a[0].b", "a.[0]", "a[*]", "a[first]", "a[-1]", "a[1:]", "a[::-1]", "a[0, 2]",
+		`a["x.y"]`, "a[name=web]", "a[?(@.name == 'x]')]", "a[?(.b[0]==1)]", `a\]`, `"a]"`, "a.~^x[0-9]+$",
+	} {
+		if err := Check(pattern); err != nil {
+			t.Errorf("Check(%s) = %v, want nil", pattern, err)
+		}
+	}
+}
+
+func TestExtractAllFilter(t *testing.T) {
+	doc := mustParse(t, `
+containers:
+  - name: app
+    image: app:1.0
+    readinessProbe: {path: /ready}
+    ports: [80, 443]
+  - name: sidecar
+    image: envoy:1.29
+  - name: "a.b"
+    image: dotted:1
+    ports: [8080]
+`)
+
+	names := func(pattern string) []string {
+		var got []string
+		for _, m := range extractAll(doc, pattern) {
+			got = append(got, m.Node.Value)
+		}
+		return got
+	}
+
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{`containers[?(.name=="sidecar")].image`, []string{"envoy:1.29"}},
+		{`containers[?(.name=='sidecar')].image`, []string{"envoy:1.29"}},
+		{`containers[?(.name==sidecar)].image`, []string{"envoy:1.29"}},
+		{`containers[?(@.name == "sidecar")].image`, []string{"envoy:1.29"}},
+		{`containers[?(.name!="sidecar")].name`, []string{"app", "a.b"}},
+		{`containers[?(.readinessProbe)].name`, []string{"app"}},
+		{`containers[?(.ports)].name`, []string{"app", "a.b"}},
+		{`containers[?(.ports[*]==443)].name`, []string{"app"}},
+		{`containers[?(.name=="a.b")].image`, []string{"dotted:1"}},
+		{`containers[?(.name=="nope")].image`, nil},
+		{`containers[?(.missing!="x")].name`, []string{"app", "sidecar", "a.b"}},
+		{`containers[name=sidecar].image`, []string{"envoy:1.29"}},
+		{`containers[name=a.b].image`, []string{"dotted:1"}},
+		{`containers[image=app:1.0].name`, []string{"app"}},
+		{`containers[name="sidecar"].image`, nil}, // the value is literal
+		{`containers[ports[*]=443].name`, []string{"app"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			if got := names(tc.pattern); !stringSlicesEqual(got, tc.want) {
+				t.Errorf("extractAll(%s) = %v, want %v", tc.pattern, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("filtered elements keep their real index", func(t *testing.T) {
+		matches := extractAll(doc, `containers[?(.name=="a.b")]`)
+		if len(matches) != 1 || FormatPath(matches[0].Path) != "containers[2]" {
+			t.Errorf("extractAll(filter) = %v, want path containers[2]", matches)
+		}
+	})
+
+	t.Run("select on a non-sequence explains the miss", func(t *testing.T) {
+		var w Walker
+		_, err := w.Search(doc, `containers[0][name=app]`)
+		want := "[name=app] selects from a sequence, but containers[0] is a mapping"
+		if err == nil || err.Error() != want {
+			t.Errorf("search error = %v, want %q", err, want)
+		}
+		if _, err := w.Search(doc, `..[name=app]`); err != nil {
+			t.Errorf("search(..[name=app]) error = %v, want none under recursive descent", err)
+		}
+	})
+
+	t.Run("filter on a mapping matches nothing", func(t *testing.T) {
+		if got := names(`containers[0][?(.name)]`); got != nil {
+			t.Errorf("filter on a mapping = %v, want no matches", got)
+		}
+	})
+}
+
+func TestPaths(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"a.b[0]", []string{"a.b[0]"}},
+		{"a // b.c // 30", []string{"a", "b.c"}},
+		{"a // b", []string{"a", "b"}},
+		{"items[*] | metadata.name", []string{"items[*]", "metadata.name"}},
+		{"spec | keys", []string{"spec"}},
+		{"~^(a|b)$", []string{"~^(a|b)$"}},
+	}
+	for _, tc := range cases {
+		if got := Paths(tc.pattern); !stringSlicesEqual(got, tc.want) {
+			t.Errorf("Paths(%q) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestWalkerLimit(t *testing.T) {
+	root := mustParse(t, "a:\n  name: 1\n  b:\n    name: 2\nc:\n  - name: 3\n  - {}\n  - name: 4\nd:\n  name: 5\n")
+	paths := func(matches []Match) []string {
+		var paths []string
+		for _, m := range matches {
+			paths = append(paths, FormatPath(m.Path))
+		}
+		return paths
+	}
+
+	// Matches come in document order, so a limit keeps the first ones
+	all := []string{"a.name", "a.b.name", "c[0].name", "c[2].name", "d.name"}
+	for limit := 0; limit <= len(all)+1; limit++ {
+		w := Walker{Limit: limit}
+		matches, err := w.Find(root, "..name")
+		want := all
+		if limit > 0 && limit < len(all) {
+			want = all[:limit]
+		}
+		if err != nil || !stringSlicesEqual(paths(matches), want) {
+			t.Errorf("Limit %d: ..name = %q (%v), want %q", limit, paths(matches), err, want)
+		}
+	}
+
+	cases := []struct {
+		pattern string
+		limit   int
+		want    []string
+	}{
+		{"*", 2, []string{"a", "c"}},
+		{"c[*]", 2, []string{"c[0]", "c[1]"}},
+		{"**", 3, []string{"a.name", "a.b.name", "c[0].name"}},
+		// The limit is on the last stage of a pipe, which skips c[1]
+		{"c[*] | name", 2, []string{"c[0].name", "c[2].name"}},
+	}
+	for _, tc := range cases {
+		w := Walker{Limit: tc.limit}
+		matches, _ := w.Find(root, tc.pattern)
+		if got := paths(matches); !stringSlicesEqual(got, tc.want) {
+			t.Errorf("Limit %d: %s = %q, want %q", tc.limit, tc.pattern, got, tc.want)
+		}
+		if w.Limit != tc.limit {
+			t.Errorf("Find(%s) left Limit at %d, want %d", tc.pattern, w.Limit, tc.limit)
+		}
+	}
+
+	t.Run("a node reached twice counts once", func(t *testing.T) {
+		// ..a..name finds a.a.name from both of the a's above it
+		nested := mustParse(t, "a:\n  a:\n    name: 1\nx:\n  a:\n    name: 2\n")
+		w := Walker{Limit: 2}
+		matches, _ := w.Find(nested, "..a..name")
+		if got := paths(matches); !stringSlicesEqual(got, []string{"a.a.name", "x.a.name"}) {
+			t.Errorf("..a..name = %q, want [a.a.name x.a.name]", got)
+		}
+	})
+}
+
+func TestWalkerTrace(t *testing.T) {
+	root := mustParse(t, "users:\n  - name: bob\n    email: b@x\n  - name: amy\n")
+	var trace strings.Builder
+	w := Walker{Trace: &trace}
+	if _, err := w.Find(root, "users[name=amy].email"); err == nil {
+		t.Fatal("users[name=amy].email found something, want a miss")
+	}
+	want := "key users at the document root (a mapping)\n" +
+		"  filter [name=amy] at users (a sequence)\n" +
+		"    key email at users[1] (a mapping)\n" +
+		"    no match: users[1] has no key email\n"
+	if trace.String() != want {
+		t.Errorf("trace =\n%s\nwant:\n%s", trace.String(), want)
+	}
+
+	trace.Reset()
+	w.Find(root, "users[0:1]")
+	want = "key users at the document root (a mapping)\n" +
+		"  slice [0:1] at users (a sequence)\n" +
+		"  match at users (a sequence)\n"
+	if trace.String() != want {
+		t.Errorf("trace =\n%s\nwant:\n%s", trace.String(), want)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		path     string
+		optional bool
+	}{
+		{"spec.strategy?", "spec.strategy", true},
+		{"spec.strategy", "spec.strategy", false},
+		{"items[0]?", "items[0]", true},
+		{"node?", "node", true},
+		{"node??", "node?", true},
+		{`why\?`, `why\?`, false},
+		{`why\\?`, `why\\`, true},
+		{`"why?"`, `"why?"`, false},
+		{"a // b ?", "a // b", true},
+		{"?", "", true},
+	}
+	for _, tc := range cases {
+		path, optional := Optional(tc.pattern)
+		if path != tc.path || optional != tc.optional {
+			t.Errorf("Optional(%q) = %q, %v; want %q, %v", tc.pattern, path, optional, tc.path, tc.optional)
+		}
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	cases := []struct {
+		parts []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b"}, "a.b"},
+		{[]string{"users", "[0]", "name"}, "users[0].name"},
+		{[]string{"[0]", "[1]"}, "[0][1]"},
+		{[]string{`"a.b"`, "c"}, `"a.b".c`},
+	}
+
+	for _, tc := range cases {
+		if got := FormatPath(tc.parts); got != tc.want {
+			t.Errorf("formatPath(%q) = %q, want %q", tc.parts, got, tc.want)
+		}
+	}
+
+	t.Run("round-trips through extractAll", func(t *testing.T) {
+		root := mustParse(t, sampleYAML+"labels:\n  app.kubernetes.io/name: web\n  \"*\": star\n"+
+			"odd:\n  \"[x]\": 1\n  \"~re\": 2\n  \"a,b\": 3\n  \"a|b\": 4\n  \"a//b\": 5\n  \"^\": 6\n  \"&anc\": 7\n  \"back\\\\slash\": 8\n  \"q\\\"uote\": 9\n  \"\": 10\n")
+		for _, m := range extractAll(root, "..*") {
+			again := extractAll(root, FormatPath(m.Path))
+			if len(again) != 1 || again[0].Node != m.Node {
+				t.Errorf("formatPath(%v) = %q does not lead back to the same node", m.Path, FormatPath(m.Path))
+			}
+		}
+	})
+}
+
+func TestWrapMatches(t *testing.T) {
+	root := mustParse(t, sampleYAML)
+
+	t.Run("wildcard matches share their rebuilt ancestors", func(t *testing.T) {
+		wrapped := Wrap(root, extractAll(root, "*.port"))
+		got := marshal(t, wrapped)
+		want := "database:\n    port: 5432\n"
+		if got != want {
+			t.Errorf("wrapMatches(*.port) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("sibling matches are rebuilt side by side in document order", func(t *testing.T) {
+		wrapped := Wrap(root, extractAll(root, "database.credentials.*"))
+		got := marshal(t, wrapped)
+		want := "database:\n    credentials:\n        user: admin\n        password: secret\n"
+		if got != want {
+			t.Errorf("wrapMatches(database.credentials.*) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("[*] matches are rebuilt as one sequence in order", func(t *testing.T) {
+		wrapped := Wrap(root, extractAll(root, "services[*].port"))
+		got := marshal(t, wrapped)
+		want := "services:\n    - port: 8080\n    - port: 3000\n"
+		if got != want {
+			t.Errorf("wrapMatches(services[*].port) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("several matches inside one element share it", func(t *testing.T) {
+		wrapped := Wrap(root, extractAll(root, "services[*].*"))
+		got := marshal(t, wrapped)
+		want := "services:\n    - name: web\n      port: 8080\n    - name: api\n      port: 3000\n"
+		if got != want {
+			t.Errorf("wrapMatches(services[*].*) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("literal star key is rebuilt unescaped", func(t *testing.T) {
+		doc := mustParse(t, "globs:\n  \"*\": everything\n")
+		got := marshal(t, Wrap(doc, extractAll(doc, `globs.\*`)))
+		want := "globs:\n    \"*\": everything\n"
+		if got != want {
+			t.Errorf("wrapMatches(globs.\\*) =\n%q\nwant:\n%q", got, want)
+		}
+	})
+}
+
+func TestWrapNullFill(t *testing.T) {
+	doc := mustParse(t, "hosts: [a, b, c, d]\nservices:\n  - name: web\n  - name: api\n  - name: db\n")
+	tests := []struct {
+		pattern, want string
+	}{
+		{"hosts[2]", "hosts: [null, null, c]\n"},
+		{"hosts[0]", "hosts: [a]\n"},
+		{"services[2].name", "services:\n    - null\n    - null\n    - name: db\n"},
+		{"services[*].name", "services:\n    - name: web\n    - name: api\n    - name: db\n"},
+		// A slice is printed as the elements it selects, padded or not
+		{"hosts[1:3]", "hosts: [b, c]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := marshal(t, WrapNullFill(doc, extractAll(doc, tt.pattern))); got != tt.want {
+				t.Errorf("WrapNullFill(%s) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+
+	// Without it the same matches are never padded
+	if got, want := marshal(t, Wrap(doc, extractAll(doc, "services[2].name"))), "services:\n    - name: db\n"; got != want {
+		t.Errorf("Wrap(services[2].name) = %q, want %q", got, want)
+	}
+}
+
+func TestWrapKeepsCustomTags(t *testing.T) {
+	doc := mustParse(t, "a: !If [x, !Ref y, z]\nb: !Custom\n  c: 1\n  d: !!str 2\ne: !!map {f: 3}\n")
+	tests := []struct {
+		pattern, want string
+	}{
+		{"a[1]", "a: !If [!Ref y]\n"},
+		{"b.c", "b: !Custom\n    c: 1\n"},
+		{"b.d", "b: !Custom\n    d: !!str 2\n"},
+		// A standard tag is the encoder's to write or not
+		{"e.f", "e: {f: 3}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := marshal(t, Wrap(doc, extractAll(doc, tt.pattern))); got != tt.want {
+				t.Errorf("Wrap(%s) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeadingDot(t *testing.T) {
+	// With or without a leading dot, a path splits, matches and wraps the
+	// same, whether it starts with a key or an index
+	for _, tt := range []struct {
+		src, bare string
+		parts     []string
+		want      string
+	}{
+		{"items:\n  - name: x\n  - name: y\n", "items[0].name", []string{"items", "[0]", "name"}, "items:\n    - name: x\n"},
+		{"- name: a\n- name: b\n", "[0]", []string{"[0]"}, "- name: a\n"},
+		{"- name: a\n- name: b\n", "[1].name", []string{"[1]", "name"}, "- name: b\n"},
+	} {
+		doc := mustParse(t, tt.src)
+		for _, pattern := range []string{tt.bare, "." + tt.bare} {
+			t.Run(pattern, func(t *testing.T) {
+				if got := SplitPath(pattern); !stringSlicesEqual(got, tt.parts) {
+					t.Errorf("SplitPath(%s) = %q, want %q", pattern, got, tt.parts)
+				}
+				if got, want := extractPath(doc, pattern), extractPath(doc, tt.bare); got == nil || got != want {
+					t.Errorf("extractPath(%s) = %v, want the node %s finds", pattern, got, tt.bare)
+				}
+				if got := wrap(t, doc, pattern); got != tt.want {
+					t.Errorf("Wrap(%s) = %q, want %q", pattern, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// wrap is what the command prints for pattern: its matches in doc,
+// wrapped back in their ancestry.
+func wrap(t *testing.T, doc *yaml.Node, pattern string) string {
+	t.Helper()
+	return marshal(t, Wrap(doc, extractAll(doc, pattern)))
+}
+
+func TestWrap(t *testing.T) {
+	root := mustParse(t, sampleYAML)
+
+	t.Run("wraps scalar back in its mapping ancestry", func(t *testing.T) {
+		want := "app:\n    name: MyApp\n"
+		if got := wrap(t, root, "app.name"); got != want {
+			t.Errorf("Wrap(app.name) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("array index reconstruction drops the index, keeps only the match", func(t *testing.T) {
+		// services[1] is "api", but Wrap doesn't reconstruct services[0]
+		// (unknown value) - it emits a single-element array with just the
+		// match rather than fabricating a `null` placeholder for the skipped
+		// index, which would misrepresent the source document.
+		want := "services:\n    - name: api\n"
+		if got := wrap(t, root, "services[1].name"); got != want {
+			t.Errorf("Wrap(services[1].name) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("negative index wraps only the resolved element", func(t *testing.T) {
+		want := "services:\n    - name: api\n"
+		if got := wrap(t, root, "services[-1].name"); got != want {
+			t.Errorf("Wrap(services[-1].name) =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("[first] and [last] wrap only the resolved element", func(t *testing.T) {
+		for pattern, want := range map[string]string{
+			"services[last].name":  "services:\n    - name: api\n",
+			"services[first].name": "services:\n    - name: web\n",
+		} {
+			if got := wrap(t, root, pattern); got != want {
+				t.Errorf("Wrap(%s) =\n%s\nwant:\n%s", pattern, got, want)
+			}
+		}
+	})
+
+	t.Run("bracketed string keys are rebuilt as plain keys", func(t *testing.T) {
+		doc := mustParse(t, "weird key: 1\nmetadata:\n  \"a.b/c d\": 2\n")
+		for pattern, want := range map[string]string{
+			`.["weird key"]`:       "weird key: 1\n",
+			`.metadata['a.b/c d']`: "metadata:\n    \"a.b/c d\": 2\n",
+		} {
+			if got := wrap(t, doc, pattern); got != want {
+				t.Errorf("Wrap(%s) = %q, want %q", pattern, got, want)
+			}
+		}
+	})
+
+	t.Run("reconstructed ancestors inherit the source's flow style", func(t *testing.T) {
+		// JSON is valid YAML flow syntax, and yaml.v3 records that per-node
+		// (Node.Style). Wrapping used to always fabricate block-style
+		// wrapper nodes, so extracting from a JSON source reverted to block
+		// YAML. It should now look up the original node at each ancestor
+		// path and match its style, so JSON in stays JSON-shaped out.
+		jsonRoot := mustParse(t, `{"database": {"host": "localhost", "tags": ["a", "b"]}}`)
+		want := "{\"database\": {\"host\": \"localhost\"}}\n"
+		if got := wrap(t, jsonRoot, "database.host"); got != want {
+			t.Errorf("Wrap(database.host) on JSON source =\n%q\nwant:\n%q", got, want)
+		}
+	})
+
+	t.Run("reconstructed array ancestor inherits flow style", func(t *testing.T) {
+		jsonRoot := mustParse(t, `{"database": {"tags": ["a", "b"]}}`)
+		want := "{\"database\": {\"tags\": [\"b\"]}}\n"
+		if got := wrap(t, jsonRoot, "database.tags[1]"); got != want {
+			t.Errorf("Wrap(database.tags[1]) on JSON source =\n%q\nwant:\n%q", got, want)
+		}
+	})
+
+	t.Run("block-style source still reconstructs as block style", func(t *testing.T) {
+		// Regression check: the style-inheritance lookup shouldn't change
+		// behavior for ordinary block YAML, which is the common case.
+		want := "app:\n    name: MyApp\n"
+		if got := wrap(t, root, "app.name"); got != want {
+			t.Errorf("Wrap(app.name) on block source =\n%q\nwant:\n%q", got, want)
+		}
+	})
+}
+
+func TestExtractPathHandlesYAMLAnchorsAndAliases(t *testing.T) {
+	doc := mustParse(t, `
+defaults: &defaults
+  pool: 5
+  host: localhost
+production:
+  <<: *defaults
+  pool: 25
+regions: &regions
+  - us-east-1
+  - us-west-2
+deployment:
+  active_regions: *regions
+`)
+
+	t.Run("merged keys are navigable, and the mapping's own keys win", func(t *testing.T) {
+		if got := extractPath(doc, "production.host"); got == nil || got.Value != "localhost" {
+			t.Errorf("extractPath(production.host) = %v, want localhost", got)
+		}
+		if got := extractPath(doc, "production.pool"); got == nil || got.Value != "25" {
+			t.Errorf("extractPath(production.pool) = %v, want 25", got)
+		}
+		var keys []string
+		for _, m := range extractAll(doc, "production.*") {
+			keys = append(keys, FormatPath(m.Path))
+		}
+		if want := []string{"production.host", "production.pool"}; !stringSlicesEqual(keys, want) {
+			t.Errorf("extractAll(production.*) paths = %q, want %q", keys, want)
+		}
+	})
+
+	t.Run("the merge key itself is left in the output", func(t *testing.T) {
+		out := marshal(t, extractPath(doc, "production"))
+		if !strings.Contains(out, "<<: *defaults") {
+			t.Errorf("expected the merge key in output, got:\n%s", out)
+		}
+	})
+
+	t.Run("noMerge matches the merge key as a plain entry", func(t *testing.T) {
+		w := Walker{NoMerge: true}
+		if got, _ := w.Search(doc, "production.host"); len(got) != 0 {
+			t.Errorf("search(production.host) with noMerge = %v, want no matches", got)
+		}
+		if got, _ := w.Search(doc, "production.<<.host"); len(got) != 1 {
+			t.Errorf("search(production.<<.host) with noMerge = %v, want one match", got)
+		}
+	})
+
+	t.Run("an alias is followed to the node it refers to", func(t *testing.T) {
+		if got := extractPath(doc, "deployment.active_regions[1]"); got == nil || got.Value != "us-west-2" {
+			t.Errorf("extractPath(deployment.active_regions[1]) = %v, want us-west-2", got)
+		}
+		out := marshal(t, extractPath(doc, "deployment.active_regions"))
+		if want := "- us-east-1\n- us-west-2\n"; out != want {
+			t.Errorf("extractPath(deployment.active_regions) =\n%s\nwant the anchored sequence without its anchor:\n%s", out, want)
+		}
+	})
+
+	t.Run("merges and aliases that contain themselves don't loop", func(t *testing.T) {
+		doc := mustParse(t, "a: &a\n  x: 1\n  <<: *a\n  self: *a\n")
+		if got := extractPath(doc, "a.self.self.x"); got == nil || got.Value != "1" {
+			t.Errorf("extractPath(a.self.self.x) = %v, want 1", got)
+		}
+		if got := extractAll(doc, "a.*"); len(got) != 2 {
+			t.Errorf("extractAll(a.*) = %v, want x and self", got)
+		}
+		if got := extractAll(doc, "..x"); len(got) != 1 {
+			t.Errorf("extractAll(..x) = %v, want the one x", got)
+		}
+	})
+
+	t.Run("&name continues from the anchored node at its real path", func(t *testing.T) {
+		matches := extractAll(doc, "&defaults.pool")
+		if len(matches) != 1 || matches[0].Node.Value != "5" || FormatPath(matches[0].Path) != "defaults.pool" {
+			t.Errorf("extractAll(&defaults.pool) = %v, want 5 at defaults.pool", matches)
+		}
+	})
+
+	t.Run("&* matches every anchored node, not the aliases", func(t *testing.T) {
+		var paths []string
+		for _, m := range extractAll(doc, "&*") {
+			paths = append(paths, FormatPath(m.Path))
+		}
+		if want := []string{"defaults", "regions"}; !stringSlicesEqual(paths, want) {
+			t.Errorf("extractAll(&*) paths = %q, want %q", paths, want)
+		}
+	})
+
+	t.Run("an unknown anchor matches nothing", func(t *testing.T) {
+		if got := extractAll(doc, "&nope"); len(got) != 0 {
+			t.Errorf("extractAll(&nope) = %v, want no matches", got)
+		}
+	})
+
+	t.Run("an anchor defined twice is an error", func(t *testing.T) {
+		var w Walker
+		_, err := w.Search(mustParse(t, "a: &x 1\nb: &x 2\n"), "&x")
+		if err == nil || err.Error() != "anchor &x is defined more than once (lines 1 and 2)" {
+			t.Errorf("search(&x) error = %v, want a defined-twice error", err)
+		}
+	})
+}